@@ -76,7 +76,7 @@ new_session;
 SELECT session.*, account.refresh_token, account.provider_id FROM "public"."session" session
 INNER JOIN public.user  ON session.user_id = "user".id
 INNER JOIN public.account account ON session.account_id = account.id
-WHERE session.token = $1
+WHERE session.token = $1 AND "user".disabled_at IS NULL
 LIMIT 1;
 
 
@@ -109,6 +109,17 @@ SELECT * FROM "public"."user"
 WHERE id = $1
 LIMIT 1;
 
+-- name: GetUserByEmail :one
+SELECT * FROM "public"."user"
+WHERE email = $1
+LIMIT 1;
+
+-- name: UpdateSessionAuthenticatedAt :execrows
+UPDATE "public"."session"
+SET "authenticated_at" = sqlc.arg('authenticated_at'),
+    "updated_at" = NOW()
+WHERE id = sqlc.arg('id');
+
 -- name: GetUserSessions :many
 SELECT * FROM "public"."session"
 WHERE "user_id" = $1;
@@ -128,6 +139,109 @@ DELETE FROM "public"."user"
 WHERE id = $1
 RETURNING id;
 
+-- name: DeleteUserSessions :execrows
+DELETE FROM "public"."session"
+WHERE "user_id" = $1;
+
+-- name: GetUserAccounts :many
+SELECT * FROM "public"."account"
+WHERE "user_id" = $1
+ORDER BY created_at;
+
+-- name: ScheduleUserDeletion :one
+UPDATE "public"."user"
+SET "disabled_at" = sqlc.arg('disabled_at'),
+    "deletion_scheduled_at" = sqlc.arg('deletion_scheduled_at')
+WHERE id = sqlc.arg('id') AND "deletion_scheduled_at" IS NULL
+RETURNING id;
+
+-- name: CancelUserDeletion :execrows
+UPDATE "public"."user"
+SET "disabled_at" = NULL,
+    "deletion_scheduled_at" = NULL
+WHERE id = $1 AND "deletion_scheduled_at" IS NOT NULL;
+
+-- name: GetUsersDueForDeletion :many
+SELECT id FROM "public"."user"
+WHERE "deletion_scheduled_at" <= sqlc.arg('now')
+ORDER BY "deletion_scheduled_at"
+LIMIT sqlc.arg('limit');
+
+-- name: PurgeUser :one
+DELETE FROM "public"."user"
+WHERE id = sqlc.arg('id') AND "deletion_scheduled_at" <= sqlc.arg('now')
+RETURNING id;
+
+
+-- name: CreateAuditEvent :exec
+INSERT INTO "public"."audit_event"
+("user_id", "type", "ip_address", "user_agent", "metadata")
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: GetUserAuditEvents :many
+SELECT * FROM "public"."audit_event"
+WHERE "user_id" = $1
+ORDER BY created_at;
+
+
+-- name: AddToWaitlist :exec
+INSERT INTO "public"."waitlist"
+("email", "name", "provider")
+VALUES ($1, $2, $3)
+ON CONFLICT(email) DO UPDATE SET name = $2, provider = $3;
+
+-- name: CreateOAuthTransaction :exec
+INSERT INTO "public"."oauth_transaction"
+("state", "code_verifier", "nonce", "provider", "redirect_url", "created_at", "expires_at", "reauth_session_id")
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: ConsumeOAuthTransaction :one
+DELETE FROM "public"."oauth_transaction"
+WHERE "state" = sqlc.arg('state') AND "expires_at" > sqlc.arg('now')
+RETURNING *;
+
+-- name: DeleteExpiredOAuthTransactions :execrows
+DELETE FROM "public"."oauth_transaction"
+WHERE "expires_at" <= sqlc.arg('now');
+
+
+-- name: GetCacheEntry :one
+SELECT "value" FROM "public"."cache_entry"
+WHERE "key" = sqlc.arg('key') AND "expires_at" > sqlc.arg('now');
+
+-- name: PutCacheEntry :exec
+INSERT INTO "public"."cache_entry" ("key", "value", "expires_at")
+VALUES ($1, $2, $3)
+ON CONFLICT("key") DO UPDATE SET "value" = EXCLUDED."value", "expires_at" = EXCLUDED."expires_at";
+
+-- name: DeleteCacheEntry :exec
+DELETE FROM "public"."cache_entry"
+WHERE "key" = $1;
+
+-- name: DeleteExpiredCacheEntries :execrows
+DELETE FROM "public"."cache_entry"
+WHERE "expires_at" <= sqlc.arg('now');
+
+-- name: LockRateLimit :one
+-- Returns the state and locks the row until the transaction ends. A missing
+-- key is inserted as an already expired placeholder so it can be locked too.
+INSERT INTO "public"."rate_limit" ("key", "value", "expires_at")
+VALUES (sqlc.arg('key'), 'null', sqlc.arg('now'))
+ON CONFLICT("key") DO UPDATE SET "key" = EXCLUDED."key"
+RETURNING "value", "expires_at";
+
+-- name: SetRateLimit :exec
+UPDATE "public"."rate_limit"
+SET "value" = sqlc.arg('value'), "expires_at" = sqlc.arg('expires_at')
+WHERE "key" = sqlc.arg('key');
+
+-- name: DeleteExpiredRateLimits :execrows
+DELETE FROM "public"."rate_limit"
+WHERE "expires_at" <= sqlc.arg('now');
+
+-- name: GetRegisteredClientByOrigin :one
+SELECT * FROM "public"."registered_client"
+WHERE "origin" = $1 AND "revoked_at" IS NULL;
 
 
 -- name: TestDatabaseConnection :one
@@ -27,6 +27,7 @@ CREATE TABLE "session" (
     "user_agent" TEXT,
     "user_id" TEXT NOT NULL,
     "account_id" TEXT,
+    "authenticated_at" TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
 
     CONSTRAINT "session_pkey" PRIMARY KEY ("id")
 );
@@ -40,6 +41,8 @@ CREATE TABLE "user" (
     "image" TEXT,
     "created_at" TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
     "updated_at" TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "disabled_at" TIMESTAMP(6),
+    "deletion_scheduled_at" TIMESTAMP(6),
 
     CONSTRAINT "user_pkey" PRIMARY KEY ("id")
 );
@@ -56,6 +59,55 @@ CREATE TABLE "verification" (
     CONSTRAINT "verification_pkey" PRIMARY KEY ("id")
 );
 
+-- CreateTable
+CREATE TABLE "audit_event" (
+    "id" TEXT NOT NULL DEFAULT gen_random_uuid(),
+    "user_id" TEXT NOT NULL,
+    "type" TEXT NOT NULL,
+    "ip_address" TEXT,
+    "user_agent" TEXT,
+    "metadata" JSONB,
+    "created_at" TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "audit_event_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateTable
+CREATE TABLE "waitlist" (
+    "id" TEXT NOT NULL DEFAULT gen_random_uuid(),
+    "email" TEXT NOT NULL,
+    "name" TEXT NOT NULL,
+    "provider" TEXT NOT NULL,
+    "created_at" TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT "waitlist_pkey" PRIMARY KEY ("id")
+);
+
+-- CreateTable
+CREATE TABLE "oauth_transaction" (
+    "state" TEXT NOT NULL,
+    "code_verifier" TEXT NOT NULL,
+    "nonce" TEXT NOT NULL,
+    "provider" TEXT NOT NULL,
+    "redirect_url" TEXT NOT NULL,
+    "created_at" TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "expires_at" TIMESTAMP(6) NOT NULL,
+    "reauth_session_id" TEXT,
+
+    CONSTRAINT "oauth_transaction_pkey" PRIMARY KEY ("state")
+);
+
+-- CreateTable
+CREATE TABLE "registered_client" (
+    "id" TEXT NOT NULL DEFAULT gen_random_uuid(),
+    "name" TEXT NOT NULL,
+    "origin" TEXT NOT NULL,
+    "created_at" TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "revoked_at" TIMESTAMP(6),
+
+    CONSTRAINT "registered_client_pkey" PRIMARY KEY ("id")
+);
+
 -- CreateTable
 CREATE TABLE "authors" (
     "id" SERIAL NOT NULL,
@@ -65,6 +117,24 @@ CREATE TABLE "authors" (
     CONSTRAINT "authors_pkey" PRIMARY KEY ("id")
 );
 
+-- CreateTable
+CREATE TABLE "cache_entry" (
+    "key" TEXT NOT NULL,
+    "value" JSONB NOT NULL,
+    "expires_at" TIMESTAMP(6) NOT NULL,
+
+    CONSTRAINT "cache_entry_pkey" PRIMARY KEY ("key")
+);
+
+-- CreateTable
+CREATE TABLE "rate_limit" (
+    "key" TEXT NOT NULL,
+    "value" JSONB NOT NULL,
+    "expires_at" TIMESTAMP(6) NOT NULL,
+
+    CONSTRAINT "rate_limit_pkey" PRIMARY KEY ("key")
+);
+
 -- CreateIndex
 CREATE UNIQUE INDEX "account_account_id_unique" ON "account"("account_id");
 
@@ -74,6 +144,24 @@ CREATE UNIQUE INDEX "session_token_unique" ON "session"("token");
 -- CreateIndex
 CREATE UNIQUE INDEX "user_email_unique" ON "user"("email");
 
+-- CreateIndex
+CREATE INDEX "audit_event_user_id_idx" ON "audit_event"("user_id");
+
+-- CreateIndex
+CREATE UNIQUE INDEX "waitlist_email_unique" ON "waitlist"("email");
+
+-- CreateIndex
+CREATE INDEX "oauth_transaction_expires_at_idx" ON "oauth_transaction"("expires_at");
+
+-- CreateIndex
+CREATE UNIQUE INDEX "registered_client_origin_unique" ON "registered_client"("origin");
+
+-- CreateIndex
+CREATE INDEX "cache_entry_expires_at_idx" ON "cache_entry"("expires_at");
+
+-- CreateIndex
+CREATE INDEX "rate_limit_expires_at_idx" ON "rate_limit"("expires_at");
+
 -- AddForeignKey
 ALTER TABLE "account" ADD CONSTRAINT "account_user_id_user_id_fk" FOREIGN KEY ("user_id") REFERENCES "user"("id") ON DELETE CASCADE ON UPDATE NO ACTION;
 
@@ -83,3 +171,10 @@ ALTER TABLE "session" ADD CONSTRAINT "session_user_id_user_id_fk" FOREIGN KEY ("
 -- AddForeignKey
 ALTER TABLE "session" ADD CONSTRAINT "session_account_id_account_id_fk" FOREIGN KEY ("account_id") REFERENCES "account"("id") ON DELETE CASCADE ON UPDATE NO ACTION;
 
+-- AddForeignKey
+ALTER TABLE "audit_event" ADD CONSTRAINT "audit_event_user_id_user_id_fk" FOREIGN KEY ("user_id") REFERENCES "user"("id") ON DELETE CASCADE ON UPDATE NO ACTION;
+
+-- Applied after prisma db push, which always creates logged tables. Cache and
+-- rate limit state is disposable, so these tables skip the WAL.
+ALTER TABLE "cache_entry" SET UNLOGGED;
+ALTER TABLE "rate_limit" SET UNLOGGED;
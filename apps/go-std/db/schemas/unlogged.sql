@@ -0,0 +1,4 @@
+-- Applied after prisma db push, which always creates logged tables. Cache and
+-- rate limit state is disposable, so these tables skip the WAL.
+ALTER TABLE "cache_entry" SET UNLOGGED;
+ALTER TABLE "rate_limit" SET UNLOGGED;
@@ -0,0 +1,373 @@
+package auth
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-std/internal/config"
+	"go-std/internal/sqlc"
+	"go-std/internal/utils"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	accountDeletionGracePeriod = time.Hour * 24 * 30
+	accountPurgeInterval       = time.Hour
+	accountPurgeBatchSize      = 100
+	redacted                   = "[redacted]"
+)
+
+// Audit event types
+const (
+	AuditSignUp            = "user.signup"
+	AuditLogin             = "user.login"
+	AuditLogout            = "user.logout"
+	AuditReauthenticated   = "user.reauthenticated"
+	AuditDataExported      = "user.data_exported"
+	AuditDeletionScheduled = "user.deletion_scheduled"
+	AuditDeletionCancelled = "user.deletion_cancelled"
+)
+
+// accountDurations reads ACCOUNT_DELETION_GRACE_PERIOD and ACCOUNT_PURGE_INTERVAL
+// as Go durations, e.g. "720h"
+func accountDurations(app *config.App) (grace time.Duration, interval time.Duration, err error) {
+	grace, interval = accountDeletionGracePeriod, accountPurgeInterval
+	if v := app.Env.GetString("ACCOUNT_DELETION_GRACE_PERIOD"); v != "" {
+		if grace, err = time.ParseDuration(v); err != nil || grace < 0 {
+			return 0, 0, fmt.Errorf("invalid ACCOUNT_DELETION_GRACE_PERIOD %q", v)
+		}
+	}
+	if v := app.Env.GetString("ACCOUNT_PURGE_INTERVAL"); v != "" {
+		if interval, err = time.ParseDuration(v); err != nil || interval <= 0 {
+			return 0, 0, fmt.Errorf("invalid ACCOUNT_PURGE_INTERVAL %q", v)
+		}
+	}
+	return grace, interval, nil
+}
+
+// recordAuditEvent stores an event in the user's audit trail
+func recordAuditEvent(ctx context.Context, q *sqlc.Queries, r *http.Request, userID string, eventType string, metadata map[string]interface{}) error {
+	var data []byte
+	if len(metadata) > 0 {
+		var err error
+		if data, err = json.Marshal(metadata); err != nil {
+			return err
+		}
+	}
+	return q.CreateAuditEvent(ctx, sqlc.CreateAuditEventParams{
+		UserID:    userID,
+		Type:      eventType,
+		IpAddress: pgtype.Text{String: utils.ClientIP(r), Valid: true},
+		UserAgent: pgtype.Text{String: r.UserAgent(), Valid: true},
+		Metadata:  data,
+	})
+}
+
+// currentSession returns the session of the request's session cookie
+func (a *AuthHandlers) currentSession(r *http.Request) (sqlc.GetSessionByTokenRow, error) {
+	token, err := a.SessionCookie.Read(r)
+	if err != nil || token == "" {
+		return sqlc.GetSessionByTokenRow{}, pgx.ErrNoRows
+	}
+	return a.Queries.GetSessionByToken(r.Context(), token)
+}
+
+// DeleteUserHandler schedules the current user for deletion. The account is
+// disabled and signed out everywhere right away and purged once the grace
+// period has passed. Logging in again before then cancels the deletion.
+func (a *AuthHandlers) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	session, err := a.currentSession(r)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusUnauthorized, "Unauthorized", "UNAUTHORIZED")
+		return
+	}
+
+	now := time.Now().UTC()
+	purgeAt := now.Add(a.AccountDeletionGracePeriod)
+
+	err = pgx.BeginFunc(ctx, a.DB, func(tx pgx.Tx) error {
+		qtx := a.Queries.WithTx(tx)
+		if _, err := qtx.ScheduleUserDeletion(ctx, sqlc.ScheduleUserDeletionParams{
+			ID:                  session.UserID,
+			DisabledAt:          pgtype.Timestamp{Time: now, Valid: true},
+			DeletionScheduledAt: pgtype.Timestamp{Time: purgeAt, Valid: true},
+		}); err != nil {
+			return err
+		}
+
+		sessions, err := qtx.GetUserSessions(ctx, session.UserID)
+		if err != nil {
+			return err
+		}
+		if _, err := qtx.DeleteUserSessions(ctx, session.UserID); err != nil {
+			return err
+		}
+		for _, s := range sessions {
+			if err := a.Hooks.OnSessionRevoked(ctx, qtx, s.ID, s.UserID); err != nil {
+				return err
+			}
+		}
+
+		return recordAuditEvent(ctx, qtx, r, session.UserID, AuditDeletionScheduled, map[string]interface{}{
+			"purge_at": purgeAt,
+		})
+	})
+	if writeAuthError(w, err) {
+		return
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ErrorResponse(w, http.StatusBadRequest, "user not found", "BAD_REQUEST")
+		return
+	}
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "error deleting user", "INTERNAL_SERVER_ERROR")
+		logger.Error("error scheduling user deletion: %v", err)
+		return
+	}
+
+	a.SessionCookie.Remove(w)
+	utils.SuccessResponse(w, map[string]interface{}{
+		"status":   "deletion_scheduled",
+		"purge_at": purgeAt,
+	})
+}
+
+// cancelScheduledDeletion re-enables a user that is pending deletion
+func cancelScheduledDeletion(ctx context.Context, q *sqlc.Queries, r *http.Request, user sqlc.User) error {
+	if !user.DeletionScheduledAt.Valid {
+		return nil
+	}
+	cancelled, err := q.CancelUserDeletion(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("error cancelling user deletion: %w", err)
+	}
+	if cancelled == 0 {
+		return nil
+	}
+	logger.Info("cancelled scheduled deletion of user %s", user.ID)
+	return recordAuditEvent(ctx, q, r, user.ID, AuditDeletionCancelled, nil)
+}
+
+// PurgeDeletedUsers permanently deletes users whose grace period has ended
+// and returns how many were deleted
+func (a *AuthHandlers) PurgeDeletedUsers(ctx context.Context) (int, error) {
+	now := pgtype.Timestamp{Time: time.Now().UTC(), Valid: true}
+
+	ids, err := a.Queries.GetUsersDueForDeletion(ctx, sqlc.GetUsersDueForDeletionParams{
+		Now:   now,
+		Limit: accountPurgeBatchSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, id := range ids {
+		err := pgx.BeginFunc(ctx, a.DB, func(tx pgx.Tx) error {
+			qtx := a.Queries.WithTx(tx)
+			// Only deletes if the deletion was not cancelled in the meantime
+			if _, err := qtx.PurgeUser(ctx, sqlc.PurgeUserParams{ID: id, Now: now}); err != nil {
+				return err
+			}
+			return a.Hooks.OnUserDeleted(ctx, qtx, id)
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			logger.Error("error purging user %s: %v", id, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// RunAccountPurger calls PurgeDeletedUsers every AccountPurgeInterval until ctx is done
+func (a *AuthHandlers) RunAccountPurger(ctx context.Context) {
+	ticker := time.NewTicker(a.AccountPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := a.PurgeDeletedUsers(ctx)
+			if err != nil {
+				logger.Error("error purging deleted users: %v", err)
+				continue
+			}
+			if purged > 0 {
+				logger.Info("purged %d deleted users", purged)
+			}
+		}
+	}
+}
+
+// exportedAuditEvent is an audit event with its metadata inlined as JSON
+type exportedAuditEvent struct {
+	ID        string           `json:"id"`
+	Type      string           `json:"type"`
+	IpAddress pgtype.Text      `json:"ip_address"`
+	UserAgent pgtype.Text      `json:"user_agent"`
+	Metadata  json.RawMessage  `json:"metadata,omitempty"`
+	CreatedAt pgtype.Timestamp `json:"created_at"`
+}
+
+// dataExport is everything stored about a user. Credentials are redacted.
+type dataExport struct {
+	ExportedAt  time.Time            `json:"exported_at"`
+	User        sqlc.User            `json:"user"`
+	Accounts    []sqlc.Account       `json:"accounts"`
+	Sessions    []sqlc.Session       `json:"sessions"`
+	AuditEvents []exportedAuditEvent `json:"audit_events"`
+}
+
+func redactText(t pgtype.Text) pgtype.Text {
+	if !t.Valid {
+		return t
+	}
+	return pgtype.Text{String: redacted, Valid: true}
+}
+
+func (a *AuthHandlers) buildDataExport(ctx context.Context, userID string) (*dataExport, error) {
+	q := a.Queries
+
+	user, err := q.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+	accounts, err := q.GetUserAccounts(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting accounts: %w", err)
+	}
+	sessions, err := q.GetUserSessions(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting sessions: %w", err)
+	}
+	events, err := q.GetUserAuditEvents(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting audit events: %w", err)
+	}
+
+	export := &dataExport{
+		ExportedAt:  time.Now().UTC(),
+		User:        user,
+		Accounts:    make([]sqlc.Account, 0, len(accounts)),
+		Sessions:    make([]sqlc.Session, 0, len(sessions)),
+		AuditEvents: make([]exportedAuditEvent, 0, len(events)),
+	}
+	for _, acc := range accounts {
+		acc.AccessToken = redactText(acc.AccessToken)
+		acc.RefreshToken = redactText(acc.RefreshToken)
+		acc.IDToken = redactText(acc.IDToken)
+		acc.Password = redactText(acc.Password)
+		export.Accounts = append(export.Accounts, acc)
+	}
+	for _, s := range sessions {
+		s.Token = redacted
+		export.Sessions = append(export.Sessions, s)
+	}
+	for _, e := range events {
+		export.AuditEvents = append(export.AuditEvents, exportedAuditEvent{
+			ID:        e.ID,
+			Type:      e.Type,
+			IpAddress: e.IpAddress,
+			UserAgent: e.UserAgent,
+			Metadata:  e.Metadata,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+	return export, nil
+}
+
+// ExportUserDataHandler lets the current user download their data as JSON or,
+// with ?format=zip, as a ZIP archive with one JSON file per section
+func (a *AuthHandlers) ExportUserDataHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "zip" {
+		utils.ErrorResponse(w, http.StatusBadRequest, "format must be json or zip", "BAD_REQUEST")
+		return
+	}
+
+	session, err := a.currentSession(r)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusUnauthorized, "Unauthorized", "UNAUTHORIZED")
+		return
+	}
+
+	export, err := a.buildDataExport(ctx, session.UserID)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "error exporting user data", "INTERNAL_SERVER_ERROR")
+		logger.Error("error exporting user data: %v", err)
+		return
+	}
+
+	if err := recordAuditEvent(ctx, a.Queries, r, session.UserID, AuditDataExported, map[string]interface{}{
+		"format": format,
+	}); err != nil {
+		logger.Error("error recording audit event: %v", err)
+	}
+
+	filename := "user-data-" + export.ExportedAt.Format("20060102T150405Z")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(export); err != nil {
+			logger.Error("error writing user data export: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+	if err := writeExportZip(w, export); err != nil {
+		logger.Error("error writing user data export: %v", err)
+	}
+}
+
+func writeExportZip(w http.ResponseWriter, export *dataExport) error {
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.User},
+		{"accounts.json", export.Accounts},
+		{"sessions.json", export.Sessions},
+		{"audit_events.json", export.AuditEvents},
+	}
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: export.ExportedAt,
+		})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
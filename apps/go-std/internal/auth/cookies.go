@@ -0,0 +1,21 @@
+package auth
+
+import (
+	"fmt"
+	"go-std/internal/config"
+	"go-std/internal/utils"
+)
+
+// NewCookieCodec builds the cookie codec from COOKIE_KEYS ("id:secret,..." with
+// the signing key first) and COOKIE_MODE ("encrypted" or "signed")
+func NewCookieCodec(app *config.App) (*utils.CookieCodec, error) {
+	keys, err := app.CookieKeys()
+	if err != nil {
+		return nil, err
+	}
+	mode, err := utils.ParseCookieMode(app.Env.GetString("COOKIE_MODE"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid COOKIE_MODE: %w", err)
+	}
+	return utils.NewCookieCodec(keys, mode), nil
+}
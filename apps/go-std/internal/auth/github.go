@@ -20,39 +20,34 @@ const (
 
 type GitHubProvider struct {
 	config ProviderConfig
+	client *http.Client
 }
 
-func NewGitHubProvider(app *config.App) (OAuthProvider, error) {
-	clientID := app.Env.GetString("GITHUB_CLIENT_ID")
-	clientSecret := app.Env.GetString("GITHUB_CLIENT_SECRET")
-	redirectURI := app.Env.GetString("GITHUB_REDIRECT_URI")
-
-	// Validate required fields
-	if clientID == "" {
-		return nil, fmt.Errorf("GITHUB_CLIENT_ID is required")
-	}
-	if clientSecret == "" {
-		return nil, fmt.Errorf("GITHUB_CLIENT_SECRET is required")
+func NewGitHubProvider(app *config.App, client *http.Client) (OAuthProvider, error) {
+	var cfg struct {
+		ClientID     string `env:"GITHUB_CLIENT_ID" required:"true"`
+		ClientSecret string `env:"GITHUB_CLIENT_SECRET" required:"true"`
+		RedirectURI  string `env:"GITHUB_REDIRECT_URI" required:"true" validate:"url"`
 	}
-	if redirectURI == "" {
-		return nil, fmt.Errorf("GITHUB_REDIRECT_URI is required")
+	if err := app.Env.Bind(&cfg); err != nil {
+		return nil, err
 	}
 
 	config := ProviderConfig{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		RedirectURI:  redirectURI,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURI:  cfg.RedirectURI,
 		Scopes:       []string{"user:email", "read:user"},
 	}
 
-	return &GitHubProvider{config: config}, nil
+	return &GitHubProvider{config: config, client: client}, nil
 }
 
 func (p *GitHubProvider) GetProviderName() string {
 	return "github"
 }
 
-func (p *GitHubProvider) CreateAuthorizationURL(state string, codeVerifier string) (*url.URL, error) {
+func (p *GitHubProvider) CreateAuthorizationURL(state string, codeVerifier string, opts AuthorizationOptions) (*url.URL, error) {
 	queryParams := url.Values{
 		"client_id":     {p.config.ClientID},
 		"redirect_uri":  {p.config.RedirectURI},
@@ -182,7 +177,7 @@ func (p *GitHubProvider) makeTokenRequest(endpoint string, data url.Values) ([]b
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("User-Agent", "miles-creative")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -205,7 +200,7 @@ func (p *GitHubProvider) makeAPIRequest(endpoint string, accessToken string) ([]
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
 	req.Header.Set("User-Agent", "miles-creative")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -18,39 +19,34 @@ const (
 
 type GoogleProvider struct {
 	config ProviderConfig
+	client *http.Client
 }
 
-func NewGoogleProvider(app *config.App) (OAuthProvider, error) {
-	clientID := app.Env.GetString("GOOGLE_CLIENT_ID")
-	clientSecret := app.Env.GetString("GOOGLE_CLIENT_SECRET")
-	redirectURI := app.Env.GetString("GOOGLE_REDIRECT_URI")
-
-	// Validate required fields
-	if clientID == "" {
-		return nil, fmt.Errorf("GOOGLE_CLIENT_ID is required")
-	}
-	if clientSecret == "" {
-		return nil, fmt.Errorf("GOOGLE_CLIENT_SECRET is required")
+func NewGoogleProvider(app *config.App, client *http.Client) (OAuthProvider, error) {
+	var cfg struct {
+		ClientID     string `env:"GOOGLE_CLIENT_ID" required:"true"`
+		ClientSecret string `env:"GOOGLE_CLIENT_SECRET" required:"true"`
+		RedirectURI  string `env:"GOOGLE_REDIRECT_URI" required:"true" validate:"url"`
 	}
-	if redirectURI == "" {
-		return nil, fmt.Errorf("GOOGLE_REDIRECT_URI is required")
+	if err := app.Env.Bind(&cfg); err != nil {
+		return nil, err
 	}
 
 	config := ProviderConfig{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		RedirectURI:  redirectURI,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURI:  cfg.RedirectURI,
 		Scopes:       []string{"email", "profile"},
 	}
 
-	return &GoogleProvider{config: config}, nil
+	return &GoogleProvider{config: config, client: client}, nil
 }
 
 func (p *GoogleProvider) GetProviderName() string {
 	return "google"
 }
 
-func (p *GoogleProvider) CreateAuthorizationURL(state string, codeVerifier string) (*url.URL, error) {
+func (p *GoogleProvider) CreateAuthorizationURL(state string, codeVerifier string, opts AuthorizationOptions) (*url.URL, error) {
 
 	var queryParams url.Values = url.Values{
 		"response_type":         {"code"},
@@ -65,6 +61,26 @@ func (p *GoogleProvider) CreateAuthorizationURL(state string, codeVerifier strin
 	if p.config.RedirectURI != "" {
 		queryParams.Set("redirect_uri", p.config.RedirectURI)
 	}
+	if opts.Nonce != "" {
+		queryParams.Set("nonce", opts.Nonce)
+	}
+	if opts.HostedDomain != "" {
+		queryParams.Set("hd", opts.HostedDomain)
+	}
+	switch opts.Prompt {
+	case "", "login":
+		// Google rejects prompt=login, re-authentication is forced with
+		// max_age and checked against auth_time instead
+	default:
+		queryParams.Set("prompt", opts.Prompt+" consent")
+	}
+	if opts.Prompt == "login" {
+		// auth_time must be after the login started, which only a fresh
+		// sign-in guarantees. max_age also makes Google return auth_time.
+		queryParams.Set("max_age", "0")
+	} else if opts.MaxAge > 0 {
+		queryParams.Set("max_age", strconv.Itoa(opts.MaxAge))
+	}
 
 	authURL, err := url.Parse(googleAuthEndpoint)
 	if err != nil {
@@ -75,6 +91,11 @@ func (p *GoogleProvider) CreateAuthorizationURL(state string, codeVerifier strin
 	return authURL, nil
 }
 
+// SupportsReauthentication reports that Google ID tokens carry auth_time
+func (p *GoogleProvider) SupportsReauthentication() bool {
+	return true
+}
+
 func (p *GoogleProvider) ValidateAuthorizationCode(code string, codeVerifier string) (*utils.OAuth2Tokens, error) {
 
 	var queryParams url.Values = url.Values{
@@ -149,12 +170,15 @@ func (p *GoogleProvider) GetUserInfo(tokens *utils.OAuth2Tokens) (UserInfo, erro
 		return UserInfo{}, fmt.Errorf("failed to decode ID token: %w", err)
 	}
 
+	hostedDomain, _ := claims["hd"].(string)
+
 	return UserInfo{
 		ID:            claims["sub"].(string),
 		Email:         claims["email"].(string),
 		Name:          claims["name"].(string),
 		Picture:       claims["picture"].(string),
 		EmailVerified: claims["email_verified"].(bool),
+		HostedDomain:  hostedDomain,
 	}, nil
 }
 
@@ -185,7 +209,7 @@ func (p *GoogleProvider) authFetch(endpoint string, queryParams url.Values) ([]b
 		req.Header.Set("Authorization", "Basic "+encodedCredentials)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
@@ -2,17 +2,23 @@ package auth
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go-std/internal/config"
 	"go-std/internal/utils"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
 
 	"strings"
 	"time"
 
 	"go-std/internal/sqlc"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
@@ -20,76 +26,142 @@ var logger = utils.NewLogger(utils.DEBUG, true)
 
 type AuthHandlers struct {
 	*config.App
-	SessionCookieName           string
-	SessionExpiration           time.Duration
-	AuthRedirectQueryParam      string
-	AuthRedirectCookieName      string
-	AuthRedirectDefault         string
-	OAuthStateCookieName        string
-	OAuthCodeVerifierCookieName string
-	UserSessionQueryParam       string
-	ProviderRegistry            *ProviderRegistry
+	SessionCookie          utils.CookiePolicy
+	CSRFCookie             utils.CookiePolicy
+	OAuthCookie            utils.CookiePolicy
+	SessionExpiration      time.Duration
+	AuthRedirectQueryParam string
+	AuthRedirectDefault    string
+	UserSessionQueryParam  string
+	ProviderRegistry       *ProviderRegistry
+	RedirectPolicy         *utils.RedirectPolicy
+	TransactionStore       OAuthTransactionStore
+	CookieCodec            *utils.CookieCodec
+	CSRFSigner             *utils.CSRFSigner
+	SignUpPolicy           *SignUpPolicy
+	Hooks                  Hooks
+	// AccountDeletionGracePeriod is how long a deleted account can still be
+	// restored by logging in before it is purged
+	AccountDeletionGracePeriod time.Duration
+	AccountPurgeInterval       time.Duration
 }
 
 const (
-	sessionCookieName           = "session_token"
-	sessionExpiration           = time.Hour * 24 * 30
-	authRedirectQueryParam      = "redirect_url"
-	authRedirectCookieName      = "auth_redirect_url"
-	authRedirectDefault         = "/"
-	oauthStateCookieName        = "oauth_state"
-	oauthCodeVerifierCookieName = "oauth_code_verifier"
-	userSessionQueryParam       = "user_session"
+	sessionExpiration      = time.Hour * 24 * 30
+	authRedirectQueryParam = "redirect_url"
+	authRedirectDefault    = "/"
+	userSessionQueryParam  = "user_session"
 )
 
-func NewAuthHandlers(app *config.App) (*AuthHandlers, error) {
-	registry, err := NewProviderRegistry(app)
+func NewAuthHandlers(app *config.App, opts ...Option) (*AuthHandlers, error) {
+	redirectPolicy, err := NewRedirectPolicy(app)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create provider registry: %w", err)
-	}
-
-	return &AuthHandlers{
-		App:                         app,
-		SessionCookieName:           sessionCookieName,
-		SessionExpiration:           sessionExpiration,
-		AuthRedirectQueryParam:      authRedirectQueryParam,
-		AuthRedirectCookieName:      authRedirectCookieName,
-		AuthRedirectDefault:         authRedirectDefault,
-		OAuthStateCookieName:        oauthStateCookieName,
-		OAuthCodeVerifierCookieName: oauthCodeVerifierCookieName,
-		UserSessionQueryParam:       userSessionQueryParam,
-		ProviderRegistry:            registry,
-	}, nil
+		return nil, fmt.Errorf("failed to create redirect policy: %w", err)
+	}
+
+	transactionStore, err := NewOAuthTransactionStore(app)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create oauth transaction store: %w", err)
+	}
+
+	cookieCodec, err := NewCookieCodec(app)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cookie codec: %w", err)
+	}
+
+	csrfKeys, err := app.CSRFKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CSRF signer: %w", err)
+	}
+
+	signUpPolicy, err := NewSignUpPolicy(app)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sign-up policy: %w", err)
+	}
+
+	gracePeriod, purgeInterval, err := accountDurations(app)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &AuthHandlers{
+		App:                    app,
+		SessionCookie:          app.SessionCookiePolicy(),
+		CSRFCookie:             app.CSRFCookiePolicy(),
+		OAuthCookie:            app.OAuthCookiePolicy(),
+		SessionExpiration:      sessionExpiration,
+		AuthRedirectQueryParam: authRedirectQueryParam,
+		AuthRedirectDefault:    authRedirectDefault,
+		UserSessionQueryParam:  userSessionQueryParam,
+		RedirectPolicy:         redirectPolicy,
+		TransactionStore:       transactionStore,
+		CookieCodec:            cookieCodec,
+		CSRFSigner:             utils.NewCSRFSigner(csrfKeys),
+		SignUpPolicy:           signUpPolicy,
+		Hooks:                  NoopHooks{},
+
+		AccountDeletionGracePeriod: gracePeriod,
+		AccountPurgeInterval:       purgeInterval,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	// Route sets built from one registry share its circuit breakers
+	if a.ProviderRegistry == nil {
+		if a.ProviderRegistry, err = NewProviderRegistry(app); err != nil {
+			return nil, fmt.Errorf("failed to create provider registry: %w", err)
+		}
+	}
+
+	return a, nil
+}
+
+// Close stops the background work of the handlers' stores
+func (a *AuthHandlers) Close() error {
+	if closer, ok := a.TransactionStore.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
 
 func (a *AuthHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	provider := r.PathValue("provider")
-	if provider == "" {
-		provider = "google" // default provider
-	}
 
-	q := a.Queries
 	redirectURL := r.URL.Query().Get(a.AuthRedirectQueryParam)
 	if redirectURL == "" {
 		redirectURL = a.AuthRedirectDefault
 	}
 
-	// check if user is already logged in
-	valid_session, _ := utils.ValidateSession(q, w, r)
-	if valid_session {
-		utils.Redirect(w, r, redirectURL)
+	redirectURL, err := a.RedirectPolicy.Normalize(redirectURL)
+	if err != nil {
+		logger.Warn("Rejected login redirect: %v", err)
+		utils.ErrorResponse(w, http.StatusBadRequest, err.Error(), "INVALID_REDIRECT")
 		return
 	}
 
-	if redirectURL != "" {
-		cookie := &http.Cookie{
-			Name:   a.AuthRedirectCookieName,
-			Value:  redirectURL,
-			Path:   "/",
-			MaxAge: 3600,
+	authOptions, reauth, err := reauthOptions(r)
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusBadRequest, err.Error(), "BAD_REQUEST")
+		return
+	}
+
+	// check if user is already logged in. A re-authentication keeps the
+	// session and, unless told otherwise, its provider
+	var reauthSessionID string
+	if session, err := a.currentSession(r); err == nil {
+		if !reauth {
+			a.RedirectPolicy.Redirect(w, r, redirectURL)
+			return
+		}
+		reauthSessionID = session.ID
+		if provider == "" {
+			provider = session.ProviderID
 		}
-		http.SetCookie(w, cookie)
+	}
+	if provider == "" {
+		provider = "google" // default provider
 	}
 
 	state, err := utils.GenerateState()
@@ -108,6 +180,13 @@ func (a *AuthHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// logger.Debug("codeVerifier: %s", codeVerifier)
 
+	nonce, err := utils.GenerateRandomStringNoPadding()
+	if err != nil {
+		logger.Error("Error generating nonce: %v", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Error generating nonce", "INTERNAL_SERVER_ERROR")
+		return
+	}
+
 	// Create OAuth provider
 	oauthProvider, err := a.ProviderRegistry.CreateProvider(provider)
 	if err != nil {
@@ -116,42 +195,46 @@ func (a *AuthHandlers) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authURL, err := oauthProvider.CreateAuthorizationURL(state, codeVerifier)
+	// Fail fast instead of sending the user to a provider that cannot finish the login
+	if err := a.ProviderRegistry.CheckAvailable(oauthProvider.GetProviderName()); err != nil {
+		a.providerUnavailableResponse(w, oauthProvider.GetProviderName())
+		return
+	}
+
+	if reauthSessionID != "" && !supportsReauthentication(oauthProvider) {
+		utils.ErrorResponse(w, http.StatusBadRequest, "This provider cannot confirm a new sign-in, please use another provider", "REAUTH_UNSUPPORTED")
+		return
+	}
+
+	authOptions.Nonce = nonce
+	authOptions.HostedDomain = a.SignUpPolicy.HostedDomain
+	authURL, err := oauthProvider.CreateAuthorizationURL(state, codeVerifier, authOptions)
 	if err != nil {
 		logger.Error("Error creating authorization URL: %v", err)
 		utils.ErrorResponse(w, http.StatusInternalServerError, "Error creating authorization URL", "INTERNAL_SERVER_ERROR")
 		return
 	}
 
-	isDev := a.IsDev
-
-	cookies := []*http.Cookie{{
-		Name:     a.OAuthStateCookieName,
-		Value:    state,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   !isDev,
-		SameSite: http.SameSiteLaxMode,
-	},
-		{
-			Name:     a.OAuthCodeVerifierCookieName,
-			Value:    codeVerifier,
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   !isDev,
-			SameSite: http.SameSiteLaxMode,
-		},
-		{
-			Name:     "oauth_provider",
-			Value:    provider,
-			Path:     "/",
-			HttpOnly: true,
-			Secure:   !isDev,
-			SameSite: http.SameSiteLaxMode,
-		},
-	}
-	for _, c := range cookies {
-		http.SetCookie(w, c)
+	err = a.TransactionStore.Save(r.Context(), &OAuthTransaction{
+		State:           state,
+		CodeVerifier:    codeVerifier,
+		Nonce:           nonce,
+		Provider:        oauthProvider.GetProviderName(),
+		RedirectURL:     redirectURL,
+		CreatedAt:       time.Now(),
+		ReauthSessionID: reauthSessionID,
+	})
+	if err != nil {
+		logger.Error("Error saving oauth transaction: %v", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Error starting login", "INTERNAL_SERVER_ERROR")
+		return
+	}
+
+	// Bind the transaction to this browser
+	if err := utils.SetSecureCookie(w, a.CookieCodec, a.OAuthCookie, state); err != nil {
+		logger.Error("Error setting oauth transaction cookie: %v", err)
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Error starting login", "INTERNAL_SERVER_ERROR")
+		return
 	}
 
 	// logger.Debug("login creation success- redirecting to: %s", authURL)
@@ -165,68 +248,88 @@ func (a *AuthHandlers) CallbackHandler(w http.ResponseWriter, r *http.Request) {
 	code := r.URL.Query().Get("code")
 	state := r.URL.Query().Get("state")
 
-	q := a.Queries
-	storedState, err := r.Cookie(a.OAuthStateCookieName)
-	if err != nil {
-		utils.ErrorResponse(w, http.StatusBadRequest, "Error getting oauth_state. Please restart", "BAD_REQUEST")
-		return
-	}
-	storedCodeVerifier, err := r.Cookie(a.OAuthCodeVerifierCookieName)
-	if err != nil {
-		utils.ErrorResponse(w, http.StatusBadRequest, "Error getting oauth_code_verifier. Please restart", "BAD_REQUEST")
+	boundState, err := utils.ReadSecureCookie(r, a.CookieCodec, a.OAuthCookie)
+	if errors.Is(err, http.ErrNoCookie) {
+		utils.ErrorResponse(w, http.StatusBadRequest, "Error getting oauth transaction. Please restart", "BAD_REQUEST")
 		return
 	}
+	a.OAuthCookie.Remove(w)
 
-	provider := r.PathValue("provider")
-	if provider == "" {
-		provider = r.URL.Query().Get("provider")
-	}
-	if provider == "" {
-		providerCookie, err := r.Cookie("oauth_provider")
-		if err != nil {
-			utils.ErrorResponse(w, http.StatusBadRequest, "Error getting oauth_provider. Please restart", "BAD_REQUEST")
-			return
+	// An expired cookie or one signed with a rotated out key is no sign of an
+	// attack, only a cookie that decoded and mismatched counts towards bans
+	if err != nil || subtle.ConstantTimeCompare([]byte(boundState), []byte(state)) != 1 {
+		if err == nil {
+			a.IPBans.RecordFailure(utils.ClientIP(r), "state_mismatch")
 		}
-		provider = providerCookie.Value
+		utils.ErrorResponse(w, http.StatusBadRequest, "state mismatch- please restart", "BAD_REQUEST")
+		return
 	}
 
-	if provider == "" {
-		utils.ErrorResponse(w, http.StatusBadRequest, "Error getting provider. Please restart", "BAD_REQUEST")
+	// Consume the transaction so the same state can never be replayed. A
+	// consumed state is usually the back button or a double click, so it does
+	// not count towards bans.
+	tx, err := a.TransactionStore.Consume(r.Context(), state)
+	if err != nil {
+		if !errors.Is(err, ErrTransactionNotFound) {
+			logger.Error("Error consuming oauth transaction: %v", err)
+		}
+		utils.ErrorResponse(w, http.StatusBadRequest, "Login expired or already used. Please restart", "BAD_REQUEST")
 		return
 	}
 
-	if storedState.Value != state {
-		utils.ErrorResponse(w, http.StatusBadRequest, "state mismatch- please restart", "BAD_REQUEST")
+	provider := r.PathValue("provider")
+	if provider != "" && provider != tx.Provider {
+		a.IPBans.RecordFailure(utils.ClientIP(r), "provider_mismatch")
+		utils.ErrorResponse(w, http.StatusBadRequest, "provider mismatch- please restart", "BAD_REQUEST")
 		return
 	}
+
 	// Create OAuth provider
-	oauthProvider, err := a.ProviderRegistry.CreateProvider(provider)
+	oauthProvider, err := a.ProviderRegistry.CreateProvider(tx.Provider)
 	if err != nil {
 		logger.Error("Error creating OAuth provider: %v", err)
 		utils.ErrorResponse(w, http.StatusBadRequest, "Unsupported provider", "BAD_REQUEST")
 		return
 	}
 
-	tokens, err := oauthProvider.ValidateAuthorizationCode(code, storedCodeVerifier.Value)
+	tokens, err := oauthProvider.ValidateAuthorizationCode(code, tx.CodeVerifier)
+	if errors.Is(err, ErrProviderUnavailable) {
+		logger.Error("Error validating authorization code: %v", err)
+		a.providerUnavailableResponse(w, tx.Provider)
+		return
+	}
 	if err != nil {
+		a.IPBans.RecordFailure(utils.ClientIP(r), "invalid_code")
 		utils.ErrorResponse(w, http.StatusBadRequest, "Error validating authorization code. Please restart", "BAD_REQUEST")
 		logger.Error("Error validating authorization code: %v", err)
 		return
 	}
 
-	// Clean up cookies
-	utils.RemoveCookie(w, a.OAuthStateCookieName)
-	utils.RemoveCookie(w, a.OAuthCodeVerifierCookieName)
-	utils.RemoveCookie(w, "oauth_provider")
+	if err := verifyNonce(tokens, tx.Nonce); err != nil {
+		a.IPBans.RecordFailure(utils.ClientIP(r), "invalid_nonce")
+		utils.ErrorResponse(w, http.StatusBadRequest, "Invalid ID token. Please restart", "BAD_REQUEST")
+		logger.Error("Error verifying nonce: %v", err)
+		return
+	}
 
 	// Get user info from provider
 	userInfo, err := oauthProvider.GetUserInfo(tokens)
+	if errors.Is(err, ErrProviderUnavailable) {
+		logger.Error("Error getting user info: %v", err)
+		a.providerUnavailableResponse(w, tx.Provider)
+		return
+	}
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusBadRequest, "Error getting user info", "BAD_REQUEST")
 		logger.Error("Error getting user info: %v", err)
 		return
 	}
 
+	if tx.ReauthSessionID != "" {
+		a.completeReauth(w, r, tx, tokens, userInfo)
+		return
+	}
+
 	token_result, err := tokens.GetTokenResult()
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusBadRequest, "Error getting token result", "BAD_REQUEST")
@@ -234,9 +337,6 @@ func (a *AuthHandlers) CallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ip_address := r.RemoteAddr
-	user_agent := r.UserAgent()
-
 	session_token, err := utils.GenerateSessionToken()
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusBadRequest, "Error generating session token", "BAD_REQUEST")
@@ -244,45 +344,20 @@ func (a *AuthHandlers) CallbackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, thisErr := q.CreateNewUser(context.Background(), sqlc.CreateNewUserParams{
-		Name:                 userInfo.Name,
-		Email:                userInfo.Email,
-		EmailVerified:        userInfo.EmailVerified,
-		Image:                pgtype.Text{String: userInfo.Picture, Valid: true},
-		AccountID:            userInfo.ID,
-		ProviderID:           oauthProvider.GetProviderName(),
-		Scope:                pgtype.Text{String: strings.Join(token_result.Scopes, " "), Valid: true},
-		AccessToken:          pgtype.Text{String: token_result.AccessToken, Valid: true},
-		RefreshToken:         pgtype.Text{String: token_result.RefreshToken, Valid: true},
-		IDToken:              pgtype.Text{String: token_result.IDToken, Valid: true},
-		ExpiresAt:            pgtype.Timestamp{Time: time.Now().Add(time.Hour * 24 * 30), Valid: true},
-		Token:                session_token,
-		IpAddress:            pgtype.Text{String: ip_address, Valid: true},
-		UserAgent:            pgtype.Text{String: user_agent, Valid: true},
-		AccessTokenExpiresAt: pgtype.Timestamp{Time: token_result.AccessTokenExpiresAt, Valid: true},
-	})
-
+	thisErr := a.createUserSession(r, oauthProvider.GetProviderName(), userInfo, token_result, session_token)
 	if thisErr != nil {
+		if writeAuthError(w, thisErr) {
+			return
+		}
 		logger.Error("Error creating new user: %v", thisErr)
 		utils.ErrorResponse(w, http.StatusInternalServerError, "Error creating new user", "INTERNAL_SERVER_ERROR")
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    sessionCookieName,
-		Value:   session_token,
-		Path:    "/",
-		Expires: time.Now().Add(sessionExpiration),
-	})
+	a.SessionCookie.Set(w, session_token)
 
-	redirectURL, err := r.Cookie(authRedirectCookieName)
-	if err != nil {
-		logger.Error("Error getting auth_redirect_url: %v", err)
-	}
-
-	if redirectURL != nil && redirectURL.Value != "" {
-		utils.RemoveCookie(w, authRedirectCookieName)
-		utils.Redirect(w, r, redirectURL.Value)
+	if tx.RedirectURL != "" {
+		a.RedirectPolicy.Redirect(w, r, tx.RedirectURL)
 		return
 	} else {
 		utils.Redirect(w, r, authRedirectDefault)
@@ -291,18 +366,50 @@ func (a *AuthHandlers) CallbackHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// providerUnavailableResponse tells the user to come back later while the
+// provider's circuit breaker is open
+func (a *AuthHandlers) providerUnavailableResponse(w http.ResponseWriter, provider string) {
+	retryAfter := int(math.Ceil(a.ProviderRegistry.RetryAfter(provider).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	utils.ErrorResponse(w, http.StatusServiceUnavailable,
+		"Signing in with "+provider+" is temporarily unavailable. Please try again in a few minutes.",
+		"PROVIDER_UNAVAILABLE")
+}
+
 func (a *AuthHandlers) ValidateSessionHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debug("ValidateSessionHandler")
 	q := a.Queries
 
-	valid_session, err := utils.ValidateSession(q, w, r)
+	valid_session, err := utils.ValidateSession(q, w, r, a.SessionCookie)
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusInternalServerError, "error validating session", "INTERNAL_SERVER_ERROR")
 		logger.Error("error validating session: %v", err)
 		return
 	}
 	if valid_session {
-		utils.SuccessResponse(w, "session valid")
+		token, _ := a.SessionCookie.Read(r)
+		session, err := q.GetSessionByToken(r.Context(), token)
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusInternalServerError, "error validating session", "INTERNAL_SERVER_ERROR")
+			logger.Error("error getting session: %v", err)
+			return
+		}
+
+		data := map[string]interface{}{}
+		if err := a.Hooks.EnrichSession(r.Context(), session, data); err != nil {
+			if writeAuthError(w, err) {
+				return
+			}
+			utils.ErrorResponse(w, http.StatusInternalServerError, "error validating session", "INTERNAL_SERVER_ERROR")
+			logger.Error("error enriching session: %v", err)
+			return
+		}
+		if len(data) == 0 {
+			utils.SuccessResponse(w, "session valid")
+			return
+		}
+		data["session"] = "valid"
+		utils.SuccessResponse(w, data)
 		return
 	}
 
@@ -314,15 +421,43 @@ func (a *AuthHandlers) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 
 	q := a.Queries
 
-	cookie, _ := r.Cookie(sessionCookieName)
-	if cookie != nil {
-		_, err := q.DeleteSession(context.Background(), cookie.Value)
-		if err != nil {
+	token, _ := a.SessionCookie.Read(r)
+	if token != "" {
+		ctx := r.Context()
+		session, err := q.GetSessionByToken(ctx, token)
+		found := err == nil
+		if found {
+			if err := a.Hooks.BeforeLogout(ctx, q, session); err != nil {
+				if writeAuthError(w, err) {
+					return
+				}
+				utils.ErrorResponse(w, http.StatusInternalServerError, "error logging out", "INTERNAL_SERVER_ERROR")
+				logger.Error("error in logout hook: %v", err)
+				return
+			}
+		}
+
+		err = pgx.BeginFunc(ctx, a.DB, func(tx pgx.Tx) error {
+			qtx := q.WithTx(tx)
+			sessionID, err := qtx.DeleteSession(ctx, token)
+			if err != nil {
+				return err
+			}
+			// Without the session there is no user to audit or pass to hooks
+			if !found {
+				return nil
+			}
+			if err := recordAuditEvent(ctx, qtx, r, session.UserID, AuditLogout, nil); err != nil {
+				return err
+			}
+			return a.Hooks.OnSessionRevoked(ctx, qtx, sessionID, session.UserID)
+		})
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 			utils.ErrorResponse(w, http.StatusInternalServerError, "error logging out", "INTERNAL_SERVER_ERROR")
 			logger.Error("error logging out: %v", err)
 			return
 		}
-		utils.RemoveCookie(w, sessionCookieName)
+		a.SessionCookie.Remove(w)
 	}
 
 	utils.SuccessResponse(w, "session logged out")
@@ -331,14 +466,14 @@ func (a *AuthHandlers) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 func (a *AuthHandlers) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
 	q := a.Queries
 
-	cookie, err := r.Cookie(sessionCookieName)
+	token, err := a.SessionCookie.Read(r)
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusBadRequest, "error getting session cookie", "BAD_REQUEST")
 		logger.Error("error getting session cookie: %v", err)
 		return
 	}
 
-	session, err := q.GetSessionByToken(context.Background(), cookie.Value)
+	session, err := q.GetSessionByToken(context.Background(), token)
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusBadRequest, "error getting session", "BAD_REQUEST")
 		logger.Error("error getting session: %v", err)
@@ -370,6 +505,11 @@ func (a *AuthHandlers) RefreshTokenHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	tokens, err := oauthProvider.RefreshAccessToken(refreshToken)
+	if errors.Is(err, ErrProviderUnavailable) {
+		logger.Error("error refreshing access token: %v", err)
+		a.providerUnavailableResponse(w, providerName)
+		return
+	}
 	if err != nil {
 		// Handle provider-specific errors (like GitHub not supporting refresh)
 		if providerName == "github" {
@@ -462,33 +602,33 @@ func (a *AuthHandlers) UpdateUserHandler(w http.ResponseWriter, r *http.Request)
 	utils.SuccessResponse(w, "user updated")
 }
 
-func (a *AuthHandlers) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
-
-	q := a.Queries
-
-	user_id := r.URL.Query().Get(userSessionQueryParam)
-	id, err := q.DeleteUser(context.Background(), user_id)
-	if id == "" {
-		utils.ErrorResponse(w, http.StatusBadRequest, "user not found", "BAD_REQUEST")
-		return
-	}
+func (a *AuthHandlers) GetCSRFTokenHandler(w http.ResponseWriter, r *http.Request) {
+	sessionToken, err := a.SessionCookie.Read(r)
 	if err != nil {
-		utils.ErrorResponse(w, http.StatusInternalServerError, "error deleting user", "INTERNAL_SERVER_ERROR")
-		logger.Error("error deleting user: %v", err)
+		utils.ErrorResponse(w, http.StatusUnauthorized, "Session required", "UNAUTHORIZED")
 		return
 	}
-	utils.SuccessResponse(w, "user deleted")
+
+	a.CSRFSigner.SetToken(w, a.CSRFCookie, sessionToken)
+	utils.SuccessResponse(w, "CSRF token set")
 }
 
-func (a *AuthHandlers) GetCSRFTokenHandler(w http.ResponseWriter, r *http.Request) {
-	sessionCookie, err := r.Cookie("session_token")
+// GetCSRFTokenJSONHandler is GetCSRFTokenHandler for SPAs on other origins,
+// which cannot read the X-CSRF-Token header unless CORS exposes it
+func (a *AuthHandlers) GetCSRFTokenJSONHandler(w http.ResponseWriter, r *http.Request) {
+	sessionToken, err := a.SessionCookie.Read(r)
 	if err != nil {
 		utils.ErrorResponse(w, http.StatusUnauthorized, "Session required", "UNAUTHORIZED")
 		return
 	}
 
-	utils.SetCSRFToken(w, sessionCookie.Value)
-	utils.SuccessResponse(w, "CSRF token set")
+	token := a.CSRFSigner.SetToken(w, a.CSRFCookie, sessionToken)
+	w.Header().Set("Cache-Control", "no-store")
+	utils.SuccessResponse(w, map[string]string{
+		"token":      token,
+		"header":     utils.CsrfHeaderName,
+		"form_field": utils.CsrfFormField,
+	})
 }
 
 func (a *AuthHandlers) TestFormHandler(w http.ResponseWriter, r *http.Request) {
@@ -515,3 +655,93 @@ func (a *AuthHandlers) TestFormHandler(w http.ResponseWriter, r *http.Request) {
 	// Return the form data in the response
 	utils.SuccessResponse(w, formData)
 }
+
+// createUserSession enforces the sign-up policy, then upserts the user and
+// account and creates a session, running the sign-up and login hooks inside
+// the same transaction
+func (a *AuthHandlers) createUserSession(r *http.Request, provider string, userInfo UserInfo, tokenResult utils.TokenResult, sessionToken string) error {
+	ctx := r.Context()
+
+	if err := a.SignUpPolicy.Check(ctx, a.Queries, provider, userInfo); err != nil {
+		return err
+	}
+
+	return pgx.BeginFunc(ctx, a.DB, func(tx pgx.Tx) error {
+		q := a.Queries.WithTx(tx)
+
+		existing, err := q.GetUserByEmail(ctx, userInfo.Email)
+		newUser := errors.Is(err, pgx.ErrNoRows)
+		if err != nil && !newUser {
+			return fmt.Errorf("error getting user by email: %w", err)
+		}
+
+		login := LoginInfo{
+			Provider: provider,
+			User:     userInfo,
+			UserID:   existing.ID,
+			NewUser:  newUser,
+			Request:  r,
+		}
+
+		if newUser {
+			err = a.Hooks.BeforeSignUp(ctx, q, SignUpInfo{Provider: provider, User: userInfo, Request: r})
+		} else {
+			err = a.Hooks.BeforeLogin(ctx, q, login)
+		}
+		if err != nil {
+			return err
+		}
+
+		row, err := q.CreateNewUser(ctx, sqlc.CreateNewUserParams{
+			Name:                 userInfo.Name,
+			Email:                userInfo.Email,
+			EmailVerified:        userInfo.EmailVerified,
+			Image:                pgtype.Text{String: userInfo.Picture, Valid: true},
+			AccountID:            userInfo.ID,
+			ProviderID:           provider,
+			Scope:                pgtype.Text{String: strings.Join(tokenResult.Scopes, " "), Valid: true},
+			AccessToken:          pgtype.Text{String: tokenResult.AccessToken, Valid: true},
+			RefreshToken:         pgtype.Text{String: tokenResult.RefreshToken, Valid: true},
+			IDToken:              pgtype.Text{String: tokenResult.IDToken, Valid: true},
+			ExpiresAt:            pgtype.Timestamp{Time: time.Now().Add(a.SessionExpiration), Valid: true},
+			Token:                sessionToken,
+			IpAddress:            pgtype.Text{String: utils.ClientIP(r), Valid: true},
+			UserAgent:            pgtype.Text{String: r.UserAgent(), Valid: true},
+			AccessTokenExpiresAt: pgtype.Timestamp{Time: tokenResult.AccessTokenExpiresAt, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+
+		if !newUser {
+			if err := cancelScheduledDeletion(ctx, q, r, existing); err != nil {
+				return err
+			}
+		}
+
+		event := AuditLogin
+		if newUser {
+			event = AuditSignUp
+		}
+		if err := recordAuditEvent(ctx, q, r, row.UserID, event, map[string]interface{}{
+			"provider":   provider,
+			"session_id": row.SessionID,
+		}); err != nil {
+			return err
+		}
+
+		if newUser {
+			user, err := q.GetUserByID(ctx, row.UserID)
+			if err != nil {
+				return fmt.Errorf("error getting new user: %w", err)
+			}
+			if err := a.Hooks.AfterSignUp(ctx, q, user); err != nil {
+				return err
+			}
+		}
+
+		login.UserID = row.UserID
+		login.SessionID = row.SessionID
+		return a.Hooks.AfterLogin(ctx, q, login)
+	})
+}
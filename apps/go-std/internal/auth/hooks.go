@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-std/internal/sqlc"
+	"go-std/internal/utils"
+	"net/http"
+)
+
+// AuthError denies an auth operation. Hooks and policies return it to stop a
+// request; the handler responds with its status, code and message.
+type AuthError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Deny creates an AuthError
+func Deny(status int, code string, message string) *AuthError {
+	return &AuthError{Status: status, Code: code, Message: message}
+}
+
+// writeAuthError writes the response for an AuthError and reports whether err was one
+func writeAuthError(w http.ResponseWriter, err error) bool {
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		return false
+	}
+	utils.ErrorResponse(w, authErr.Status, authErr.Message, authErr.Code)
+	return true
+}
+
+// SignUpInfo describes a user signing in for the first time
+type SignUpInfo struct {
+	Provider string
+	User     UserInfo
+	Request  *http.Request
+}
+
+// LoginInfo describes a login. UserID and SessionID are empty in BeforeLogin
+// for a user that is signing up.
+type LoginInfo struct {
+	Provider  string
+	User      UserInfo
+	UserID    string
+	SessionID string
+	NewUser   bool
+	Request   *http.Request
+}
+
+// Hooks lets application code run at key points of the auth lifecycle.
+// Returning an error from a Before* hook aborts the operation; return an
+// *AuthError to control the response. Hooks given a *sqlc.Queries run inside
+// the transaction of the operation, so their writes commit or roll back with it.
+// Embed NoopHooks to implement only the hooks you need.
+type Hooks interface {
+	BeforeSignUp(ctx context.Context, q *sqlc.Queries, info SignUpInfo) error
+	AfterSignUp(ctx context.Context, q *sqlc.Queries, user sqlc.User) error
+	BeforeLogin(ctx context.Context, q *sqlc.Queries, info LoginInfo) error
+	AfterLogin(ctx context.Context, q *sqlc.Queries, info LoginInfo) error
+	BeforeLogout(ctx context.Context, q *sqlc.Queries, session sqlc.GetSessionByTokenRow) error
+	OnSessionRevoked(ctx context.Context, q *sqlc.Queries, sessionID string, userID string) error
+	OnUserDeleted(ctx context.Context, q *sqlc.Queries, userID string) error
+	// EnrichSession adds fields to the session validation response
+	EnrichSession(ctx context.Context, session sqlc.GetSessionByTokenRow, data map[string]interface{}) error
+}
+
+// NoopHooks implements Hooks and does nothing
+type NoopHooks struct{}
+
+func (NoopHooks) BeforeSignUp(ctx context.Context, q *sqlc.Queries, info SignUpInfo) error {
+	return nil
+}
+
+func (NoopHooks) AfterSignUp(ctx context.Context, q *sqlc.Queries, user sqlc.User) error {
+	return nil
+}
+
+func (NoopHooks) BeforeLogin(ctx context.Context, q *sqlc.Queries, info LoginInfo) error {
+	return nil
+}
+
+func (NoopHooks) AfterLogin(ctx context.Context, q *sqlc.Queries, info LoginInfo) error {
+	return nil
+}
+
+func (NoopHooks) BeforeLogout(ctx context.Context, q *sqlc.Queries, session sqlc.GetSessionByTokenRow) error {
+	return nil
+}
+
+func (NoopHooks) OnSessionRevoked(ctx context.Context, q *sqlc.Queries, sessionID string, userID string) error {
+	return nil
+}
+
+func (NoopHooks) OnUserDeleted(ctx context.Context, q *sqlc.Queries, userID string) error {
+	return nil
+}
+
+func (NoopHooks) EnrichSession(ctx context.Context, session sqlc.GetSessionByTokenRow, data map[string]interface{}) error {
+	return nil
+}
+
+// Option configures AuthHandlers
+type Option func(*AuthHandlers)
+
+// WithHooks sets the lifecycle hooks
+func WithHooks(hooks Hooks) Option {
+	return func(a *AuthHandlers) {
+		a.Hooks = hooks
+	}
+}
@@ -1,10 +1,13 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"go-std/internal/config"
 	"go-std/internal/utils"
+	"net/http"
 	"net/url"
+	"time"
 )
 
 // OAuthProvider defines the interface that all OAuth providers must implement
@@ -13,7 +16,7 @@ type OAuthProvider interface {
 	GetProviderName() string
 
 	// CreateAuthorizationURL creates the OAuth authorization URL with PKCE
-	CreateAuthorizationURL(state string, codeVerifier string) (*url.URL, error)
+	CreateAuthorizationURL(state string, codeVerifier string, opts AuthorizationOptions) (*url.URL, error)
 
 	// ValidateAuthorizationCode exchanges the authorization code for tokens
 	ValidateAuthorizationCode(code string, codeVerifier string) (*utils.OAuth2Tokens, error)
@@ -28,6 +31,21 @@ type OAuthProvider interface {
 	GetUserInfo(tokens *utils.OAuth2Tokens) (UserInfo, error)
 }
 
+// AuthorizationOptions holds optional parameters for the authorization URL.
+// Providers ignore options they do not support.
+type AuthorizationOptions struct {
+	// Nonce is bound into the ID token by OpenID Connect providers
+	Nonce string
+	// HostedDomain asks Google to only offer accounts of a Workspace domain.
+	// It is only a hint, the hd claim must still be checked.
+	HostedDomain string
+	// Prompt is the OpenID Connect prompt, "login" forces the user to
+	// authenticate again even if they are signed in with the provider
+	Prompt string
+	// MaxAge is the OpenID Connect max_age in seconds, zero means unset
+	MaxAge int
+}
+
 // UserInfo represents standardized user information from OAuth providers
 type UserInfo struct {
 	ID            string
@@ -35,6 +53,8 @@ type UserInfo struct {
 	Name          string
 	Picture       string
 	EmailVerified bool
+	// HostedDomain is the Google Workspace domain of the account, if any
+	HostedDomain string
 }
 
 // ProviderConfig holds common configuration for OAuth providers
@@ -48,15 +68,45 @@ type ProviderConfig struct {
 type ProviderRegistry struct {
 	app       *config.App
 	providers map[string]ProviderConstructor
+	breakers  map[string]*utils.CircuitBreaker
+	clients   map[string]*http.Client
+	settings  providerClientSettings
+}
+
+// WithProviderRegistry uses registry instead of a registry of the handlers'
+// own, so handlers sharing it share the providers' circuit breakers
+func WithProviderRegistry(registry *ProviderRegistry) Option {
+	return func(a *AuthHandlers) {
+		a.ProviderRegistry = registry
+	}
 }
 
-type ProviderConstructor func(app *config.App) (OAuthProvider, error)
+// ProviderConstructor creates a provider. It must make all outbound calls with
+// client, which fails fast while the provider's circuit breaker is open.
+type ProviderConstructor func(app *config.App, client *http.Client) (OAuthProvider, error)
+
+// ErrProviderUnavailable is returned while a provider's circuit breaker is open
+var ErrProviderUnavailable = errors.New("provider unavailable")
+
+// providerClientSettings configure the HTTP clients of all providers
+type providerClientSettings struct {
+	timeout time.Duration
+	breaker utils.CircuitBreakerSettings
+}
 
 // NewProviderFactory creates a new provider factory
 func NewProviderRegistry(app *config.App) (*ProviderRegistry, error) {
+	settings, err := newProviderClientSettings(app)
+	if err != nil {
+		return nil, err
+	}
+
 	registry := &ProviderRegistry{
 		app:       app,
 		providers: make(map[string]ProviderConstructor),
+		breakers:  make(map[string]*utils.CircuitBreaker),
+		clients:   make(map[string]*http.Client),
+		settings:  settings,
 	}
 
 	// Register all available providers
@@ -70,8 +120,38 @@ func NewProviderRegistry(app *config.App) (*ProviderRegistry, error) {
 	return registry, nil
 }
 
+// newProviderClientSettings reads PROVIDER_TIMEOUT, the timeout of each call to
+// a provider, and the circuit breaker settings PROVIDER_BREAKER_FAILURES,
+// PROVIDER_BREAKER_OPEN_TIMEOUT and PROVIDER_BREAKER_HALF_OPEN_REQUESTS
+func newProviderClientSettings(app *config.App) (providerClientSettings, error) {
+	var cfg struct {
+		Timeout                 time.Duration `env:"PROVIDER_TIMEOUT" default:"10s" validate:"min=1ms"`
+		BreakerFailures         int           `env:"PROVIDER_BREAKER_FAILURES" default:"5" validate:"min=1"`
+		BreakerOpenTimeout      time.Duration `env:"PROVIDER_BREAKER_OPEN_TIMEOUT" default:"30s" validate:"min=1ms"`
+		BreakerHalfOpenRequests int           `env:"PROVIDER_BREAKER_HALF_OPEN_REQUESTS" default:"1" validate:"min=1"`
+	}
+	if err := app.Env.Bind(&cfg); err != nil {
+		return providerClientSettings{}, err
+	}
+	return providerClientSettings{
+		timeout: cfg.Timeout,
+		breaker: utils.CircuitBreakerSettings{
+			FailureThreshold:    cfg.BreakerFailures,
+			OpenTimeout:         cfg.BreakerOpenTimeout,
+			HalfOpenMaxRequests: cfg.BreakerHalfOpenRequests,
+		},
+	}, nil
+}
+
+// registerProvider adds a provider with its own circuit breaker and HTTP client
 func (r *ProviderRegistry) registerProvider(name string, constructor ProviderConstructor) {
+	breaker := utils.NewCircuitBreaker("provider:"+name, r.settings.breaker)
 	r.providers[name] = constructor
+	r.breakers[name] = breaker
+	r.clients[name] = &http.Client{
+		Timeout:   r.settings.timeout,
+		Transport: &breakerTransport{base: http.DefaultTransport, breaker: breaker},
+	}
 }
 
 // CreateProvider creates an OAuth provider instance
@@ -80,7 +160,44 @@ func (r *ProviderRegistry) CreateProvider(name string) (OAuthProvider, error) {
 	if !exists {
 		return nil, fmt.Errorf("provider %s not supported", name)
 	}
-	return constructor(r.app)
+	return constructor(r.app, r.clients[name])
+}
+
+// CheckAvailable returns ErrProviderUnavailable while the provider's circuit
+// breaker is open
+func (r *ProviderRegistry) CheckAvailable(name string) error {
+	if breaker, exists := r.breakers[name]; exists && breaker.State() == utils.CircuitOpen {
+		return ErrProviderUnavailable
+	}
+	return nil
+}
+
+// RetryAfter is how long until an unavailable provider is tried again
+func (r *ProviderRegistry) RetryAfter(name string) time.Duration {
+	if breaker, exists := r.breakers[name]; exists {
+		if d := breaker.RetryAfter(); d > 0 {
+			return d
+		}
+	}
+	return r.settings.breaker.OpenTimeout
+}
+
+// breakerTransport records the outcome of every request in a circuit breaker.
+// Network errors, 5xx and 429 responses count as failures, other responses
+// mean the provider is up even if the request itself was rejected.
+type breakerTransport struct {
+	base    http.RoundTripper
+	breaker *utils.CircuitBreaker
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	done, err := t.breaker.Allow()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+	}
+	resp, err := t.base.RoundTrip(req)
+	done(err == nil && resp.StatusCode < http.StatusInternalServerError && resp.StatusCode != http.StatusTooManyRequests)
+	return resp, err
 }
 
 func (r *ProviderRegistry) GetSupportedProviders() []string {
@@ -92,11 +209,12 @@ func (r *ProviderRegistry) GetSupportedProviders() []string {
 }
 
 func (r *ProviderRegistry) validateProviders() error {
+	var errs []error
 	for name, constructor := range r.providers {
-		_, err := constructor(r.app)
+		_, err := constructor(r.app, r.clients[name])
 		if err != nil {
-			return fmt.Errorf("provider %s: %w", name, err)
+			errs = append(errs, fmt.Errorf("provider %s: %w", name, err))
 		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"go-std/internal/sqlc"
+	"go-std/internal/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// authTimeSkew is the clock skew tolerated between us and the provider
+const authTimeSkew = time.Minute
+
+// reauthOptions reads the prompt=login and max_age login parameters. Either one
+// turns the login into a re-authentication of the current session.
+func reauthOptions(r *http.Request) (opts AuthorizationOptions, reauth bool, err error) {
+	query := r.URL.Query()
+
+	switch prompt := query.Get("prompt"); prompt {
+	case "":
+	case "login":
+		reauth = true
+	default:
+		return opts, false, fmt.Errorf("unsupported prompt %q", prompt)
+	}
+
+	if v := query.Get("max_age"); v != "" {
+		maxAge, err := strconv.Atoi(v)
+		if err != nil || maxAge < 0 {
+			return opts, false, fmt.Errorf("invalid max_age %q", v)
+		}
+		opts.MaxAge = maxAge
+		reauth = true
+	}
+
+	if reauth {
+		opts.Prompt = "login"
+	}
+	return opts, reauth, nil
+}
+
+// Reauthenticator is implemented by providers whose ID tokens carry an
+// auth_time claim, which proves that the user signed in again. Providers
+// without it, such as GitHub, cannot re-authenticate a session because they
+// may silently reuse the user's existing provider session.
+type Reauthenticator interface {
+	SupportsReauthentication() bool
+}
+
+func supportsReauthentication(p OAuthProvider) bool {
+	r, ok := p.(Reauthenticator)
+	return ok && r.SupportsReauthentication()
+}
+
+// verifyAuthTime checks that the ID token has an auth_time that is not older
+// than the start of the login
+func verifyAuthTime(tokens *utils.OAuth2Tokens, notBefore time.Time) error {
+	if !tokens.HasIDToken() {
+		return errors.New("provider returned no ID token to prove re-authentication")
+	}
+	idToken, err := tokens.IDToken()
+	if err != nil {
+		return err
+	}
+	claims, err := utils.DecodeJwt(idToken)
+	if err != nil {
+		return fmt.Errorf("failed to decode ID token: %w", err)
+	}
+	authTime, ok := claims["auth_time"].(float64)
+	if !ok {
+		return errors.New("ID token has no auth_time claim")
+	}
+	if time.Unix(int64(authTime), 0).Before(notBefore.Add(-authTimeSkew)) {
+		return errors.New("provider did not re-authenticate the user")
+	}
+	return nil
+}
+
+// completeReauth finishes a re-authentication login by marking the existing
+// session as freshly authenticated instead of creating a new one
+func (a *AuthHandlers) completeReauth(w http.ResponseWriter, r *http.Request, tx *OAuthTransaction, tokens *utils.OAuth2Tokens, userInfo UserInfo) {
+	ctx := r.Context()
+
+	if err := verifyAuthTime(tokens, tx.CreatedAt); err != nil {
+		utils.ErrorResponse(w, http.StatusUnauthorized, "Re-authentication failed. Please try again", "REAUTH_FAILED")
+		logger.Warn("Error verifying auth_time: %v", err)
+		return
+	}
+
+	session, err := a.currentSession(r)
+	if err != nil || session.ID != tx.ReauthSessionID {
+		utils.ErrorResponse(w, http.StatusUnauthorized, "Session changed during re-authentication", "UNAUTHORIZED")
+		return
+	}
+
+	if err := a.SignUpPolicy.CheckEmail(tx.Provider, userInfo); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
+	user, err := a.Queries.GetUserByEmail(ctx, userInfo.Email)
+	if err != nil || user.ID != session.UserID {
+		utils.ErrorResponse(w, http.StatusForbidden, "Re-authenticated as a different user", "REAUTH_USER_MISMATCH")
+		return
+	}
+
+	err = pgx.BeginFunc(ctx, a.DB, func(dbtx pgx.Tx) error {
+		qtx := a.Queries.WithTx(dbtx)
+		if _, err := qtx.UpdateSessionAuthenticatedAt(ctx, sqlc.UpdateSessionAuthenticatedAtParams{
+			ID:              session.ID,
+			AuthenticatedAt: pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+		}); err != nil {
+			return err
+		}
+		return recordAuditEvent(ctx, qtx, r, session.UserID, AuditReauthenticated, map[string]interface{}{
+			"provider":   tx.Provider,
+			"session_id": session.ID,
+		})
+	})
+	if err != nil {
+		utils.ErrorResponse(w, http.StatusInternalServerError, "Error updating session", "INTERNAL_SERVER_ERROR")
+		logger.Error("Error updating session authentication time: %v", err)
+		return
+	}
+
+	a.RedirectPolicy.Redirect(w, r, tx.RedirectURL)
+}
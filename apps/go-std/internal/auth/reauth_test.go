@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerifyAuthTime(t *testing.T) {
+	started := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) float64 { return float64(started.Add(d).Unix()) }
+
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		wantErr bool
+	}{
+		{"after the login started", map[string]interface{}{"auth_time": at(time.Second * 30)}, false},
+		{"within the clock skew", map[string]interface{}{"auth_time": at(-authTimeSkew + time.Second)}, false},
+		{"reused provider session", map[string]interface{}{"auth_time": at(-time.Hour)}, true},
+		{"missing claim", map[string]interface{}{"sub": "1"}, true},
+		{"wrong type", map[string]interface{}{"auth_time": "1704110400"}, true},
+		{"no ID token", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyAuthTime(testTokens(t, tt.claims), started)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyAuthTime = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"fmt"
+	"go-std/internal/config"
+	"go-std/internal/utils"
+	"strings"
+)
+
+// redirectFrontends are the frontends that may be redirected to after login.
+// Each one is configured with AUTH_REDIRECT_<NAME>_ORIGIN and an optional
+// comma separated AUTH_REDIRECT_<NAME>_PATHS list of allowed path prefixes.
+var redirectFrontends = []string{"next", "tanstack", "vite-admin"}
+
+// NewRedirectPolicy builds the post-login redirect allowlist from config
+func NewRedirectPolicy(app *config.App) (*utils.RedirectPolicy, error) {
+	var targets []utils.RedirectTarget
+	for _, name := range redirectFrontends {
+		key := "AUTH_REDIRECT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+
+		origin := app.Env.GetString(key + "_ORIGIN")
+		if origin == "" {
+			continue
+		}
+
+		var paths []string
+		for _, p := range strings.Split(app.Env.GetString(key+"_PATHS"), ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				paths = append(paths, p)
+			}
+		}
+
+		targets = append(targets, utils.RedirectTarget{
+			Name:   name,
+			Origin: origin,
+			Paths:  paths,
+		})
+	}
+
+	policy, err := utils.NewRedirectPolicy(targets...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect allowlist: %w", err)
+	}
+	return policy, nil
+}
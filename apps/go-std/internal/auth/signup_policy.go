@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-std/internal/config"
+	"go-std/internal/sqlc"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SignUpMode controls whether users without an account may sign up
+type SignUpMode string
+
+const (
+	// SignUpOpen lets anyone passing the domain checks sign up
+	SignUpOpen SignUpMode = "open"
+	// SignUpInviteOnly only admits users that already exist, e.g. created by an admin
+	SignUpInviteOnly SignUpMode = "invite_only"
+	// SignUpWaitlist records unknown users on the waitlist instead of creating them
+	SignUpWaitlist SignUpMode = "waitlist"
+)
+
+// SignUpPolicy decides who may sign up and log in. It is configured with:
+//
+//	SIGNUP_MODE                    open, invite_only or waitlist (default open)
+//	SIGNUP_ALLOWED_DOMAINS         comma separated email domains, empty allows all
+//	SIGNUP_DENIED_DOMAINS          comma separated email domains that are always rejected
+//	SIGNUP_HOSTED_DOMAIN           Google Workspace domain required in the hd claim
+//	SIGNUP_REQUIRE_VERIFIED_EMAIL  reject accounts whose provider has not verified the email
+type SignUpPolicy struct {
+	Mode                 SignUpMode
+	AllowedDomains       []string
+	DeniedDomains        []string
+	HostedDomain         string
+	RequireVerifiedEmail bool
+}
+
+// NewSignUpPolicy builds the sign-up policy from config
+func NewSignUpPolicy(app *config.App) (*SignUpPolicy, error) {
+	policy := &SignUpPolicy{
+		Mode:           SignUpOpen,
+		AllowedDomains: splitDomains(app.Env.GetString("SIGNUP_ALLOWED_DOMAINS")),
+		DeniedDomains:  splitDomains(app.Env.GetString("SIGNUP_DENIED_DOMAINS")),
+		HostedDomain:   strings.ToLower(strings.TrimSpace(app.Env.GetString("SIGNUP_HOSTED_DOMAIN"))),
+	}
+
+	switch mode := SignUpMode(strings.ToLower(app.Env.GetString("SIGNUP_MODE"))); mode {
+	case "":
+	case SignUpOpen, SignUpInviteOnly, SignUpWaitlist:
+		policy.Mode = mode
+	default:
+		return nil, fmt.Errorf("unknown SIGNUP_MODE %q", mode)
+	}
+
+	if v := app.Env.GetString("SIGNUP_REQUIRE_VERIFIED_EMAIL"); v != "" {
+		required, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SIGNUP_REQUIRE_VERIFIED_EMAIL: %w", err)
+		}
+		policy.RequireVerifiedEmail = required
+	}
+
+	return policy, nil
+}
+
+// Check enforces the policy for a user authenticated by provider. The email
+// checks apply to every login, the mode only to users without an account.
+// Any login path, OAuth or credentials, must call it before creating a user
+// or session. Waitlisted users are recorded with q, so it must not be a
+// transaction that is rolled back on the returned error.
+func (p *SignUpPolicy) Check(ctx context.Context, q *sqlc.Queries, provider string, info UserInfo) error {
+	if err := p.CheckEmail(provider, info); err != nil {
+		return err
+	}
+	if p.Mode == SignUpOpen {
+		return nil
+	}
+
+	_, err := q.GetUserByEmail(ctx, info.Email)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("error getting user by email: %w", err)
+	}
+
+	if p.Mode == SignUpWaitlist {
+		err := q.AddToWaitlist(ctx, sqlc.AddToWaitlistParams{
+			Email:    info.Email,
+			Name:     info.Name,
+			Provider: provider,
+		})
+		if err != nil {
+			return fmt.Errorf("error adding to waitlist: %w", err)
+		}
+		return Deny(http.StatusForbidden, "WAITLISTED", "You have been added to the waitlist")
+	}
+	return Deny(http.StatusForbidden, "INVITE_REQUIRED", "Sign up is by invitation only")
+}
+
+// CheckEmail enforces the verified email, domain and hosted domain rules
+func (p *SignUpPolicy) CheckEmail(provider string, info UserInfo) error {
+	if p.RequireVerifiedEmail && !info.EmailVerified {
+		return Deny(http.StatusForbidden, "EMAIL_NOT_VERIFIED", "Email address is not verified")
+	}
+
+	_, domain, ok := strings.Cut(strings.ToLower(info.Email), "@")
+	if !ok || domain == "" {
+		return Deny(http.StatusForbidden, "EMAIL_DOMAIN_NOT_ALLOWED", "Email address is not allowed")
+	}
+	for _, d := range p.DeniedDomains {
+		if domain == d {
+			return Deny(http.StatusForbidden, "EMAIL_DOMAIN_NOT_ALLOWED", "Email domain is not allowed")
+		}
+	}
+	if len(p.AllowedDomains) > 0 {
+		allowed := false
+		for _, d := range p.AllowedDomains {
+			if domain == d {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return Deny(http.StatusForbidden, "EMAIL_DOMAIN_NOT_ALLOWED", "Email domain is not allowed")
+		}
+	}
+
+	// Only Google proves Workspace membership, other providers are rejected
+	// outright when a hosted domain is required
+	if p.HostedDomain != "" {
+		if provider != "google" || strings.ToLower(info.HostedDomain) != p.HostedDomain {
+			return Deny(http.StatusForbidden, "HOSTED_DOMAIN_REQUIRED", "Account is not part of the required organization")
+		}
+	}
+
+	return nil
+}
+
+func splitDomains(s string) []string {
+	var domains []string
+	for _, d := range strings.Split(s, ",") {
+		if d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@")); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"go-std/internal/sqlc"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeUsers is a sqlc.DBTX that knows the users in emails and records the
+// statements it executes
+type fakeUsers struct {
+	emails map[string]bool
+	execs  []string
+}
+
+type fakeRow struct{ err error }
+
+func (r fakeRow) Scan(dest ...interface{}) error { return r.err }
+
+func (db *fakeUsers) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
+	if strings.Contains(query, "GetUserByEmail") && db.emails[args[0].(string)] {
+		return fakeRow{}
+	}
+	return fakeRow{err: pgx.ErrNoRows}
+}
+
+func (db *fakeUsers) Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+	db.execs = append(db.execs, query)
+	return pgconn.NewCommandTag("INSERT 0 1"), nil
+}
+
+func (db *fakeUsers) Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (db *fakeUsers) CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, src pgx.CopyFromSource) (int64, error) {
+	return 0, errors.New("not implemented")
+}
+
+func TestSignUpPolicyCheck(t *testing.T) {
+	member := UserInfo{Email: "Ann@Example.com", EmailVerified: true, HostedDomain: "example.com"}
+	stranger := UserInfo{Email: "bob@example.com", EmailVerified: true}
+
+	tests := []struct {
+		name     string
+		policy   SignUpPolicy
+		provider string
+		info     UserInfo
+		code     string
+		status   int
+		waitlist bool
+	}{
+		{"open", SignUpPolicy{Mode: SignUpOpen}, "github", stranger, "", 0, false},
+		{"unverified", SignUpPolicy{Mode: SignUpOpen, RequireVerifiedEmail: true}, "github", UserInfo{Email: "bob@example.com"}, "EMAIL_NOT_VERIFIED", http.StatusForbidden, false},
+		{"no domain", SignUpPolicy{Mode: SignUpOpen}, "github", UserInfo{Email: "bob@"}, "EMAIL_DOMAIN_NOT_ALLOWED", http.StatusForbidden, false},
+		{"denied domain", SignUpPolicy{Mode: SignUpOpen, DeniedDomains: []string{"example.com"}}, "github", stranger, "EMAIL_DOMAIN_NOT_ALLOWED", http.StatusForbidden, false},
+		{"allowed domain", SignUpPolicy{Mode: SignUpOpen, AllowedDomains: []string{"example.com"}}, "github", member, "", 0, false},
+		{"other domain", SignUpPolicy{Mode: SignUpOpen, AllowedDomains: []string{"example.org"}}, "github", stranger, "EMAIL_DOMAIN_NOT_ALLOWED", http.StatusForbidden, false},
+		{"subdomain", SignUpPolicy{Mode: SignUpOpen, AllowedDomains: []string{"example.com"}}, "github", UserInfo{Email: "bob@evil.example.com"}, "EMAIL_DOMAIN_NOT_ALLOWED", http.StatusForbidden, false},
+		{"hosted domain", SignUpPolicy{Mode: SignUpOpen, HostedDomain: "example.com"}, "google", member, "", 0, false},
+		{"hosted domain missing", SignUpPolicy{Mode: SignUpOpen, HostedDomain: "example.com"}, "google", stranger, "HOSTED_DOMAIN_REQUIRED", http.StatusForbidden, false},
+		{"hosted domain other provider", SignUpPolicy{Mode: SignUpOpen, HostedDomain: "example.com"}, "github", member, "HOSTED_DOMAIN_REQUIRED", http.StatusForbidden, false},
+		{"invite only member", SignUpPolicy{Mode: SignUpInviteOnly}, "github", member, "", 0, false},
+		{"invite only stranger", SignUpPolicy{Mode: SignUpInviteOnly}, "github", stranger, "INVITE_REQUIRED", http.StatusForbidden, false},
+		{"waitlist member", SignUpPolicy{Mode: SignUpWaitlist}, "github", member, "", 0, false},
+		{"waitlist stranger", SignUpPolicy{Mode: SignUpWaitlist}, "github", stranger, "WAITLISTED", http.StatusForbidden, true},
+		{"waitlist denied domain", SignUpPolicy{Mode: SignUpWaitlist, DeniedDomains: []string{"example.com"}}, "github", stranger, "EMAIL_DOMAIN_NOT_ALLOWED", http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeUsers{emails: map[string]bool{"Ann@Example.com": true}}
+			err := tt.policy.Check(context.Background(), sqlc.New(db), tt.provider, tt.info)
+
+			if tt.code == "" {
+				if err != nil {
+					t.Fatalf("expected the login to be allowed, got %v", err)
+				}
+			} else {
+				var authErr *AuthError
+				if !errors.As(err, &authErr) || authErr.Code != tt.code || authErr.Status != tt.status {
+					t.Fatalf("got %v, want %d %s", err, tt.status, tt.code)
+				}
+			}
+			if waitlisted := len(db.execs) == 1 && strings.Contains(db.execs[0], "AddToWaitlist"); waitlisted != tt.waitlist || len(db.execs) > 1 {
+				t.Fatalf("got statements %q, want waitlist %v", db.execs, tt.waitlist)
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"go-std/internal/config"
+	"go-std/internal/sqlc"
+	"go-std/internal/utils"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const oauthTransactionTTL = time.Minute * 10
+
+// ErrTransactionNotFound is returned when a transaction is unknown, expired or already consumed
+var ErrTransactionNotFound = errors.New("oauth transaction not found")
+
+// OAuthTransaction is the server-side state of a single login attempt, keyed by state
+type OAuthTransaction struct {
+	State        string
+	CodeVerifier string
+	Nonce        string
+	Provider     string
+	RedirectURL  string
+	CreatedAt    time.Time
+	// ReauthSessionID is set when the login re-authenticates an existing session
+	ReauthSessionID string
+}
+
+// Expired reports whether the transaction is older than ttl
+func (t *OAuthTransaction) Expired(ttl time.Duration) bool {
+	return time.Since(t.CreatedAt) > ttl
+}
+
+// OAuthTransactionStore persists OAuth transactions between the login and callback requests.
+// Consume must return a transaction at most once.
+type OAuthTransactionStore interface {
+	Save(ctx context.Context, tx *OAuthTransaction) error
+	Consume(ctx context.Context, state string) (*OAuthTransaction, error)
+}
+
+// NewOAuthTransactionStore selects the store from OAUTH_TRANSACTION_STORE ("memory" or "postgres")
+func NewOAuthTransactionStore(app *config.App) (OAuthTransactionStore, error) {
+	switch backend := strings.ToLower(app.Env.GetString("OAUTH_TRANSACTION_STORE")); backend {
+	case "", "memory":
+		return NewMemoryTransactionStore(oauthTransactionTTL), nil
+	case "postgres":
+		return NewPostgresTransactionStore(app.Queries, oauthTransactionTTL), nil
+	default:
+		return nil, fmt.Errorf("unknown OAUTH_TRANSACTION_STORE %q", backend)
+	}
+}
+
+// MemoryTransactionStore keeps transactions in a TTLMap. Suitable for a single node only.
+type MemoryTransactionStore struct {
+	m   *utils.TTLMap[string, *OAuthTransaction]
+	ttl time.Duration
+}
+
+func NewMemoryTransactionStore(ttl time.Duration) *MemoryTransactionStore {
+	return &MemoryTransactionStore{
+		m:   utils.NewTTLMap[string, *OAuthTransaction](10000, ttl, "oauth_transactions"),
+		ttl: ttl,
+	}
+}
+
+func (s *MemoryTransactionStore) Save(ctx context.Context, tx *OAuthTransaction) error {
+	s.m.Put(tx.State, tx)
+	return nil
+}
+
+func (s *MemoryTransactionStore) Consume(ctx context.Context, state string) (*OAuthTransaction, error) {
+	tx, ok := s.m.Take(state)
+	if !ok {
+		return nil, ErrTransactionNotFound
+	}
+	if tx.Expired(s.ttl) {
+		return nil, ErrTransactionNotFound
+	}
+	return tx, nil
+}
+
+// Close stops the store's janitor
+func (s *MemoryTransactionStore) Close() error {
+	return s.m.Close()
+}
+
+// PostgresTransactionStore keeps transactions in the oauth_transaction table so any
+// node in a cluster can complete a login.
+type PostgresTransactionStore struct {
+	q   *sqlc.Queries
+	ttl time.Duration
+}
+
+func NewPostgresTransactionStore(q *sqlc.Queries, ttl time.Duration) *PostgresTransactionStore {
+	return &PostgresTransactionStore{q: q, ttl: ttl}
+}
+
+func (s *PostgresTransactionStore) Save(ctx context.Context, tx *OAuthTransaction) error {
+	now := pgtype.Timestamp{Time: time.Now().UTC(), Valid: true}
+
+	// Opportunistically clear out abandoned logins
+	if _, err := s.q.DeleteExpiredOAuthTransactions(ctx, now); err != nil {
+		logger.Warn("error deleting expired oauth transactions: %v", err)
+	}
+
+	return s.q.CreateOAuthTransaction(ctx, sqlc.CreateOAuthTransactionParams{
+		State:        tx.State,
+		CodeVerifier: tx.CodeVerifier,
+		Nonce:        tx.Nonce,
+		Provider:     tx.Provider,
+		RedirectUrl:  tx.RedirectURL,
+		CreatedAt:    pgtype.Timestamp{Time: tx.CreatedAt.UTC(), Valid: true},
+		ExpiresAt:    pgtype.Timestamp{Time: tx.CreatedAt.Add(s.ttl).UTC(), Valid: true},
+		ReauthSessionID: pgtype.Text{
+			String: tx.ReauthSessionID,
+			Valid:  tx.ReauthSessionID != "",
+		},
+	})
+}
+
+func (s *PostgresTransactionStore) Consume(ctx context.Context, state string) (*OAuthTransaction, error) {
+	row, err := s.q.ConsumeOAuthTransaction(ctx, sqlc.ConsumeOAuthTransactionParams{
+		State: state,
+		Now:   pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrTransactionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &OAuthTransaction{
+		State:           row.State,
+		CodeVerifier:    row.CodeVerifier,
+		Nonce:           row.Nonce,
+		Provider:        row.Provider,
+		RedirectURL:     row.RedirectUrl,
+		CreatedAt:       row.CreatedAt.Time,
+		ReauthSessionID: row.ReauthSessionID.String,
+	}, nil
+}
+
+// verifyNonce checks the nonce claim of the ID token, if the provider returned one
+func verifyNonce(tokens *utils.OAuth2Tokens, nonce string) error {
+	if !tokens.HasIDToken() {
+		return nil
+	}
+	idToken, err := tokens.IDToken()
+	if err != nil {
+		return err
+	}
+	claims, err := utils.DecodeJwt(idToken)
+	if err != nil {
+		return fmt.Errorf("failed to decode ID token: %w", err)
+	}
+	claimed, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(claimed), []byte(nonce)) != 1 {
+		return errors.New("nonce mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"go-std/internal/utils"
+	"testing"
+	"time"
+)
+
+// testTokens returns tokens whose unsigned ID token carries claims, or no ID
+// token when claims is nil
+func testTokens(t *testing.T, claims map[string]interface{}) *utils.OAuth2Tokens {
+	t.Helper()
+	data := map[string]interface{}{"access_token": "access"}
+	if claims != nil {
+		payload, err := json.Marshal(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data["id_token"] = "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+	}
+	return &utils.OAuth2Tokens{Data: data}
+}
+
+func TestMemoryTransactionStoreConsume(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryTransactionStore(time.Minute)
+	defer store.Close()
+
+	tx := &OAuthTransaction{State: "state", Nonce: "nonce", Provider: "google", CreatedAt: time.Now()}
+	if err := store.Save(ctx, tx); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	got, err := store.Consume(ctx, "state")
+	if err != nil || got.Nonce != "nonce" || got.Provider != "google" {
+		t.Fatalf("Consume = %+v, %v", got, err)
+	}
+	if _, err := store.Consume(ctx, "state"); !errors.Is(err, ErrTransactionNotFound) {
+		t.Fatalf("expected a replayed state to be rejected, got %v", err)
+	}
+	if _, err := store.Consume(ctx, "unknown"); !errors.Is(err, ErrTransactionNotFound) {
+		t.Fatalf("expected an unknown state to be rejected, got %v", err)
+	}
+
+	old := &OAuthTransaction{State: "old", CreatedAt: time.Now().Add(-2 * time.Minute)}
+	store.Save(ctx, old)
+	if _, err := store.Consume(ctx, "old"); !errors.Is(err, ErrTransactionNotFound) {
+		t.Fatalf("expected an expired transaction to be rejected, got %v", err)
+	}
+}
+
+func TestVerifyNonce(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		wantErr bool
+	}{
+		{"matching", map[string]interface{}{"nonce": "abc"}, false},
+		{"no ID token", nil, false},
+		{"mismatch", map[string]interface{}{"nonce": "abd"}, true},
+		{"prefix", map[string]interface{}{"nonce": "ab"}, true},
+		{"missing claim", map[string]interface{}{"sub": "1"}, true},
+		{"wrong type", map[string]interface{}{"nonce": 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyNonce(testTokens(t, tt.claims), "abc")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verifyNonce = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	malformed := &utils.OAuth2Tokens{Data: map[string]interface{}{"id_token": "not-a-jwt"}}
+	if err := verifyNonce(malformed, "abc"); err == nil {
+		t.Fatal("expected a malformed ID token to be rejected")
+	}
+}
@@ -0,0 +1,477 @@
+package config
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError is a missing or invalid setting
+type FieldError struct {
+	// Key is the env var, or the JSONC path for settings without one
+	Key string
+	Err error
+}
+
+func (e FieldError) Error() string {
+	return e.Key + ": " + e.Err.Error()
+}
+
+func (e FieldError) Unwrap() error {
+	return e.Err
+}
+
+// BindError lists every missing or invalid setting found by Bind
+type BindError struct {
+	Fields []FieldError
+}
+
+func (e *BindError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid config, %d setting(s) missing or invalid:", len(e.Fields))
+	for _, f := range e.Fields {
+		b.WriteString("\n\t")
+		b.WriteString(f.Error())
+	}
+	return b.String()
+}
+
+func (e *BindError) Unwrap() []error {
+	errs := make([]error, len(e.Fields))
+	for i, f := range e.Fields {
+		errs[i] = f
+	}
+	return errs
+}
+
+// ErrRequired is the error of required settings that are not set
+var ErrRequired = errors.New("required")
+
+// Validator is implemented by config structs with rules across fields. It is
+// called after the struct's fields were bound without errors.
+type Validator interface {
+	Validate() error
+}
+
+// Bind fills the struct v points to from the app's config, see ConfigMap.Bind
+func Bind(v interface{}) error {
+	c, err := Config()
+	if err != nil {
+		return err
+	}
+	return c.Bind(v)
+}
+
+// Bind fills the struct v points to. Each field is read from the first of
+//
+//	env:"KEY"        the environment, or a top level JSONC key of that name
+//	json:"name"      the JSONC value at the field's path, nested structs nest;
+//	                 the path is overridden by env, see EnvKey
+//	default:"value"  a default in the env format
+//
+// that is set. A field set by none keeps its value, or is reported if it has
+// required:"true". Strings, bools, numbers, time.Duration, url.URL, slices
+// (comma separated in env), maps ("k=v,..." in env), encoding.TextUnmarshaler
+// and nested structs are supported. validate:"..." adds comma separated rules:
+//
+//	min=N, max=N  bounds of numbers and durations, or of the length of
+//	              strings, slices and maps
+//	oneof=a|b     allowed values
+//	url           an absolute URL
+//
+// Every missing or invalid setting is reported in a single *BindError.
+func (c *ConfigMap) Bind(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("config: Bind requires a pointer to a struct")
+	}
+	b := binder{c: c}
+	b.bindStruct(rv.Elem(), c.data, "")
+	if len(b.errs) > 0 {
+		return &BindError{Fields: b.errs}
+	}
+	return nil
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	urlType             = reflect.TypeOf(url.URL{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+type binder struct {
+	c    *ConfigMap
+	errs []FieldError
+}
+
+func (b *binder) fail(key string, err error) {
+	b.errs = append(b.errs, FieldError{Key: key, Err: err})
+}
+
+func (b *binder) bindStruct(v reflect.Value, data map[string]interface{}, path string) {
+	before := len(b.errs)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+
+		envKey := field.Tag.Get("env")
+		jsonName, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if jsonName == "-" {
+			jsonName = ""
+		}
+		fieldPath := jsonName
+		if path != "" && jsonName != "" {
+			fieldPath = path + "." + jsonName
+		}
+		key := envKey
+		if key == "" {
+			key = fieldPath
+		}
+		if key == "" {
+			key = field.Name
+		}
+
+		if isNestedStruct(field.Type) && envKey == "" {
+			var sub map[string]interface{}
+			if jsonName != "" {
+				sub, _ = data[jsonName].(map[string]interface{})
+			} else if field.Anonymous {
+				sub = data
+			}
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					fv.Set(reflect.New(field.Type.Elem()))
+				}
+				fv = fv.Elem()
+			}
+			nestedPath := fieldPath
+			if field.Anonymous && jsonName == "" {
+				nestedPath = path
+			}
+			b.bindStruct(fv, sub, nestedPath)
+			continue
+		}
+
+		if envKey == "" && fieldPath != "" {
+			envKey = EnvKey(fieldPath)
+		}
+		raw, found := b.lookup(envKey, jsonName, data)
+		if !found {
+			if def, ok := field.Tag.Lookup("default"); ok {
+				raw, found = def, true
+			}
+		}
+		if !found {
+			if required, _ := strconv.ParseBool(field.Tag.Get("required")); required {
+				b.fail(key, ErrRequired)
+			}
+			continue
+		}
+		if err := setValue(fv, raw); err != nil {
+			b.fail(key, err)
+			continue
+		}
+		if err := validate(fv, field.Tag.Get("validate")); err != nil {
+			b.fail(key, err)
+		}
+	}
+
+	if len(b.errs) == before && v.CanAddr() {
+		if validator, ok := v.Addr().Interface().(Validator); ok {
+			if err := validator.Validate(); err != nil {
+				key := path
+				if key == "" {
+					key = t.Name()
+				}
+				b.fail(key, err)
+			}
+		}
+	}
+}
+
+// lookup returns the raw value of a field, from env or JSONC
+func (b *binder) lookup(envKey, jsonName string, data map[string]interface{}) (interface{}, bool) {
+	if envKey != "" {
+		if v := b.c.GetString(envKey); v != "" {
+			return v, true
+		}
+	}
+	if jsonName != "" && data != nil {
+		if v, ok := data[jsonName]; ok && v != nil {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// isNestedStruct reports whether t is bound field by field rather than from a
+// single value
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == urlType {
+		return false
+	}
+	return !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+// setValue converts raw, an env string or a decoded JSON value, into v
+func setValue(v reflect.Value, raw interface{}) error {
+	t := v.Type()
+
+	if t.Kind() == reflect.Pointer {
+		elem := reflect.New(t.Elem())
+		if err := setValue(elem.Elem(), raw); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	}
+	if s, ok := raw.(string); ok && t != urlType && reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch {
+	case t == durationType:
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("expected a duration such as \"30s\", got %v", raw)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+		return nil
+
+	case t == urlType:
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("expected a URL, got %v", raw)
+		}
+		u, err := parseAbsoluteURL(s)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(*u))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		switch r := raw.(type) {
+		case string:
+			v.SetString(r)
+		case map[string]interface{}, []interface{}:
+			return fmt.Errorf("expected a string, got %v", raw)
+		default:
+			v.SetString(fmt.Sprintf("%v", r))
+		}
+
+	case reflect.Bool:
+		switch r := raw.(type) {
+		case bool:
+			v.SetBool(r)
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(r))
+			if err != nil {
+				return fmt.Errorf("invalid boolean %q", r)
+			}
+			v.SetBool(b)
+		default:
+			return fmt.Errorf("expected a boolean, got %v", raw)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := toFloat(raw)
+		if err != nil {
+			return err
+		}
+		if n != math.Trunc(n) || v.OverflowInt(int64(n)) {
+			return fmt.Errorf("invalid integer %v", raw)
+		}
+		v.SetInt(int64(n))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := toFloat(raw)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n != math.Trunc(n) || v.OverflowUint(uint64(n)) {
+			return fmt.Errorf("invalid unsigned integer %v", raw)
+		}
+		v.SetUint(uint64(n))
+
+	case reflect.Float32, reflect.Float64:
+		n, err := toFloat(raw)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+
+	case reflect.Slice:
+		var items []interface{}
+		switch r := raw.(type) {
+		case []interface{}:
+			items = r
+		case string:
+			for _, item := range strings.Split(r, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+		default:
+			return fmt.Errorf("expected a list, got %v", raw)
+		}
+		slice := reflect.MakeSlice(t, len(items), len(items))
+		for i, item := range items {
+			if err := setValue(slice.Index(i), item); err != nil {
+				return fmt.Errorf("item %d: %w", i, err)
+			}
+		}
+		v.Set(slice)
+
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type %s", t.Key())
+		}
+		entries := map[string]interface{}{}
+		switch r := raw.(type) {
+		case map[string]interface{}:
+			entries = r
+		case string:
+			for _, pair := range strings.Split(r, ",") {
+				if pair = strings.TrimSpace(pair); pair == "" {
+					continue
+				}
+				k, val, ok := strings.Cut(pair, "=")
+				if !ok {
+					return fmt.Errorf("invalid map entry %q, expected key=value", pair)
+				}
+				entries[strings.TrimSpace(k)] = strings.TrimSpace(val)
+			}
+		default:
+			return fmt.Errorf("expected a map, got %v", raw)
+		}
+		m := reflect.MakeMapWithSize(t, len(entries))
+		for k, item := range entries {
+			elem := reflect.New(t.Elem()).Elem()
+			if err := setValue(elem, item); err != nil {
+				return fmt.Errorf("key %s: %w", k, err)
+			}
+			m.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), elem)
+		}
+		v.Set(m)
+
+	default:
+		return fmt.Errorf("unsupported type %s", t)
+	}
+	return nil
+}
+
+func toFloat(raw interface{}) (float64, error) {
+	switch r := raw.(type) {
+	case float64:
+		return r, nil
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(r), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", r)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("expected a number, got %v", raw)
+}
+
+func parseAbsoluteURL(s string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q, expected an absolute URL", s)
+	}
+	return u, nil
+}
+
+// validate applies the rules of a validate tag to a bound value
+func validate(v reflect.Value, rules string) error {
+	if rules == "" {
+		return nil
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "min", "max":
+			value, limit, err := measure(v, arg)
+			if err != nil {
+				return fmt.Errorf("rule %s: %w", rule, err)
+			}
+			if name == "min" && value < limit {
+				return fmt.Errorf("must be at least %s", arg)
+			}
+			if name == "max" && value > limit {
+				return fmt.Errorf("must be at most %s", arg)
+			}
+		case "oneof":
+			value := fmt.Sprintf("%v", v.Interface())
+			allowed := strings.Split(arg, "|")
+			found := false
+			for _, a := range allowed {
+				if value == a {
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("must be one of %s, got %q", strings.Join(allowed, ", "), value)
+			}
+		case "url":
+			if v.Kind() == reflect.String {
+				if _, err := parseAbsoluteURL(v.String()); err != nil {
+					return err
+				}
+			}
+		case "":
+		default:
+			return fmt.Errorf("unknown validation rule %q", name)
+		}
+	}
+	return nil
+}
+
+// measure returns the value min and max compare and the limit parsed for it
+func measure(v reflect.Value, arg string) (float64, float64, error) {
+	if v.Type() == durationType {
+		limit, err := time.ParseDuration(arg)
+		return float64(v.Int()), float64(limit), err
+	}
+	limit, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), limit, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), limit, nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), limit, nil
+	case reflect.String, reflect.Slice, reflect.Map:
+		return float64(v.Len()), limit, nil
+	}
+	return 0, 0, fmt.Errorf("not supported for %s", v.Type())
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testPoolConfig struct {
+	MaxConns int           `json:"max_conns" default:"10" validate:"min=1,max=100"`
+	Timeout  time.Duration `json:"timeout" env:"TEST_POOL_TIMEOUT" default:"5s"`
+}
+
+type testDBConfig struct {
+	URL  url.URL        `env:"TEST_DATABASE_URL" required:"true"`
+	Pool testPoolConfig `json:"pool"`
+}
+
+type testConfig struct {
+	Name    string            `json:"name" default:"go-std"`
+	Mode    string            `env:"TEST_MODE" default:"dev" validate:"oneof=dev|prod"`
+	Origins []string          `env:"TEST_ORIGINS" json:"origins"`
+	Limits  map[string]int    `env:"TEST_LIMITS"`
+	Labels  map[string]string `json:"labels"`
+	Debug   bool              `env:"TEST_DEBUG"`
+	DB      testDBConfig      `json:"db"`
+}
+
+func TestBind(t *testing.T) {
+	t.Setenv("TEST_DATABASE_URL", "postgres://localhost:5432/app")
+	t.Setenv("TEST_ORIGINS", "https://a.example.com, https://b.example.com")
+	t.Setenv("TEST_LIMITS", "login=10,refresh=30")
+	t.Setenv("TEST_POOL_TIMEOUT", "2s")
+	c := &ConfigMap{data: map[string]interface{}{
+		"name":   "api",
+		"labels": map[string]interface{}{"team": "auth"},
+		"db": map[string]interface{}{
+			"pool": map[string]interface{}{"max_conns": float64(20), "timeout": "1m"},
+		},
+	}}
+
+	var cfg testConfig
+	if err := c.Bind(&cfg); err != nil {
+		t.Fatalf("Bind: %v", err)
+	}
+	if cfg.Name != "api" || cfg.Mode != "dev" || cfg.Debug {
+		t.Fatalf("unexpected scalars %+v", cfg)
+	}
+	if len(cfg.Origins) != 2 || cfg.Origins[1] != "https://b.example.com" {
+		t.Fatalf("unexpected origins %v", cfg.Origins)
+	}
+	if cfg.Limits["refresh"] != 30 || cfg.Labels["team"] != "auth" {
+		t.Fatalf("unexpected maps %v %v", cfg.Limits, cfg.Labels)
+	}
+	if cfg.DB.URL.Host != "localhost:5432" || cfg.DB.Pool.MaxConns != 20 {
+		t.Fatalf("unexpected nested config %+v", cfg.DB)
+	}
+	if cfg.DB.Pool.Timeout != time.Second*2 {
+		t.Fatalf("expected env to win over JSONC, got %s", cfg.DB.Pool.Timeout)
+	}
+}
+
+func TestBindReportsEveryError(t *testing.T) {
+	t.Setenv("TEST_MODE", "staging")
+	t.Setenv("TEST_DEBUG", "maybe")
+	c := &ConfigMap{data: map[string]interface{}{
+		"db": map[string]interface{}{"pool": map[string]interface{}{"max_conns": float64(500)}},
+	}}
+
+	var cfg testConfig
+	err := c.Bind(&cfg)
+	var bindErr *BindError
+	if !errors.As(err, &bindErr) {
+		t.Fatalf("expected a BindError, got %v", err)
+	}
+	keys := map[string]bool{}
+	for _, f := range bindErr.Fields {
+		keys[f.Key] = true
+	}
+	for _, key := range []string{"TEST_MODE", "TEST_DEBUG", "TEST_DATABASE_URL", "db.pool.max_conns"} {
+		if !keys[key] {
+			t.Errorf("expected an error for %s in %v", key, err)
+		}
+	}
+	if !errors.Is(err, ErrRequired) {
+		t.Error("expected the missing URL to match ErrRequired")
+	}
+	if !strings.Contains(err.Error(), "4 setting(s)") {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
+
+type testRangeConfig struct {
+	Min int `json:"min" default:"1"`
+	Max int `json:"max" default:"0"`
+}
+
+func (c *testRangeConfig) Validate() error {
+	if c.Max < c.Min {
+		return errors.New("max must not be below min")
+	}
+	return nil
+}
+
+func TestBindRunsValidators(t *testing.T) {
+	var cfg testRangeConfig
+	err := (&ConfigMap{}).Bind(&cfg)
+	if err == nil || !strings.Contains(err.Error(), "max must not be below min") {
+		t.Fatalf("expected the validator error, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-std/internal/utils"
+)
+
+const (
+	defaultCacheTTL           = time.Hour
+	defaultCacheSweepInterval = time.Minute
+)
+
+// OpenCache creates the shared Cache and RateLimitStore selected by
+// CACHE_BACKEND:
+//
+//	memory    per process TTLMaps (default)
+//	postgres  UNLOGGED tables shared by every node
+//	redis     Redis or Valkey server at REDIS_URL, redis://[user:password@]host:port[/db]
+//
+// CACHE_TTL sets how long cache entries live and CACHE_SWEEP_INTERVAL how
+// often expired rows are deleted, both as Go durations. REDIS_POOL_SIZE limits
+// the connections to Redis and REDIS_PREFIX namespaces its keys.
+func (a *App) OpenCache() error {
+	ttl, err := a.duration("CACHE_TTL", defaultCacheTTL)
+	if err != nil {
+		return err
+	}
+	sweepInterval, err := a.duration("CACHE_SWEEP_INTERVAL", defaultCacheSweepInterval)
+	if err != nil {
+		return err
+	}
+
+	switch backend := strings.ToLower(a.Env.GetString("CACHE_BACKEND")); backend {
+	case "", "memory":
+		a.Cache = utils.NewTTLMap[string, interface{}](10000, ttl, "cache")
+		// One store for every limiter, whose keys are prefixed by limiter
+		a.RateLimitStore = utils.NewMemoryRateLimitStore("rate_limits", 100000, ttl)
+	case "postgres":
+		a.Cache = utils.NewPostgresCache(a.Queries, ttl, sweepInterval)
+		a.RateLimitStore = utils.NewPostgresRateLimitStore(a.DB, a.Queries, sweepInterval)
+	case "redis":
+		opts, err := utils.ParseRESPURL(a.Env.GetString("REDIS_URL"))
+		if err != nil {
+			return fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		if v := a.Env.GetString("REDIS_POOL_SIZE"); v != "" {
+			if opts.PoolSize, err = strconv.Atoi(v); err != nil || opts.PoolSize <= 0 {
+				return fmt.Errorf("invalid REDIS_POOL_SIZE %q", v)
+			}
+		}
+		prefix := a.Env.GetString("REDIS_PREFIX")
+		if prefix == "" {
+			prefix = "go-std:"
+		}
+		// The cache and the limiters share one pool, which is closed with the cache
+		client := utils.NewRESPClient(opts)
+		a.Cache = utils.NewRedisCache(client, prefix+"cache:", ttl)
+		a.RateLimitStore = utils.NewRedisRateLimitStore(client, prefix+"rate_limit:")
+	default:
+		return fmt.Errorf("unknown CACHE_BACKEND %q", backend)
+	}
+
+	return a.openIPBans()
+}
+
+// openIPBans creates the IP ban list. IP_BAN_THRESHOLD auth failures within
+// IP_BAN_WINDOW ban an IP for IP_BAN_DURATION.
+func (a *App) openIPBans() error {
+	var settings utils.IPBanSettings
+	var err error
+	if v := a.Env.GetString("IP_BAN_THRESHOLD"); v != "" {
+		if settings.Threshold, err = strconv.Atoi(v); err != nil || settings.Threshold <= 0 {
+			return fmt.Errorf("invalid IP_BAN_THRESHOLD %q", v)
+		}
+	}
+	if settings.Window, err = a.duration("IP_BAN_WINDOW", time.Minute*10); err != nil {
+		return err
+	}
+	if settings.Duration, err = a.duration("IP_BAN_DURATION", time.Hour); err != nil {
+		return err
+	}
+
+	var opts []utils.RateLimiterOption
+	if a.RateLimitStore != nil {
+		opts = append(opts, utils.WithRateLimitStore(a.RateLimitStore))
+	}
+	a.IPBans = utils.NewIPBanList(a.Cache, settings, opts...)
+	return nil
+}
+
+// CloseCache stops the background work of the cache backends
+func (a *App) CloseCache() {
+	for _, c := range []interface{}{a.Cache, a.RateLimitStore} {
+		if closer, ok := c.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+func (a *App) duration(key string, fallback time.Duration) (time.Duration, error) {
+	v := a.Env.GetString(key)
+	if v == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return d, nil
+}
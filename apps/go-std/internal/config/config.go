@@ -1,29 +1,25 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
+	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"go-std/internal/sqlc"
+	"go-std/internal/utils"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 )
 
-// Config provides a unified interface for both JSONC and environment variables
+// ConfigMap provides a unified interface for both JSONC and environment
+// variables. Keys are dot paths into the JSONC, e.g. "db.pool.max_conns",
+// and the environment overrides them, see EnvKey.
 type ConfigMap struct {
-	// Cache commonly used values
-	cachedValues struct {
-		port   int
-		debug  bool
-		apiKey string
-		// etc...
-	}
 	// Raw data
 	data map[string]interface{}
 }
@@ -33,6 +29,12 @@ type App struct {
 	IsDev   bool
 	Queries *sqlc.Queries
 	Env     *ConfigMap
+	// Cache and RateLimitStore are set by OpenCache and closed by
+	// CloseCache. Without them limiters keep state in stores of their own.
+	Cache          utils.Cache
+	RateLimitStore utils.RateLimitStore
+	// IPBans is set by OpenCache and keeps its bans in Cache
+	IPBans *utils.IPBanList
 }
 
 var (
@@ -86,17 +88,6 @@ func Config() (*ConfigMap, error) {
 			return
 		}
 
-		// Cache frequently used values from env
-		if port := os.Getenv("PORT"); port != "" {
-			if p, err := strconv.Atoi(port); err == nil {
-				instance.cachedValues.port = p
-			}
-		}
-		if port := os.Getenv("APP_PORT"); port != "" {
-			if p, err := strconv.Atoi(port); err == nil {
-				instance.cachedValues.port = p
-			}
-		}
 	})
 
 	if initErr != nil {
@@ -106,122 +97,172 @@ func Config() (*ConfigMap, error) {
 	return instance, nil
 }
 
-// LoadJSON loads and merges JSON configuration on top of environment variables
-func (c *ConfigMap) LoadJSON(path string) error {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return fmt.Errorf("failed to read JSON config file: %w", err)
-	}
-
-	cleanJSON := removeJSONCComments(string(data))
-
-	// Create a temporary map for the new JSON data
-	jsonData := make(map[string]interface{})
-	if err := json.Unmarshal([]byte(cleanJSON), &jsonData); err != nil {
-		return fmt.Errorf("failed to parse JSON config: %w", err)
-	}
+// LoadJSON loads JSONC files and deep merges them into the config in order,
+// so later files override single nested keys of earlier ones. Objects are
+// merged, arrays and other values are replaced.
+func (c *ConfigMap) LoadJSON(paths ...string) error {
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read JSON config file: %w", err)
+		}
 
-	// Merge JSON data into existing config
-	// JSON values will override existing values if they exist
-	for k, v := range jsonData {
-		c.data[k] = v
-	}
+		jsonData, err := parseJSONCObject(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse JSON config %s: %w", path, err)
+		}
 
-	// Update cached values if they were overridden
-	if port, err := c.GetInt("port"); port != 0 || err == nil {
-		c.cachedValues.port = port
-	}
-	if port_, err := c.GetInt("APP_PORT"); port_ != 0 || err == nil {
-		c.cachedValues.port = port_
+		if c.data == nil {
+			c.data = make(map[string]interface{})
+		}
+		deepMerge(c.data, jsonData)
 	}
-
 	return nil
 }
 
-// GetString returns string value from either env or JSONC
-func (c *ConfigMap) GetString(key string) string {
-	// First check environment
-	if val := os.Getenv(key); val != "" {
-		return val
-	}
-
-	// Then check JSONC
-	if val, ok := c.data[key]; ok {
-		switch v := val.(type) {
-		case string:
-			return v
-		default:
-			return fmt.Sprintf("%v", v)
+func deepMerge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcObj, ok := v.(map[string]interface{})
+		dstObj, isObj := dst[k].(map[string]interface{})
+		if ok && isObj {
+			deepMerge(dstObj, srcObj)
+			continue
 		}
+		dst[k] = v
 	}
+}
 
-	return ""
+// EnvKey returns the environment variable that overrides key: key upper
+// cased with each dot replaced by a double underscore, so log_level is
+// LOG_LEVEL and db.pool.max_conns is DB__POOL__MAX_CONNS. For compatibility a
+// variable named exactly like key is read when that one is not set.
+func EnvKey(key string) string {
+	return strings.ToUpper(strings.ReplaceAll(key, ".", "__"))
 }
 
-// GetInt returns int value from either env or JSONC
-func (c *ConfigMap) GetInt(key string) (int, error) {
+// lookup returns the raw value of key, an env string or a decoded JSONC value
+func (c *ConfigMap) lookup(key string) (interface{}, bool) {
 	// First check environment
+	if val := os.Getenv(EnvKey(key)); val != "" {
+		return val, true
+	}
 	if val := os.Getenv(key); val != "" {
-		return strconv.Atoi(val)
+		return val, true
 	}
 
 	// Then check JSONC
+	return c.value(key)
+}
+
+// value returns the JSONC value at the dot path key
+func (c *ConfigMap) value(key string) (interface{}, bool) {
 	if val, ok := c.data[key]; ok {
-		switch v := val.(type) {
-		case float64:
-			return int(v), nil
-		case int:
-			return v, nil
-		case string:
-			return strconv.Atoi(v)
+		return val, val != nil
+	}
+	var val interface{} = c.data
+	for _, part := range strings.Split(key, ".") {
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if val, ok = obj[part]; !ok {
+			return nil, false
 		}
 	}
+	return val, val != nil
+}
 
-	return 0, fmt.Errorf("key not found or invalid type: %s", key)
+// GetString returns string value from either env or JSONC
+func (c *ConfigMap) GetString(key string) string {
+	val, ok := c.lookup(key)
+	if !ok {
+		return ""
+	}
+	switch v := val.(type) {
+	case string:
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
 }
 
-// GetBool returns boolean value from either env or JSONC
-func (c *ConfigMap) GetBool(key string) (bool, error) {
-	// First check environment
-	if val := os.Getenv(key); val != "" {
-		return strconv.ParseBool(val)
+// GetStringSlice returns a list from either a comma separated env value or a
+// JSONC array or string. Empty items are dropped.
+func (c *ConfigMap) GetStringSlice(key string) []string {
+	val, ok := c.lookup(key)
+	if !ok {
+		return nil
+	}
+	var items []string
+	if err := setValue(reflect.ValueOf(&items).Elem(), val); err != nil {
+		items = []string{fmt.Sprintf("%v", val)}
 	}
 
-	// Then check JSONC
-	if val, ok := c.data[key]; ok {
-		switch v := val.(type) {
-		case bool:
-			return v, nil
-		case string:
-			return strconv.ParseBool(v)
-		case float64:
-			return v != 0, nil
-		case int:
-			return v != 0, nil
+	var result []string
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
 		}
 	}
+	return result
+}
 
-	return false, fmt.Errorf("key not found or invalid type: %s", key)
+// GetStringMap returns a map from either a "key=value,..." env value or a
+// JSONC object
+func (c *ConfigMap) GetStringMap(key string) (map[string]string, error) {
+	var m map[string]string
+	return m, c.get(key, &m)
 }
 
-// removeJSONCComments removes JSONC style comments
-func removeJSONCComments(input string) string {
-	lines := strings.Split(input, "\n")
-	var result []string
+// GetDuration returns a duration such as "30s" from either env or JSONC
+func (c *ConfigMap) GetDuration(key string) (time.Duration, error) {
+	var d time.Duration
+	return d, c.get(key, &d)
+}
 
-	for _, line := range lines {
-		if idx := strings.Index(line, "//"); idx >= 0 {
-			line = line[:idx]
-		}
-		if strings.TrimSpace(line) != "" {
-			result = append(result, line)
+// GetInt returns int value from either env or JSONC
+func (c *ConfigMap) GetInt(key string) (int, error) {
+	var n int
+	return n, c.get(key, &n)
+}
+
+// GetBool returns boolean value from either env or JSONC
+func (c *ConfigMap) GetBool(key string) (bool, error) {
+	var b bool
+	if val, ok := c.lookup(key); ok {
+		// JSONC numbers are accepted as flags
+		if n, isNumber := val.(float64); isNumber {
+			return n != 0, nil
 		}
 	}
+	return b, c.get(key, &b)
+}
 
-	return strings.Join(result, "\n")
+// get converts the value of key into the value v points to, like Bind
+// converts fields
+func (c *ConfigMap) get(key string, v interface{}) error {
+	val, ok := c.lookup(key)
+	if !ok {
+		return fmt.Errorf("key not found or invalid type: %s", key)
+	}
+	if err := setValue(reflect.ValueOf(v).Elem(), val); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
 }
 
-// Use cached values
+// Port returns APP_PORT from either env or JSONC, else port from JSONC, else
+// PORT from env, or 0
 func (c *ConfigMap) Port() int {
-	return c.cachedValues.port // Direct access, no lookup
+	if port, err := c.GetInt("APP_PORT"); err == nil {
+		return port
+	}
+	var port int
+	if val, ok := c.value("port"); ok && setValue(reflect.ValueOf(&port).Elem(), val) == nil {
+		return port
+	}
+	if port, err := c.GetInt("PORT"); err == nil {
+		return port
+	}
+	return 0
 }
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadJSONDeepMerges(t *testing.T) {
+	base := writeConfig(t, "base.jsonc", `{
+		"app_name": "go-std",
+		"db": {"pool": {"max_conns": 10, "idle_timeout": "5m"}, "hosts": ["a", "b"]},
+	}`)
+	local := writeConfig(t, "local.jsonc", `{
+		"db": {"pool": {"max_conns": 20}, "hosts": ["c"]},
+	}`)
+
+	c := &ConfigMap{}
+	if err := c.LoadJSON(base, local); err != nil {
+		t.Fatalf("LoadJSON: %v", err)
+	}
+	if n, err := c.GetInt("db.pool.max_conns"); err != nil || n != 20 {
+		t.Fatalf("expected the later file to win, got %d, %v", n, err)
+	}
+	if d, err := c.GetDuration("db.pool.idle_timeout"); err != nil || d != 5*time.Minute {
+		t.Fatalf("expected the merge to keep sibling keys, got %s, %v", d, err)
+	}
+	if got := c.GetStringSlice("db.hosts"); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Fatalf("expected arrays to be replaced, got %v", got)
+	}
+	if got := c.GetString("app_name"); got != "go-std" {
+		t.Fatalf("got app_name %q", got)
+	}
+	if got := c.GetString("db.pool.missing"); got != "" {
+		t.Fatalf("expected no value for a missing key, got %q", got)
+	}
+}
+
+func TestEnvOverridesNestedKeys(t *testing.T) {
+	t.Setenv("DB__POOL__MAX_CONNS", "50")
+	t.Setenv("DB__LABELS", "team=auth, tier=1")
+	c := &ConfigMap{data: map[string]interface{}{
+		"db": map[string]interface{}{
+			"pool":   map[string]interface{}{"max_conns": float64(10)},
+			"labels": map[string]interface{}{"team": "core"},
+		},
+	}}
+
+	if EnvKey("db.pool.max_conns") != "DB__POOL__MAX_CONNS" || EnvKey("log_level") != "LOG_LEVEL" {
+		t.Fatal("unexpected env key mapping")
+	}
+	if n, err := c.GetInt("db.pool.max_conns"); err != nil || n != 50 {
+		t.Fatalf("expected env to override JSONC, got %d, %v", n, err)
+	}
+	labels, err := c.GetStringMap("db.labels")
+	if err != nil || !reflect.DeepEqual(labels, map[string]string{"team": "auth", "tier": "1"}) {
+		t.Fatalf("got labels %v, %v", labels, err)
+	}
+
+	var cfg struct {
+		DB struct {
+			Pool struct {
+				MaxConns int `json:"max_conns"`
+			} `json:"pool"`
+		} `json:"db"`
+	}
+	if err := c.Bind(&cfg); err != nil || cfg.DB.Pool.MaxConns != 50 {
+		t.Fatalf("expected Bind to apply the env override, got %d, %v", cfg.DB.Pool.MaxConns, err)
+	}
+}
+
+func TestEnvOverridesTopLevelKeys(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("app_name", "legacy")
+	c := &ConfigMap{data: map[string]interface{}{"log_level": "info", "app_name": "go-std"}}
+	if got := c.GetString("log_level"); got != "debug" {
+		t.Fatalf("expected LOG_LEVEL to override log_level, got %q", got)
+	}
+	if got := c.GetString("app_name"); got != "legacy" {
+		t.Fatalf("expected the verbatim variable as a fallback, got %q", got)
+	}
+}
+
+func TestPort(t *testing.T) {
+	t.Setenv("APP_PORT", "")
+	t.Setenv("PORT", "3000")
+	c := &ConfigMap{data: map[string]interface{}{"port": float64(8080)}}
+	if c.Port() != 8080 {
+		t.Fatalf("expected the JSONC port to win over PORT, got %d", c.Port())
+	}
+	if got := (&ConfigMap{}).Port(); got != 3000 {
+		t.Fatalf("expected PORT without a JSONC port, got %d", got)
+	}
+	t.Setenv("APP_PORT", "9000")
+	if c.Port() != 9000 {
+		t.Fatalf("expected APP_PORT to win, got %d", c.Port())
+	}
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-std/internal/utils"
+)
+
+const (
+	sessionCookieMaxAge = time.Hour * 24 * 30
+	csrfCookieMaxAge    = time.Hour
+	oauthCookieMaxAge   = time.Minute * 10
+)
+
+// SessionCookiePolicy is the policy for the session token cookie
+func (a *App) SessionCookiePolicy() utils.CookiePolicy {
+	return a.CookiePolicy("SESSION", "session_token", sessionCookieMaxAge)
+}
+
+// CSRFCookiePolicy is the policy for the CSRF HMAC cookie
+func (a *App) CSRFCookiePolicy() utils.CookiePolicy {
+	return a.CookiePolicy("CSRF", utils.CsrfCookieName, csrfCookieMaxAge)
+}
+
+// CSRFKeys reads the keys signing CSRF tokens from CSRF_KEYS ("id:secret,..."
+// with the signing key first), falling back to COOKIE_KEYS. Keys are derived
+// per purpose, so sharing them with the cookies is safe.
+func (a *App) CSRFKeys() (*utils.KeyRing, error) {
+	return a.KeyRing("CSRF_KEYS", "COOKIE_KEYS")
+}
+
+// CookieKeys reads the keys of the cookie codec from COOKIE_KEYS
+func (a *App) CookieKeys() (*utils.KeyRing, error) {
+	return a.KeyRing("COOKIE_KEYS")
+}
+
+// KeyRing parses the first of settings that is set. Without any, development
+// gets a random key ring and other environments an error, since random keys
+// break cookies and tokens on every restart and across nodes.
+func (a *App) KeyRing(settings ...string) (*utils.KeyRing, error) {
+	for _, setting := range settings {
+		if spec := a.Env.GetString(setting); spec != "" {
+			keys, err := utils.ParseKeyRing(spec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", setting, err)
+			}
+			return keys, nil
+		}
+	}
+	if !a.IsDev {
+		return nil, fmt.Errorf("%s must be set outside development", strings.Join(settings, " or "))
+	}
+	return utils.EphemeralKeyRing()
+}
+
+// OAuthCookiePolicy is the policy for the cookie binding an OAuth transaction to the browser
+func (a *App) OAuthCookiePolicy() utils.CookiePolicy {
+	return a.CookiePolicy("OAUTH", "oauth_tx", oauthCookieMaxAge)
+}
+
+// CookiePolicy builds a cookie policy from config. Every setting is read from
+// <PREFIX>_COOKIE_<SETTING> first and then from COOKIE_<SETTING>, so dev and
+// prod only differ by config:
+//
+//	NAME         cookie name (per cookie only)
+//	MAX_AGE      lifetime in seconds
+//	PERSISTENT   write MaxAge/Expires instead of a browser-session cookie (default true)
+//	DOMAIN       Domain attribute (default none)
+//	PATH         Path attribute (default /)
+//	SAMESITE     lax, strict or none (default lax)
+//	SECURE       Secure attribute (default true outside development)
+//	HOST_PREFIX  use the __Host- prefix (default true outside development)
+func (a *App) CookiePolicy(prefix string, defaultName string, defaultMaxAge time.Duration) utils.CookiePolicy {
+	policy := utils.DefaultCookiePolicy(defaultName, a.IsDev)
+	policy.MaxAge = int(defaultMaxAge.Seconds())
+
+	get := func(setting string) string {
+		if v := a.Env.GetString(prefix + "_COOKIE_" + setting); v != "" {
+			return v
+		}
+		if setting == "NAME" {
+			return ""
+		}
+		return a.Env.GetString("COOKIE_" + setting)
+	}
+	getBool := func(setting string, fallback bool) bool {
+		if b, err := strconv.ParseBool(get(setting)); err == nil {
+			return b
+		}
+		return fallback
+	}
+
+	if name := get("NAME"); name != "" {
+		policy.Name = name
+	}
+	if maxAge, err := strconv.Atoi(get("MAX_AGE")); err == nil {
+		policy.MaxAge = maxAge
+	}
+	if path := get("PATH"); path != "" {
+		policy.Path = path
+	}
+	policy.Domain = get("DOMAIN")
+	policy.Persistent = getBool("PERSISTENT", policy.Persistent)
+	policy.Secure = getBool("SECURE", policy.Secure)
+	policy.HostPrefix = getBool("HOST_PREFIX", policy.HostPrefix)
+
+	switch strings.ToLower(get("SAMESITE")) {
+	case "strict":
+		policy.SameSite = http.SameSiteStrictMode
+	case "none":
+		// SameSite=None is rejected by browsers unless the cookie is Secure
+		policy.SameSite = http.SameSiteNoneMode
+		policy.Secure = true
+	case "lax":
+		policy.SameSite = http.SameSiteLaxMode
+	}
+
+	return policy
+}
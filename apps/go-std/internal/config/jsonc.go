@@ -0,0 +1,294 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// SyntaxError describes where a JSONC document is malformed
+type SyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// ParseJSONC parses a JSON document that may contain // line comments,
+// /* block */ comments and trailing commas in objects and arrays. Values are
+// decoded like encoding/json decodes into an interface{}: objects become
+// map[string]interface{}, arrays []interface{} and numbers float64.
+func ParseJSONC(data []byte) (interface{}, error) {
+	p := &jsoncParser{data: data}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.data) {
+		return nil, p.errorf("unexpected %s after top-level value", p.describe())
+	}
+	return v, nil
+}
+
+// parseJSONCObject parses a config file, which must hold an object
+func parseJSONCObject(data []byte) (map[string]interface{}, error) {
+	v, err := ParseJSONC(data)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, &SyntaxError{Line: 1, Column: 1, Msg: "config must be a JSON object"}
+	}
+	return obj, nil
+}
+
+type jsoncParser struct {
+	data []byte
+	pos  int
+}
+
+// errorf reports an error at the current position
+func (p *jsoncParser) errorf(format string, args ...interface{}) error {
+	return p.errorAt(p.pos, format, args...)
+}
+
+func (p *jsoncParser) errorAt(offset int, format string, args ...interface{}) error {
+	line, col := 1, 1
+	for i := 0; i < offset && i < len(p.data); {
+		r, size := utf8.DecodeRune(p.data[i:])
+		if r == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+		i += size
+	}
+	return &SyntaxError{Line: line, Column: col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *jsoncParser) describe() string {
+	if p.pos >= len(p.data) {
+		return "end of input"
+	}
+	r, _ := utf8.DecodeRune(p.data[p.pos:])
+	return strconv.QuoteRune(r)
+}
+
+// skip moves past whitespace and comments
+func (p *jsoncParser) skip() error {
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			p.pos++
+		case c == '/' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '/':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '/' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '*':
+			start := p.pos
+			p.pos += 2
+			for {
+				if p.pos+1 >= len(p.data) {
+					return p.errorAt(start, "unterminated block comment")
+				}
+				if p.data[p.pos] == '*' && p.data[p.pos+1] == '/' {
+					p.pos += 2
+					break
+				}
+				p.pos++
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *jsoncParser) value() (interface{}, error) {
+	if p.pos >= len(p.data) {
+		return nil, p.errorf("unexpected end of input, expected a value")
+	}
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		return p.object()
+	case c == '[':
+		return p.array()
+	case c == '"':
+		return p.string()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.number()
+	case p.literal("true"):
+		return true, nil
+	case p.literal("false"):
+		return false, nil
+	case p.literal("null"):
+		return nil, nil
+	}
+	return nil, p.errorf("unexpected %s, expected a value", p.describe())
+}
+
+func (p *jsoncParser) literal(word string) bool {
+	if len(p.data)-p.pos < len(word) || string(p.data[p.pos:p.pos+len(word)]) != word {
+		return false
+	}
+	p.pos += len(word)
+	return true
+}
+
+func (p *jsoncParser) object() (interface{}, error) {
+	obj := make(map[string]interface{})
+	p.pos++ // {
+	for {
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.data) && p.data[p.pos] == '}' {
+			p.pos++
+			return obj, nil
+		}
+		if p.pos >= len(p.data) || p.data[p.pos] != '"' {
+			return nil, p.errorf("unexpected %s, expected a string key or '}'", p.describe())
+		}
+		key, err := p.string()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.data) || p.data[p.pos] != ':' {
+			return nil, p.errorf("unexpected %s, expected ':' after key %q", p.describe(), key)
+		}
+		p.pos++
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		obj[key] = v
+		if done, err := p.separator('}'); err != nil || done {
+			return obj, err
+		}
+	}
+}
+
+func (p *jsoncParser) array() (interface{}, error) {
+	arr := []interface{}{}
+	p.pos++ // [
+	for {
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.data) && p.data[p.pos] == ']' {
+			p.pos++
+			return arr, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+		if done, err := p.separator(']'); err != nil || done {
+			return arr, err
+		}
+	}
+}
+
+// separator consumes the ',' after a member, or the closing bracket. A comma
+// directly before the closing bracket is left for the caller to accept.
+func (p *jsoncParser) separator(closing byte) (bool, error) {
+	if err := p.skip(); err != nil {
+		return false, err
+	}
+	if p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ',':
+			p.pos++
+			return false, nil
+		case closing:
+			p.pos++
+			return true, nil
+		}
+	}
+	return false, p.errorf("unexpected %s, expected ',' or '%c'", p.describe(), closing)
+}
+
+func (p *jsoncParser) string() (string, error) {
+	start := p.pos
+	p.pos++ // "
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case c == '\\':
+			p.pos += 2
+		case c == '"':
+			p.pos++
+			// encoding/json handles escapes, surrogate pairs and invalid UTF-8
+			var s string
+			if err := json.Unmarshal(p.data[start:p.pos], &s); err != nil {
+				return "", p.errorAt(start, "invalid string: %v", err)
+			}
+			return s, nil
+		case c < 0x20:
+			return "", p.errorf("control character in string")
+		default:
+			p.pos++
+		}
+	}
+	return "", p.errorAt(start, "unterminated string")
+}
+
+func (p *jsoncParser) number() (interface{}, error) {
+	start := p.pos
+	digits := func() int {
+		n := 0
+		for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+			p.pos++
+			n++
+		}
+		return n
+	}
+
+	if p.data[p.pos] == '-' {
+		p.pos++
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '0' {
+		p.pos++
+	} else if digits() == 0 {
+		return nil, p.errorAt(start, "invalid number")
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '.' {
+		p.pos++
+		if digits() == 0 {
+			return nil, p.errorAt(start, "invalid number")
+		}
+	}
+	if p.pos < len(p.data) && (p.data[p.pos] == 'e' || p.data[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.data) && (p.data[p.pos] == '+' || p.data[p.pos] == '-') {
+			p.pos++
+		}
+		if digits() == 0 {
+			return nil, p.errorAt(start, "invalid number")
+		}
+	}
+
+	f, err := strconv.ParseFloat(string(p.data[start:p.pos]), 64)
+	if err != nil {
+		return nil, p.errorAt(start, "invalid number %s", p.data[start:p.pos])
+	}
+	return f, nil
+}
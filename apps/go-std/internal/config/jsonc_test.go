@@ -0,0 +1,88 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseJSONC(t *testing.T) {
+	input := `// app settings
+{
+	"url": "https://example.com/path", // the // in the URL is kept
+	"glob": "/* not a comment */",
+	"quote": "say \"hi\" \\", /* block
+	comment */ "origins": [
+		"https://a.example.com",
+		"https://b.example.com", // trailing comma
+	],
+	"pool": {"max_conns": 20, "ratio": -1.5e2, "debug": false, "empty": null,},
+}
+`
+	got, err := ParseJSONC([]byte(input))
+	if err != nil {
+		t.Fatalf("ParseJSONC: %v", err)
+	}
+	want := map[string]interface{}{
+		"url":     "https://example.com/path",
+		"glob":    "/* not a comment */",
+		"quote":   `say "hi" \`,
+		"origins": []interface{}{"https://a.example.com", "https://b.example.com"},
+		"pool": map[string]interface{}{
+			"max_conns": float64(20),
+			"ratio":     float64(-150),
+			"debug":     false,
+			"empty":     nil,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestParseJSONCErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		line, col int
+	}{
+		{"missing comma", "{\n  \"a\": 1\n  \"b\": 2\n}", 3, 3},
+		{"double comma", `{"a": 1,,}`, 1, 9},
+		{"unterminated string", "{\n\"a\": \"https://x", 2, 6},
+		{"unterminated comment", "{} /* open", 1, 4},
+		{"bad literal", `{"a": tru}`, 1, 7},
+		{"bad number", `[1.]`, 1, 2},
+		{"trailing data", `{} {}`, 1, 4},
+		{"not an object", `[1]`, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseJSONCObject([]byte(tt.input))
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("expected a SyntaxError, got %v", err)
+			}
+			if syntaxErr.Line != tt.line || syntaxErr.Column != tt.col {
+				t.Fatalf("got %v, want line %d, column %d", err, tt.line, tt.col)
+			}
+		})
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.jsonc")
+	os.WriteFile(path, []byte(`{
+		// served behind the proxy
+		"PUBLIC_URL": "https://app.example.com",
+	}`), 0o600)
+
+	c := &ConfigMap{data: make(map[string]interface{})}
+	if err := c.LoadJSON(path); err != nil {
+		t.Fatalf("LoadJSON: %v", err)
+	}
+	if got := c.GetString("PUBLIC_URL"); got != "https://app.example.com" {
+		t.Fatalf("got %q", got)
+	}
+}
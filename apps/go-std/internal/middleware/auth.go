@@ -7,7 +7,7 @@ import (
 
 func (a *MiddlewareContext) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		valid, err := utils.ValidateSession(a.Queries, w, r)
+		valid, err := utils.ValidateSession(a.Queries, w, r, a.SessionCookiePolicy())
 		if err != nil {
 			utils.ErrorResponse(w, http.StatusUnauthorized, "Unauthorized", "UNAUTHORIZED")
 			return
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"fmt"
+	"go-std/internal/utils"
+	"log"
+	"net/http"
+)
+
+// ClientIPResolver trusts the reverse proxies listed in TRUSTED_PROXIES as
+// comma separated CIDRs, e.g. the Docker network of the Traefik proxy
+func (a *MiddlewareContext) ClientIPResolver() (*utils.ClientIPResolver, error) {
+	trusted, err := utils.ParseCIDRs(a.Env.GetString("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+	return utils.NewClientIPResolver(trusted), nil
+}
+
+// ResolveClientIP stores the client IP in the request context, where
+// utils.ClientIP reads it. Requests that already carry one are left alone.
+func (a *MiddlewareContext) ResolveClientIP(next http.HandlerFunc) http.HandlerFunc {
+	resolver, err := a.ClientIPResolver()
+	if err != nil {
+		log.Fatalf("failed to create client IP resolver: %v", err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := utils.ClientIPFromContext(r.Context()); !ok {
+			r = utils.WithClientIP(r, resolver.Resolve(r))
+		}
+		next(w, r)
+	}
+}
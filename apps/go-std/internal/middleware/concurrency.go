@@ -0,0 +1,244 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-std/internal/utils"
+)
+
+var (
+	// ErrConcurrencyQueueFull is returned when a request cannot even be queued
+	ErrConcurrencyQueueFull = errors.New("concurrency queue full")
+	// ErrConcurrencyTimeout is returned when a queued request gave up waiting
+	ErrConcurrencyTimeout = errors.New("timed out waiting for a concurrency slot")
+)
+
+// concurrencyMetrics publishes every limiter's state under "concurrency" in /debug/vars
+var concurrencyMetrics = expvar.NewMap("concurrency")
+
+// ConcurrencyOptions configures a ConcurrencyLimiter
+type ConcurrencyOptions struct {
+	// Limit is the number of requests handled at once. With TargetLatency it
+	// is the starting point and adapts between MinLimit and MaxLimit.
+	Limit int
+	// MaxQueue is the number of requests waiting for a slot, more are rejected
+	MaxQueue int
+	// QueueTimeout is how long a request waits for a slot before it is rejected
+	QueueTimeout time.Duration
+	// TargetLatency enables AIMD: the limit grows by one per round of requests
+	// finishing under it and shrinks by DecreaseFactor when they take longer
+	TargetLatency  time.Duration
+	MinLimit       int
+	MaxLimit       int
+	DecreaseFactor float64
+}
+
+// ConcurrencyLimiter caps the number of in-flight requests and queues the
+// excess in FIFO order for a bounded time
+type ConcurrencyLimiter struct {
+	name string
+	opts ConcurrencyOptions
+
+	mu           sync.Mutex
+	limit        float64
+	inFlight     int
+	queue        *list.List
+	lastDecrease time.Time
+	rejected     uint64
+	timeouts     uint64
+}
+
+func NewConcurrencyLimiter(name string, opts ConcurrencyOptions) *ConcurrencyLimiter {
+	opts.Limit = max(opts.Limit, 1)
+	opts.MaxQueue = max(opts.MaxQueue, 0)
+	if opts.TargetLatency > 0 {
+		if opts.MinLimit <= 0 {
+			opts.MinLimit = 1
+		}
+		if opts.MaxLimit < opts.Limit {
+			opts.MaxLimit = opts.Limit * 4
+		}
+		if opts.DecreaseFactor <= 0 || opts.DecreaseFactor >= 1 {
+			opts.DecreaseFactor = 0.9
+		}
+	}
+
+	l := &ConcurrencyLimiter{
+		name:  name,
+		opts:  opts,
+		limit: float64(opts.Limit),
+		queue: list.New(),
+	}
+	concurrencyMetrics.Set(name, expvar.Func(func() any { return l.Stats() }))
+	return l
+}
+
+// ConcurrencyStats is a snapshot of a limiter
+type ConcurrencyStats struct {
+	Limit      int    `json:"limit"`
+	InFlight   int    `json:"in_flight"`
+	QueueDepth int    `json:"queue_depth"`
+	Rejected   uint64 `json:"rejected"`
+	Timeouts   uint64 `json:"timeouts"`
+}
+
+func (l *ConcurrencyLimiter) Stats() ConcurrencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ConcurrencyStats{
+		Limit:      int(l.limit),
+		InFlight:   l.inFlight,
+		QueueDepth: l.queue.Len(),
+		Rejected:   l.rejected,
+		Timeouts:   l.timeouts,
+	}
+}
+
+// Acquire waits for a slot. The returned release must be called with the
+// request's latency once it is done.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) (func(latency time.Duration), error) {
+	l.mu.Lock()
+	if l.inFlight < int(l.limit) {
+		l.inFlight++
+		l.mu.Unlock()
+		return l.release, nil
+	}
+	if l.queue.Len() >= l.opts.MaxQueue {
+		l.rejected++
+		l.mu.Unlock()
+		return nil, ErrConcurrencyQueueFull
+	}
+	ready := make(chan struct{})
+	el := l.queue.PushBack(ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.opts.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return l.release, nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// The slot was handed over while giving up, hand it on
+		l.inFlight--
+		l.grant()
+	default:
+		l.queue.Remove(el)
+	}
+	l.timeouts++
+	return nil, ErrConcurrencyTimeout
+}
+
+func (l *ConcurrencyLimiter) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	if l.opts.TargetLatency > 0 {
+		l.adapt(latency)
+	}
+	l.grant()
+}
+
+// grant hands free slots to queued requests. The lock must be held.
+func (l *ConcurrencyLimiter) grant() {
+	for l.inFlight < int(l.limit) && l.queue.Len() > 0 {
+		ready := l.queue.Remove(l.queue.Front()).(chan struct{})
+		l.inFlight++
+		close(ready)
+	}
+}
+
+// adapt grows the limit additively while requests meet the target latency and
+// shrinks it multiplicatively, at most once per target latency, when they do
+// not. The lock must be held.
+func (l *ConcurrencyLimiter) adapt(latency time.Duration) {
+	if latency <= l.opts.TargetLatency {
+		l.limit = math.Min(l.limit+1/l.limit, float64(l.opts.MaxLimit))
+		return
+	}
+	now := time.Now()
+	if now.Sub(l.lastDecrease) < l.opts.TargetLatency {
+		return
+	}
+	l.lastDecrease = now
+	l.limit = math.Max(l.limit*l.opts.DecreaseFactor, float64(l.opts.MinLimit))
+}
+
+// ConcurrencyLimit sheds requests that cannot get a slot of l in time with 503
+// and a Retry-After of the queue timeout
+func ConcurrencyLimit(l *ConcurrencyLimiter) Middleware {
+	retryAfter := strconv.Itoa(max(ceilSeconds(l.opts.QueueTimeout), 1))
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			release, err := l.Acquire(r.Context())
+			if err != nil {
+				logger.Warn("concurrency limit %s: %v: %s %s", l.name, err, r.Method, r.URL.Path)
+				w.Header().Set("Retry-After", retryAfter)
+				utils.ErrorResponse(w, http.StatusServiceUnavailable, "Server is busy, try again later", "OVERLOADED")
+				return
+			}
+			start := time.Now()
+			defer func() { release(time.Since(start)) }()
+			next(w, r)
+		}
+	}
+}
+
+// ConcurrencyLimiter builds a limiter that can be overridden with
+// CONCURRENCY_<NAME> in the form "<limit>/<queue>/<timeout>", e.g. "64/128/2s".
+// CONCURRENCY_<NAME>_TARGET_LATENCY enables adapting the limit, e.g. "250ms".
+func (a *MiddlewareContext) ConcurrencyLimiter(name string, opts ConcurrencyOptions) *ConcurrencyLimiter {
+	setting := "CONCURRENCY_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	if v := a.Env.GetString(setting); v != "" {
+		limit, queue, timeout, err := parseConcurrency(v)
+		if err != nil {
+			logger.Warn("ignoring invalid %s: %v", setting, err)
+		} else {
+			opts.Limit, opts.MaxQueue, opts.QueueTimeout = limit, queue, timeout
+		}
+	}
+	if v := a.Env.GetString(setting + "_TARGET_LATENCY"); v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			logger.Warn("ignoring invalid %s_TARGET_LATENCY %q", setting, v)
+		} else {
+			opts.TargetLatency = d
+		}
+	}
+	return NewConcurrencyLimiter(name, opts)
+}
+
+func parseConcurrency(s string) (int, int, time.Duration, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf("expected <limit>/<queue>/<timeout>, got %q", s)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || limit <= 0 {
+		return 0, 0, 0, fmt.Errorf("invalid limit %q", parts[0])
+	}
+	queue, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil || queue < 0 {
+		return 0, 0, 0, fmt.Errorf("invalid queue %q", parts[1])
+	}
+	timeout, err := time.ParseDuration(strings.TrimSpace(parts[2]))
+	if err != nil || timeout < 0 {
+		return 0, 0, 0, fmt.Errorf("invalid timeout %q", parts[2])
+	}
+	return limit, queue, timeout, nil
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimitQueuesAndSheds(t *testing.T) {
+	l := NewConcurrencyLimiter("test-shed", ConcurrencyOptions{Limit: 1, MaxQueue: 1, QueueTimeout: time.Second})
+
+	unblock := make(chan struct{})
+	entered := make(chan struct{}, 2)
+	h := ConcurrencyLimit(l)(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-unblock
+	})
+
+	codes := make(chan int, 2)
+	var wg sync.WaitGroup
+	serve := func() {
+		defer wg.Done()
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		codes <- rec.Code
+	}
+
+	wg.Add(1)
+	go serve()
+	<-entered
+	wg.Add(1)
+	go serve()
+	for l.Stats().QueueDepth != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Slot and queue are taken, the next request is shed right away
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 503 with Retry-After, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	close(unblock)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("expected queued request to be served, got %d", code)
+		}
+	}
+	if s := l.Stats(); s.InFlight != 0 || s.QueueDepth != 0 || s.Rejected != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestConcurrencyLimiterQueueTimeout(t *testing.T) {
+	l := NewConcurrencyLimiter("test-timeout", ConcurrencyOptions{Limit: 1, MaxQueue: 1, QueueTimeout: time.Millisecond * 10})
+
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	if _, err := l.Acquire(context.Background()); err != ErrConcurrencyTimeout {
+		t.Fatalf("expected ErrConcurrencyTimeout, got %v", err)
+	}
+	release(0)
+	if s := l.Stats(); s.InFlight != 0 || s.QueueDepth != 0 || s.Timeouts != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestConcurrencyLimiterAdaptsToLatency(t *testing.T) {
+	l := NewConcurrencyLimiter("test-aimd", ConcurrencyOptions{Limit: 10, TargetLatency: time.Millisecond * 100, MaxLimit: 20})
+
+	for i := 0; i < 50; i++ {
+		release, _ := l.Acquire(context.Background())
+		release(time.Millisecond)
+	}
+	grown := l.Stats().Limit
+	if grown <= 10 {
+		t.Fatalf("expected limit to grow under target latency, got %d", grown)
+	}
+
+	release, _ := l.Acquire(context.Background())
+	release(time.Second)
+	if shrunk := l.Stats().Limit; shrunk >= grown {
+		t.Fatalf("expected limit to shrink over target latency, got %d from %d", shrunk, grown)
+	}
+}
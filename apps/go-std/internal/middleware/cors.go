@@ -1,22 +1,277 @@
 package middleware
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"go-std/internal/utils"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/rs/cors"
 )
 
-// Create a single CORS handler instance
-var corsHandler = cors.New(cors.Options{
-	AllowedOrigins:   []string{"http://localhost:8080", "http://localhost:3001"},
-	AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-	AllowedHeaders:   []string{"Content-Type", "X-CSRF-Token"},
-	ExposedHeaders:   []string{"X-CSRF-Token"},
-	AllowCredentials: true,
-	Debug:            false,
-})
-
-// CORS middleware that uses the pre-configured handler
-func CORS(next http.HandlerFunc) http.HandlerFunc {
-	return corsHandler.Handler(next).ServeHTTP
+// registeredClientPrefix namespaces the cached registered client lookups
+const registeredClientPrefix = "cors_registered_client:"
+
+var (
+	defaultCORSDevOrigins = []string{"http://localhost:8080", "http://localhost:3001"}
+	defaultCORSMethods    = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	defaultCORSHeaders    = []string{"Content-Type", "X-CSRF-Token"}
+	defaultCORSExposed    = []string{"X-CSRF-Token"}
+)
+
+// CORSPolicy is the CORS configuration of a group of routes
+type CORSPolicy struct {
+	// AllowedOrigins are exact origins or wildcard subdomain patterns such as
+	// https://*.example.com. "*" allows every origin and cannot be combined
+	// with AllowCredentials.
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	MaxAge           time.Duration
+	AllowCredentials bool
+	// OriginValidator, if set, is asked about origins AllowedOrigins does not
+	// match, e.g. to look them up in the registered clients
+	OriginValidator func(r *http.Request, origin string) bool
+}
+
+// Validate checks the origin patterns of the policy
+func (p CORSPolicy) Validate() error {
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			if p.AllowCredentials {
+				return errors.New("the * origin cannot be combined with credentials")
+			}
+			continue
+		}
+		scheme, host, ok := strings.Cut(origin, "://")
+		if !ok || scheme == "" || host == "" || strings.Contains(host, "/") {
+			return fmt.Errorf("invalid origin %q", origin)
+		}
+		if strings.Contains(host, "*") && (strings.Count(host, "*") > 1 || !strings.HasPrefix(host, "*.")) {
+			return fmt.Errorf("invalid origin pattern %q, only a leading *. is supported", origin)
+		}
+	}
+	return nil
+}
+
+// AllowsOrigin reports whether origin matches AllowedOrigins or is accepted
+// by the OriginValidator
+func (p CORSPolicy) AllowsOrigin(r *http.Request, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		if matchOrigin(strings.ToLower(allowed), origin) {
+			return true
+		}
+	}
+	return p.OriginValidator != nil && p.OriginValidator(r, origin)
+}
+
+// matchOrigin matches an exact origin or a pattern like https://*.example.com,
+// which matches any subdomain at any depth but not example.com itself
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	if !ok || len(origin) <= len(prefix)+len(suffix) {
+		return false
+	}
+	if !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	sub := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(sub, "/:@")
+}
+
+func (p CORSPolicy) handler() *cors.Cors {
+	maxAge := int(p.MaxAge / time.Second)
+	return cors.New(cors.Options{
+		AllowOriginVaryRequestFunc: func(r *http.Request, origin string) (bool, []string) {
+			return p.AllowsOrigin(r, origin), nil
+		},
+		AllowedMethods:   p.AllowedMethods,
+		AllowedHeaders:   p.AllowedHeaders,
+		ExposedHeaders:   p.ExposedHeaders,
+		MaxAge:           maxAge,
+		AllowCredentials: p.AllowCredentials,
+	})
+}
+
+// NewCORS creates a middleware applying policy to every route
+func NewCORS(policy CORSPolicy) Middleware {
+	c := policy.handler()
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return c.Handler(next).ServeHTTP
+	}
+}
+
+// CORSGroup is a group of routes under PathPrefix with its own CORS policy
+type CORSGroup struct {
+	Name       string
+	PathPrefix string
+}
+
+// CORSPolicy reads the policy of group from the config. Every setting can be
+// overridden for a group by inserting its name, e.g. CORS_AUTH_ALLOWED_ORIGINS
+// overrides CORS_ALLOWED_ORIGINS for the auth group.
+//
+//	CORS_ALLOWED_ORIGINS     comma separated origins or https://*.example.com patterns
+//	CORS_ALLOWED_METHODS     defaults to GET, POST, PUT, DELETE, OPTIONS
+//	CORS_ALLOWED_HEADERS     defaults to Content-Type, X-CSRF-Token
+//	CORS_EXPOSED_HEADERS     defaults to X-CSRF-Token
+//	CORS_MAX_AGE             how long preflights are cached, as a Go duration
+//	CORS_ALLOW_CREDENTIALS   defaults to true
+//	CORS_REGISTERED_CLIENTS  also allow the origins in the registered_client table
+//
+// Without CORS_ALLOWED_ORIGINS only the local frontends are allowed in
+// development and no origin otherwise.
+func (a *MiddlewareContext) CORSPolicy(group string) (CORSPolicy, error) {
+	policy := CORSPolicy{
+		AllowedOrigins:   a.corsList(group, "ALLOWED_ORIGINS", nil),
+		AllowedMethods:   a.corsList(group, "ALLOWED_METHODS", defaultCORSMethods),
+		AllowedHeaders:   a.corsList(group, "ALLOWED_HEADERS", defaultCORSHeaders),
+		ExposedHeaders:   a.corsList(group, "EXPOSED_HEADERS", defaultCORSExposed),
+		AllowCredentials: true,
+	}
+	if policy.AllowedOrigins == nil && a.IsDev {
+		policy.AllowedOrigins = defaultCORSDevOrigins
+	}
+
+	if key, v := a.corsSetting(group, "MAX_AGE"); v != "" {
+		maxAge, err := time.ParseDuration(v)
+		if err != nil || maxAge < 0 {
+			return CORSPolicy{}, fmt.Errorf("invalid %s %q", key, v)
+		}
+		policy.MaxAge = maxAge
+	}
+	if key, v := a.corsSetting(group, "ALLOW_CREDENTIALS"); v != "" {
+		allow, err := strconv.ParseBool(v)
+		if err != nil {
+			return CORSPolicy{}, fmt.Errorf("invalid %s %q", key, v)
+		}
+		policy.AllowCredentials = allow
+	}
+	if key, v := a.corsSetting(group, "REGISTERED_CLIENTS"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return CORSPolicy{}, fmt.Errorf("invalid %s %q", key, v)
+		}
+		if enabled && a.Queries == nil {
+			return CORSPolicy{}, fmt.Errorf("%s requires a database", key)
+		}
+		if enabled {
+			policy.OriginValidator = a.RegisteredClientOrigins()
+		}
+	}
+
+	if err := policy.Validate(); err != nil {
+		return CORSPolicy{}, fmt.Errorf("CORS policy %q: %w", group, err)
+	}
+	return policy, nil
+}
+
+// corsSetting returns the value of CORS_<GROUP>_<name>, falling back to
+// CORS_<name>, together with the key it was read from
+func (a *MiddlewareContext) corsSetting(group, name string) (string, string) {
+	for _, key := range corsKeys(group, name) {
+		if v := a.Env.GetString(key); v != "" {
+			return key, v
+		}
+	}
+	return "CORS_" + name, ""
+}
+
+func (a *MiddlewareContext) corsList(group, name string, fallback []string) []string {
+	for _, key := range corsKeys(group, name) {
+		if list := a.Env.GetStringSlice(key); list != nil {
+			return list
+		}
+	}
+	return fallback
+}
+
+// corsKeys returns the keys of a setting, the group override first
+func corsKeys(group, name string) []string {
+	if group == "" {
+		return []string{"CORS_" + name}
+	}
+	return []string{"CORS_" + strings.ToUpper(strings.ReplaceAll(group, "-", "_")) + "_" + name, "CORS_" + name}
+}
+
+// CORS creates a middleware applying the default policy, and the policy of the
+// group with the longest matching PathPrefix to routes in a group. Preflights
+// are answered by the group policy too, so groups work without router support.
+func (a *MiddlewareContext) CORS(groups ...CORSGroup) (Middleware, error) {
+	defaultPolicy, err := a.CORSPolicy("")
+	if err != nil {
+		return nil, err
+	}
+	if len(defaultPolicy.AllowedOrigins) == 0 && defaultPolicy.OriginValidator == nil {
+		logger.Warn("CORS_ALLOWED_ORIGINS is not set, cross-origin requests are rejected")
+	}
+
+	type groupHandler struct {
+		prefix  string
+		handler *cors.Cors
+	}
+	handlers := make([]groupHandler, 0, len(groups))
+	for _, group := range groups {
+		policy, err := a.CORSPolicy(group.Name)
+		if err != nil {
+			return nil, err
+		}
+		handlers = append(handlers, groupHandler{group.PathPrefix, policy.handler()})
+	}
+	sort.SliceStable(handlers, func(i, j int) bool { return len(handlers[i].prefix) > len(handlers[j].prefix) })
+	fallback := defaultPolicy.handler()
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		grouped := make([]http.Handler, len(handlers))
+		for i, h := range handlers {
+			grouped[i] = h.handler.Handler(next)
+		}
+		ungrouped := fallback.Handler(next)
+		return func(w http.ResponseWriter, r *http.Request) {
+			for i, h := range handlers {
+				if strings.HasPrefix(r.URL.Path, h.prefix) {
+					grouped[i].ServeHTTP(w, r)
+					return
+				}
+			}
+			ungrouped.ServeHTTP(w, r)
+		}
+	}, nil
+}
+
+// RegisteredClientOrigins returns an origin validator that allows the origins
+// of the registered clients that are not revoked, so new frontends need no
+// redeploy. Lookups are cached in the app's Cache for a minute, a revoked
+// client may keep access for that long.
+func (a *MiddlewareContext) RegisteredClientOrigins() func(r *http.Request, origin string) bool {
+	return func(r *http.Request, origin string) bool {
+		key := registeredClientPrefix + origin
+		if a.Cache != nil {
+			if allowed, ok := a.Cache.Get(key); ok {
+				return allowed == true
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second*2)
+		defer cancel()
+		_, err := a.Queries.GetRegisteredClientByOrigin(ctx, origin)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			logger.Error("error looking up registered client %s: %v", origin, err)
+			return false
+		}
+		if c, ok := a.Cache.(utils.TTLCache); ok {
+			c.PutWithTTL(key, err == nil, time.Minute)
+		}
+		return err == nil
+	}
 }
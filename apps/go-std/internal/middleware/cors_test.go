@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"go-std/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	tests := []struct {
+		pattern, origin string
+		want            bool
+	}{
+		{"https://app.example.com", "https://app.example.com", true},
+		{"https://app.example.com", "http://app.example.com", false},
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://evil.com/.example.com", false},
+		{"https://*.example.com", "https://user@x.example.com", false},
+		{"https://*.example.com", "https://app.example.com.evil.com", false},
+		{"*", "https://anything.test", true},
+	}
+	for _, tt := range tests {
+		if got := matchOrigin(tt.pattern, tt.origin); got != tt.want {
+			t.Errorf("matchOrigin(%q, %q) = %v, want %v", tt.pattern, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestCORSPolicyValidate(t *testing.T) {
+	for _, p := range []CORSPolicy{
+		{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		{AllowedOrigins: []string{"example.com"}},
+		{AllowedOrigins: []string{"https://app.*.example.com"}},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("expected %v to be invalid", p.AllowedOrigins)
+		}
+	}
+}
+
+func TestCORSGroupOverrides(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com")
+	t.Setenv("CORS_ADMIN_ALLOWED_ORIGINS", "https://admin.example.com")
+	m := NewMiddlewareContext(&config.App{Env: &config.ConfigMap{}})
+	mw, err := m.CORS(CORSGroup{Name: "admin", PathPrefix: "/api/admin/"})
+	if err != nil {
+		t.Fatalf("CORS: %v", err)
+	}
+	h := mw(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		path, origin string
+		allowed      bool
+	}{
+		{"/api/auth/me", "https://app.example.com", true},
+		{"/api/auth/me", "https://admin.example.com", false},
+		{"/api/admin/ip-bans", "https://admin.example.com", true},
+		{"/api/admin/ip-bans", "https://app.example.com", false},
+	}
+	for _, tt := range tests {
+		// Preflight, which the group policy must answer as well
+		r := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+		r.Header.Set("Origin", tt.origin)
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		rec := httptest.NewRecorder()
+		h(rec, r)
+		got := rec.Header().Get("Access-Control-Allow-Origin") == tt.origin
+		if got != tt.allowed {
+			t.Errorf("%s from %s: allowed %v, want %v", tt.path, tt.origin, got, tt.allowed)
+		}
+		if tt.allowed && rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("%s from %s: expected credentials to be allowed", tt.path, tt.origin)
+		}
+	}
+}
@@ -1,15 +1,41 @@
 package middleware
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
 	"strings"
 )
 
-// CSPMiddleware: Content Security Policy and security headers, adapts to env.
-func CSPMiddleware(isDevelopment bool) func(http.HandlerFunc) http.HandlerFunc {
-	prodCspDirectives := map[string][]string{
+// CSPReportPath is where browsers send violation reports, see routes.CSPReportRoutes
+const CSPReportPath = "/csp-report"
+
+// cspReportGroup is the Reporting-Endpoints name used by report-to
+const cspReportGroup = "csp-endpoint"
+
+type cspNonceKey struct{}
+
+// DefaultCSPDirectives returns the default policy for production or development
+func DefaultCSPDirectives(isDevelopment bool) CSPDirectives {
+	if isDevelopment {
+		return CSPDirectives{
+			"default-src": {"'self'"},
+			"script-src":  {"'self'", "'unsafe-inline'", "'unsafe-eval'"},
+			"style-src":   {"'self'", "'unsafe-inline'"},
+			"img-src":     {"'self'", "data:"},
+			"font-src":    {"'self'"},
+			"connect-src": {"'self'", "ws://localhost:3000"}, // Limit to dev server
+			"form-action": {"'self'"},
+			"frame-src":   {"'none'"},
+			"object-src":  {"'none'"},
+			"base-uri":    {"'self'"},
+		}
+	}
+	return CSPDirectives{
 		"default-src": {"'none'"},
 		"script-src":  {"'self'"},
 		"style-src": {
@@ -34,58 +60,123 @@ func CSPMiddleware(isDevelopment bool) func(http.HandlerFunc) http.HandlerFunc {
 		"manifest-src":              {"'self'"},
 		"worker-src":                {"'self'"},
 	}
+}
 
-	devCspDirectives := map[string][]string{
-		"default-src": {"'self'"},
-		"script-src":  {"'self'", "'unsafe-inline'", "'unsafe-eval'"},
-		"style-src":   {"'self'", "'unsafe-inline'"},
-		"img-src":     {"'self'", "data:"},
-		"font-src":    {"'self'"},
-		"connect-src": {"'self'", "ws://localhost:3000"}, // Limit to dev server
-		"form-action": {"'self'"},
-		"frame-src":   {"'none'"},
-		"object-src":  {"'none'"},
-		"base-uri":    {"'self'"},
-	}
-
-	var selectedDirectives map[string][]string
+// CSPMiddleware: Content Security Policy and security headers, adapts to env.
+// Use MiddlewareContext.SecurityHeaders to read them from the config instead.
+func CSPMiddleware(isDevelopment bool) func(http.HandlerFunc) http.HandlerFunc {
 	if isDevelopment {
-		selectedDirectives = devCspDirectives
 		log.Println("CSP: DEVELOPMENT policy")
 	} else {
-		selectedDirectives = prodCspDirectives
 		log.Println("CSP: PRODUCTION policy")
 	}
+	return DefaultSecurityHeaders(isDevelopment).Middleware()
+}
+
+// CSPOptions configures the CSP middleware
+type CSPOptions struct {
+	// Directives is the enforced policy, none is sent if empty
+	Directives map[string][]string
+	// ReportOnly is sent as Content-Security-Policy-Report-Only next to the
+	// enforced policy, to try a stricter policy before enforcing it
+	ReportOnly map[string][]string
+	// Nonce adds a fresh 'nonce-...' to script-src and style-src of both
+	// policies on every request. Templates read it with CSPNonce.
+	Nonce bool
+	// StrictDynamic adds 'strict-dynamic' to script-src, so scripts loaded by
+	// nonced scripts may run and host allowlists are ignored. Requires Nonce.
+	StrictDynamic bool
+	// ReportURI receives violation reports through both report-uri and the
+	// Reporting API
+	ReportURI string
+	// IsDevelopment skips HSTS
+	IsDevelopment bool
+}
+
+// CSP sets the policies of opts and the basic security headers on every
+// response. SecurityHeaders configures the other headers as well.
+func CSP(opts CSPOptions) Middleware {
+	h := SecurityHeaders{
+		CSP:            opts.Directives,
+		CSPReportOnly:  opts.ReportOnly,
+		Nonce:          opts.Nonce,
+		StrictDynamic:  opts.StrictDynamic,
+		ReportURI:      opts.ReportURI,
+		ReferrerPolicy: "strict-origin-when-cross-origin",
+		FrameOptions:   "DENY",
+	}
+	if !opts.IsDevelopment {
+		h.HSTS = HSTS{MaxAge: hstsPreloadMinAge, IncludeSubDomains: true}
+	}
+	return h.Middleware()
+}
+
+// cspTemplate is a rendered policy with a placeholder for the nonce, so only
+// the nonce is filled in per request
+type cspTemplate struct {
+	parts []string
+}
+
+const cspNoncePlaceholder = "\x00nonce\x00"
+
+func newCSPTemplate(directives CSPDirectives, nonce, strictDynamic bool, reportURI string) *cspTemplate {
+	if len(directives) == 0 {
+		return nil
+	}
 
 	var policyParts []string
-	for directive, sources := range selectedDirectives {
+	for _, directive := range sortedKeys(directives) {
+		sources := append([]string(nil), directives[directive]...)
+		if nonce && (directive == "script-src" || directive == "style-src") {
+			sources = append(sources, "'nonce-"+cspNoncePlaceholder+"'")
+		}
+		if strictDynamic && directive == "script-src" {
+			sources = append(sources, "'strict-dynamic'")
+		}
 		if len(sources) > 0 {
 			policyParts = append(policyParts, fmt.Sprintf("%s %s", directive, strings.Join(sources, " ")))
 		} else {
 			policyParts = append(policyParts, directive)
 		}
 	}
-
-	if !isDevelopment {
-		policyParts = append(policyParts, "report-uri /csp-report") // Important!
+	if reportURI != "" {
+		policyParts = append(policyParts, "report-uri "+reportURI, "report-to "+cspReportGroup)
 	}
-	cspValue := strings.Join(policyParts, "; ")
-
-	return func(next http.HandlerFunc) http.HandlerFunc {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Security-Policy", cspValue)
-			w.Header().Set("X-Content-Type-Options", "nosniff")
-			w.Header().Set("X-Frame-Options", "DENY")
-			w.Header().Set("Referrer-Policy", "strict-origin-when-cross-origin")
-			w.Header().Set("X-XSS-Protection", "0")
-
-			if !isDevelopment && (r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https") {
-				maxAgeSeconds := 31536000
-				hstsValue := fmt.Sprintf("max-age=%d; includeSubDomains", maxAgeSeconds)
-				w.Header().Set("Strict-Transport-Security", hstsValue)
-			}
+	return &cspTemplate{parts: strings.Split(strings.Join(policyParts, "; "), cspNoncePlaceholder)}
+}
+
+func (t *cspTemplate) render(nonce string) string {
+	return strings.Join(t.parts, nonce)
+}
+
+// newCSPNonce returns 128 random bits. CSP allows the URL safe base64
+// alphabet, which templates do not need to escape in attributes.
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
 
-			next(w, r)
-		})
+// CSPNonce returns the nonce of the request, "" if the CSP middleware did not
+// add one. Inline scripts and styles need it in their nonce attribute.
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// CSPTemplateFuncs returns the cspNonce template function for r, used as
+// <script nonce="{{cspNonce}}">. Declare it when parsing, e.g. with
+// CSPTemplateFuncs(nil), then bind it per request on a clone:
+//
+//	t, _ := base.Clone()
+//	t.Funcs(middleware.CSPTemplateFuncs(r)).Execute(w, data)
+func CSPTemplateFuncs(r *http.Request) template.FuncMap {
+	return template.FuncMap{
+		"cspNonce": func() string {
+			if r == nil {
+				return ""
+			}
+			return CSPNonce(r.Context())
+		},
 	}
 }
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSPNonce(t *testing.T) {
+	page := template.Must(template.New("page").Funcs(CSPTemplateFuncs(nil)).Parse(`<script nonce="{{cspNonce}}"></script>`))
+	h := CSP(CSPOptions{
+		Directives:    map[string][]string{"script-src": {"'self'"}, "default-src": {"'none'"}},
+		ReportOnly:    map[string][]string{"script-src": {}},
+		Nonce:         true,
+		StrictDynamic: true,
+		ReportURI:     CSPReportPath,
+	})(func(w http.ResponseWriter, r *http.Request) {
+		t, _ := page.Clone()
+		t.Funcs(CSPTemplateFuncs(r)).Execute(w, nil)
+	})
+
+	var nonces []string
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		nonce := strings.TrimSuffix(strings.TrimPrefix(rec.Body.String(), `<script nonce="`), `"></script>`)
+		if nonce == "" {
+			t.Fatal("expected the template to render the nonce")
+		}
+		want := "default-src 'none'; script-src 'self' 'nonce-" + nonce + "' 'strict-dynamic'; report-uri /csp-report; report-to csp-endpoint"
+		if got := rec.Header().Get("Content-Security-Policy"); got != want {
+			t.Fatalf("got policy %q, want %q", got, want)
+		}
+		if got := rec.Header().Get("Content-Security-Policy-Report-Only"); !strings.Contains(got, "'nonce-"+nonce+"'") {
+			t.Fatalf("expected the report-only policy to share the nonce, got %q", got)
+		}
+		if rec.Header().Get("Reporting-Endpoints") != `csp-endpoint="/csp-report"` {
+			t.Fatalf("unexpected Reporting-Endpoints %q", rec.Header().Get("Reporting-Endpoints"))
+		}
+		nonces = append(nonces, nonce)
+	}
+	if nonces[0] == nonces[1] {
+		t.Fatal("expected a fresh nonce per request")
+	}
+}
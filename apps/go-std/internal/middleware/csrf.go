@@ -4,50 +4,178 @@ import (
 	"go-std/internal/utils"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 const (
-	csrfCookieName = utils.CsrfCookieName
 	csrfHeaderName = utils.CsrfHeaderName
 )
 
-func CSRFMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip CSRF check for GET, HEAD, OPTIONS requests
-		if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
-			next(w, r)
-			return
-		}
+// CSRFOptions configures CSRFProtect
+type CSRFOptions struct {
+	// TrustedOrigins may send unsafe requests besides the app's own origin,
+	// as exact origins or https://*.example.com patterns
+	TrustedOrigins []string
+	// ExemptPaths skip CSRF protection, e.g. webhooks authenticated by a
+	// signature. A path ending in / exempts everything below it.
+	ExemptPaths []string
+}
 
-		// Get session ID from session cookie
-		sessionCookie, err := r.Cookie("session_token")
+// CSRFOptions reads the options from CSRF_TRUSTED_ORIGINS, falling back to the
+// origins the default CORS policy allows, and CSRF_EXEMPT_PATHS
+func (a *MiddlewareContext) CSRFOptions() (CSRFOptions, error) {
+	trusted := a.Env.GetStringSlice("CSRF_TRUSTED_ORIGINS")
+	if trusted == nil {
+		policy, err := a.CORSPolicy("")
 		if err != nil {
-			utils.ErrorResponse(w, http.StatusForbidden, "Session required for CSRF protection", "SESSION_REQUIRED")
-			return
+			return CSRFOptions{}, err
 		}
+		trusted = policy.AllowedOrigins
+	}
+	return CSRFOptions{
+		TrustedOrigins: trusted,
+		ExemptPaths:    a.Env.GetStringSlice("CSRF_EXEMPT_PATHS"),
+	}, nil
+}
 
-		// Get CSRF token from header
-		token := r.Header.Get(csrfHeaderName)
-		if token == "" {
-			utils.ErrorResponse(w, http.StatusForbidden, "CSRF token missing from header", "CSRF_TOKEN_MISSING")
-			return
+func (o CSRFOptions) exempt(path string) bool {
+	for _, p := range o.ExemptPaths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
 		}
-		//TODO add logger to app context and provide it here
-		log.Println("CSRF token from header:", token)
+	}
+	return false
+}
 
-		// Get stored HMAC from cookie
-		csrfCookie, err := r.Cookie(csrfCookieName)
-		if err != nil {
-			utils.ErrorResponse(w, http.StatusForbidden, "CSRF token missing", "CSRF_TOKEN_MISSING")
-			return
+func (o CSRFOptions) trusted(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range o.TrustedOrigins {
+		if allowed != "*" && matchOrigin(strings.ToLower(allowed), origin) {
+			return true
 		}
+	}
+	return false
+}
+
+// CSRFMiddleware protects unsafe requests with the options from the config
+func (a *MiddlewareContext) CSRFMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	opts, err := a.CSRFOptions()
+	if err != nil {
+		log.Fatalf("failed to create CSRF middleware: %v", err)
+	}
+	return a.CSRFProtect(opts)(next)
+}
+
+// CSRFProtect checks unsafe requests in layers: browsers' Sec-Fetch-Site must
+// not report a cross-site request, Origin (or Referer) must be the app's own
+// or a trusted origin, and the token from the X-CSRF-Token header or the
+// csrf_token form field must match the HMAC in the CSRF cookie.
+func (a *MiddlewareContext) CSRFProtect(opts CSRFOptions) Middleware {
+	sessionCookiePolicy := a.SessionCookiePolicy()
+	csrfCookiePolicy := a.CSRFCookiePolicy()
+	keys, err := a.CSRFKeys()
+	if err != nil {
+		log.Fatalf("failed to create CSRF middleware: %v", err)
+	}
+	signer := utils.NewCSRFSigner(keys)
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Skip CSRF check for GET, HEAD, OPTIONS requests
+			if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" || opts.exempt(r.URL.Path) {
+				next(w, r)
+				return
+			}
+
+			// Not counted towards bans, a misconfigured origin list is the
+			// usual cause
+			if !opts.sameOrigin(r) {
+				utils.ErrorResponse(w, http.StatusForbidden, "Cross-site request rejected", "CSRF_ORIGIN_MISMATCH")
+				return
+			}
+
+			// Get session ID from session cookie
+			sessionToken, err := sessionCookiePolicy.Read(r)
+			if err != nil {
+				utils.ErrorResponse(w, http.StatusForbidden, "Session required for CSRF protection", "SESSION_REQUIRED")
+				return
+			}
 
-		// Verify CSRF token
-		if !utils.VerifyCSRFToken(token, sessionCookie.Value, csrfCookie.Value) {
-			utils.ErrorResponse(w, http.StatusForbidden, "CSRF token mismatch", "CSRF_TOKEN_MISMATCH")
-			return
+			// Get CSRF token from header, or from the form of server rendered pages
+			token := r.Header.Get(csrfHeaderName)
+			if token == "" {
+				token = r.PostFormValue(utils.CsrfFormField)
+			}
+			if token == "" {
+				utils.ErrorResponse(w, http.StatusForbidden, "CSRF token missing from header or form", "CSRF_TOKEN_MISSING")
+				return
+			}
+
+			// Get stored HMAC from cookie
+			storedHMAC, err := csrfCookiePolicy.Read(r)
+			if err != nil {
+				utils.ErrorResponse(w, http.StatusForbidden, "CSRF token missing", "CSRF_TOKEN_MISSING")
+				return
+			}
+
+			// Verify CSRF token, which clients send masked as they got it
+			if valid, wellFormed := verifyCSRFToken(signer, token, sessionToken, storedHMAC); !valid {
+				// Malformed tokens are more likely stale clients than attacks
+				if wellFormed {
+					a.IPBans.RecordFailure(utils.ClientIP(r), "csrf_mismatch")
+				}
+				utils.ErrorResponse(w, http.StatusForbidden, "CSRF token mismatch", "CSRF_TOKEN_MISMATCH")
+				return
+			}
+
+			next(w, r)
+		})
+	}
+}
+
+// verifyCSRFToken checks a masked token. Tokens issued before masking was
+// deployed are still accepted unmasked until their cookies are replaced.
+// wellFormed reports whether token is a masked token of the right length.
+func verifyCSRFToken(signer *utils.CSRFSigner, token, sessionId, stored string) (valid, wellFormed bool) {
+	if unmasked, err := utils.UnmaskCSRFToken(token); err == nil {
+		if signer.Verify(unmasked, sessionId, stored) {
+			return true, true
 		}
+		wellFormed = true
+	}
+	return signer.Verify(token, sessionId, stored), wellFormed
+}
+
+// sameOrigin reports whether r comes from the app itself or a trusted origin.
+// Requests without Sec-Fetch-Site, Origin and Referer, e.g. from non-browser
+// clients, pass on to the token check.
+func (o CSRFOptions) sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		if ref, err := url.Parse(r.Referer()); err == nil && ref.Host != "" {
+			origin = ref.Scheme + "://" + ref.Host
+		}
+	}
+
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		// same-site includes sibling subdomains, which may not be ours
+		return origin != "" && o.trusted(origin)
+	}
+
+	if origin == "" || origin == "null" {
+		return r.Header.Get("Origin") != "null"
+	}
+	return strings.EqualFold(origin, requestOrigin(r)) || o.trusted(origin)
+}
 
-		next(w, r)
-	})
+// requestOrigin is the origin the request was sent to
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
 }
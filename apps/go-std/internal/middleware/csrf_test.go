@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"go-std/internal/config"
+	"go-std/internal/utils"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFSameOrigin(t *testing.T) {
+	opts := CSRFOptions{TrustedOrigins: []string{"https://*.example.com"}}
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{"no browser headers", nil, true},
+		{"same origin", map[string]string{"Origin": "http://api.test"}, true},
+		{"trusted origin", map[string]string{"Origin": "https://app.example.com", "Sec-Fetch-Site": "same-site"}, true},
+		{"foreign origin", map[string]string{"Origin": "https://evil.test"}, false},
+		{"cross-site fetch metadata", map[string]string{"Sec-Fetch-Site": "cross-site"}, false},
+		{"same-site sibling", map[string]string{"Origin": "https://evil.api.test", "Sec-Fetch-Site": "same-site"}, false},
+		{"referer fallback", map[string]string{"Referer": "https://evil.test/form"}, false},
+		{"same origin referer", map[string]string{"Referer": "http://api.test/form"}, true},
+		{"opaque origin", map[string]string{"Origin": "null"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "http://api.test/api/auth/user", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := opts.sameOrigin(r); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFExemptPaths(t *testing.T) {
+	opts := CSRFOptions{ExemptPaths: []string{"/api/webhooks/", "/api/billing/stripe"}}
+	for path, want := range map[string]bool{
+		"/api/webhooks/github":      true,
+		"/api/billing/stripe":       true,
+		"/api/billing/stripe/other": false,
+		"/api/auth/user":            false,
+	} {
+		if got := opts.exempt(path); got != want {
+			t.Errorf("exempt(%s) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestCSRFOptionsTrustDevCORSOrigins(t *testing.T) {
+	t.Setenv("CSRF_TRUSTED_ORIGINS", "")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "")
+	m := NewMiddlewareContext(&config.App{Env: &config.ConfigMap{}, IsDev: true})
+	opts, err := m.CSRFOptions()
+	if err != nil {
+		t.Fatalf("CSRFOptions: %v", err)
+	}
+	r := httptest.NewRequest("POST", "http://localhost:8081/api/auth/user", nil)
+	r.Header.Set("Origin", "http://localhost:3001")
+	r.Header.Set("Sec-Fetch-Site", "same-site")
+	if !opts.sameOrigin(r) {
+		t.Fatal("expected the dev SPA origin to be trusted")
+	}
+}
+
+func TestVerifyCSRFToken(t *testing.T) {
+	keys, err := utils.EphemeralKeyRing()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := utils.NewCSRFSigner(keys)
+	token, stored := signer.Generate("session")
+	other, _ := signer.Generate("session")
+
+	tests := []struct {
+		name              string
+		token             string
+		valid, wellFormed bool
+	}{
+		{"masked", utils.MaskCSRFToken(token), true, true},
+		{"unmasked from before the rollout", token, true, false},
+		{"masked mismatch", utils.MaskCSRFToken(other), false, true},
+		{"unmasked mismatch", other, false, false},
+		{"garbage", "not-a-token", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, wellFormed := verifyCSRFToken(signer, tt.token, "session", stored)
+			if valid != tt.valid || wellFormed != tt.wellFormed {
+				t.Fatalf("got valid %v, well formed %v", valid, wellFormed)
+			}
+		})
+	}
+}
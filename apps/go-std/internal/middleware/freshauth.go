@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"go-std/internal/utils"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const defaultFreshAuthMaxAge = time.Minute * 10
+
+// FreshAuthMaxAge is how recently a user must have logged in for sensitive
+// operations, read from AUTH_FRESH_MAX_AGE (e.g. "10m")
+func (a *MiddlewareContext) FreshAuthMaxAge() time.Duration {
+	if d, err := time.ParseDuration(a.Env.GetString("AUTH_FRESH_MAX_AGE")); err == nil && d > 0 {
+		return d
+	}
+	return defaultFreshAuthMaxAge
+}
+
+// RequireFreshAuth rejects requests whose session was not authenticated within
+// maxAge with a REAUTH_REQUIRED error. The details carry a login URL that
+// re-authenticates the current session.
+func (a *MiddlewareContext) RequireFreshAuth(maxAge time.Duration) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			token, _ := a.SessionCookiePolicy().Read(r)
+			if token == "" {
+				utils.ErrorResponse(w, http.StatusUnauthorized, "Unauthorized", "UNAUTHORIZED")
+				return
+			}
+			session, err := a.Queries.GetSessionByToken(r.Context(), token)
+			if err != nil {
+				utils.ErrorResponse(w, http.StatusUnauthorized, "Unauthorized", "UNAUTHORIZED")
+				return
+			}
+
+			if session.AuthenticatedAt.Valid && time.Since(session.AuthenticatedAt.Time) <= maxAge {
+				next(w, r)
+				return
+			}
+
+			seconds := int(maxAge.Seconds())
+			query := url.Values{
+				"prompt":  {"login"},
+				"max_age": {strconv.Itoa(seconds)},
+			}
+			utils.ErrorDetailsResponse(w, http.StatusUnauthorized, "Please log in again to continue", "REAUTH_REQUIRED", map[string]interface{}{
+				"max_age":          seconds,
+				"authenticated_at": session.AuthenticatedAt.Time,
+				"reauth_url":       "/api/auth/login/" + url.PathEscape(session.ProviderID) + "?" + query.Encode(),
+			})
+		}
+	}
+}
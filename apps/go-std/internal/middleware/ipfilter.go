@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"fmt"
+	"go-std/internal/utils"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IPFilter allows or denies requests by client IP. Deny wins over allow, and
+// an empty allow list allows every IP that is not denied.
+type IPFilter struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// Allowed reports whether ip may pass. Unparseable IPs only pass filters
+// without an allow list.
+func (f IPFilter) Allowed(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return len(f.Allow) == 0
+	}
+	addr = addr.Unmap()
+	for _, p := range f.Deny {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true
+	}
+	for _, p := range f.Allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPFilter reads the filter for name from IP_ALLOW_<NAME> and IP_DENY_<NAME>,
+// e.g. IP_ALLOW_ADMIN=10.8.0.0/16. The global IP_DENY applies to every filter.
+func (a *MiddlewareContext) IPFilter(name string) (IPFilter, error) {
+	suffix := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	var filter IPFilter
+	for _, setting := range []string{"IP_DENY", "IP_DENY_" + suffix} {
+		deny, err := utils.ParseCIDRs(a.Env.GetString(setting))
+		if err != nil {
+			return IPFilter{}, fmt.Errorf("%s: %w", setting, err)
+		}
+		filter.Deny = append(filter.Deny, deny...)
+	}
+	allow, err := utils.ParseCIDRs(a.Env.GetString("IP_ALLOW_" + suffix))
+	if err != nil {
+		return IPFilter{}, fmt.Errorf("IP_ALLOW_%s: %w", suffix, err)
+	}
+	filter.Allow = allow
+	return filter, nil
+}
+
+// IPAccess rejects requests from IPs the filter does not allow with 403
+func IPAccess(filter IPFilter) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ip := utils.ClientIP(r)
+			if !filter.Allowed(ip) {
+				logger.Warn("IP %s not allowed: %s %s", ip, r.Method, r.URL.Path)
+				utils.ErrorResponse(w, http.StatusForbidden, "Forbidden", "IP_FORBIDDEN")
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+// BlockBannedIPs rejects requests from temporarily banned IPs with 403 and a
+// Retry-After of the ban's remaining time
+func (a *MiddlewareContext) BlockBannedIPs(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ban, banned := a.IPBans.Banned(utils.ClientIP(r)); banned {
+			w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(time.Until(ban.ExpiresAt)), 1)))
+			utils.ErrorResponse(w, http.StatusForbidden, "Too many failed attempts, try again later", "IP_BANNED")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// RequireAdmin only lets through unexpired sessions of users whose email is
+// listed in ADMIN_EMAILS
+func (a *MiddlewareContext) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	admins := map[string]bool{}
+	for _, email := range strings.Split(a.Env.GetString("ADMIN_EMAILS"), ",") {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			admins[email] = true
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, _ := a.SessionCookiePolicy().Read(r)
+		if token == "" {
+			utils.ErrorResponse(w, http.StatusUnauthorized, "Unauthorized", "UNAUTHORIZED")
+			return
+		}
+		session, err := a.Queries.GetSessionByToken(r.Context(), token)
+		if err != nil || !session.ExpiresAt.Valid || time.Now().After(session.ExpiresAt.Time) {
+			utils.ErrorResponse(w, http.StatusUnauthorized, "Unauthorized", "UNAUTHORIZED")
+			return
+		}
+		user, err := a.Queries.GetUserByID(r.Context(), session.UserID)
+		if err != nil || !user.EmailVerified || !admins[strings.ToLower(user.Email)] {
+			utils.ErrorResponse(w, http.StatusForbidden, "Forbidden", "FORBIDDEN")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"go-std/internal/utils"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyFunc returns the key a request is counted under. Returning false skips
+// the policy for the request.
+type KeyFunc func(r *http.Request) (string, bool)
+
+// RateLimitPolicy limits requests per key
+type RateLimitPolicy struct {
+	Name    string
+	Limit   int
+	Window  time.Duration
+	Key     KeyFunc
+	Limiter utils.RateLimiter
+}
+
+// NewRateLimitPolicy creates a policy allowing limit requests per window for each key
+func NewRateLimitPolicy(name string, limit int, window time.Duration, key KeyFunc, opts ...utils.RateLimiterOption) RateLimitPolicy {
+	return RateLimitPolicy{
+		Name:    name,
+		Limit:   limit,
+		Window:  window,
+		Key:     key,
+		Limiter: utils.NewSlidingWindowRateLimiter(name, limit, window, opts...),
+	}
+}
+
+// RateLimitPolicy builds a policy that can be overridden with RATE_LIMIT_<NAME>
+// in the form "<limit>/<window>", e.g. "10/1m". State is kept in the app's
+// RateLimitStore when one is configured.
+func (a *MiddlewareContext) RateLimitPolicy(name string, limit int, window time.Duration, key KeyFunc) RateLimitPolicy {
+	setting := "RATE_LIMIT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	if v := a.Env.GetString(setting); v != "" {
+		l, w, err := parseRateLimit(v)
+		if err != nil {
+			logger.Warn("ignoring invalid %s: %v", setting, err)
+		} else {
+			limit, window = l, w
+		}
+	}
+	var opts []utils.RateLimiterOption
+	if a.RateLimitStore != nil {
+		opts = append(opts, utils.WithRateLimitStore(a.RateLimitStore))
+	}
+	return NewRateLimitPolicy(name, limit, window, key, opts...)
+}
+
+func parseRateLimit(s string) (int, time.Duration, error) {
+	limitStr, windowStr, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected <limit>/<window>, got %q", s)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(limitStr))
+	if err != nil || limit <= 0 {
+		return 0, 0, fmt.Errorf("invalid limit %q", limitStr)
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(windowStr))
+	if err != nil || window <= 0 {
+		return 0, 0, fmt.Errorf("invalid window %q", windowStr)
+	}
+	return limit, window, nil
+}
+
+// RateLimit rejects requests exceeding any of the policies with 429. Every
+// response carries RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset for
+// the most restrictive policy, and rejected ones also carry Retry-After.
+func RateLimit(policies ...RateLimitPolicy) Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			var (
+				tightest *utils.RateLimitResult
+				policy   RateLimitPolicy
+				denied   bool
+			)
+			for _, p := range policies {
+				key, ok := p.Key(r)
+				if !ok {
+					continue
+				}
+				result, err := p.Limiter.Allow(p.Name + ":" + key)
+				if errors.Is(err, utils.ErrRateLimitContention) {
+					// Too many concurrent requests for one key to apply them
+					// all, which is a flood rather than an outage, so deny
+					logger.Warn("rate limit %s: %v", p.Name, err)
+					result = utils.RateLimitResult{Limit: p.Limit, ResetAfter: time.Second, RetryAfter: time.Second}
+				} else if err != nil {
+					// Fail open, an unavailable store must not take the site down
+					logger.Error("rate limit %s: %v", p.Name, err)
+					continue
+				}
+				if !result.Allowed {
+					if !denied || tightest.RetryAfter < result.RetryAfter {
+						tightest, policy = &result, p
+					}
+					denied = true
+					continue
+				}
+				if !denied && (tightest == nil || result.Remaining < tightest.Remaining) {
+					tightest, policy = &result, p
+				}
+			}
+
+			if tightest != nil {
+				h := w.Header()
+				h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Window.Seconds())))
+				h.Set("RateLimit-Limit", strconv.Itoa(tightest.Limit))
+				h.Set("RateLimit-Remaining", strconv.Itoa(max(tightest.Remaining, 0)))
+				h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(tightest.ResetAfter)))
+			}
+			if denied {
+				w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(tightest.RetryAfter), 1)))
+				logger.Warn("rate limit %s exceeded: %s %s", policy.Name, r.Method, r.URL.Path)
+				utils.ErrorResponse(w, http.StatusTooManyRequests, "Too many requests", "RATE_LIMITED")
+				return
+			}
+			next(w, r)
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// KeyByIP keys requests by client IP
+func KeyByIP(r *http.Request) (string, bool) {
+	return utils.ClientIP(r), true
+}
+
+// KeyByRouteAndIP keys requests by method, path and client IP
+func KeyByRouteAndIP(r *http.Request) (string, bool) {
+	ip, _ := KeyByIP(r)
+	return r.Method + " " + r.URL.Path + "|" + ip, true
+}
+
+// KeyBySessionUser keys requests by the user of the session cookie and falls
+// back to the client IP for anonymous requests
+func (a *MiddlewareContext) KeyBySessionUser(r *http.Request) (string, bool) {
+	if token, _ := a.SessionCookiePolicy().Read(r); token != "" {
+		if session, err := a.Queries.GetSessionByToken(r.Context(), token); err == nil {
+			return "user:" + session.UserID, true
+		}
+	}
+	ip, _ := KeyByIP(r)
+	return "ip:" + ip, true
+}
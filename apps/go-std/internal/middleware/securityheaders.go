@@ -0,0 +1,421 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSPDirectives maps CSP directives to their sources. It renders sorted by
+// directive, so the header value is the same on every start.
+type CSPDirectives map[string][]string
+
+// Add adds sources to directive and returns d. Adding sources to a directive
+// that is 'none' replaces the 'none', e.g. to allow one iframe on one page.
+func (d CSPDirectives) Add(directive string, sources ...string) CSPDirectives {
+	current, ok := d[directive]
+	if !ok {
+		current = []string{}
+	}
+	if len(sources) > 0 && slices.Equal(current, []string{"'none'"}) {
+		current = []string{}
+	}
+	for _, source := range sources {
+		if !slices.Contains(current, source) {
+			current = append(current, source)
+		}
+	}
+	d[directive] = current
+	return d
+}
+
+// Clone returns a deep copy of d
+func (d CSPDirectives) Clone() CSPDirectives {
+	if d == nil {
+		return nil
+	}
+	clone := make(CSPDirectives, len(d))
+	for directive, sources := range d {
+		clone[directive] = slices.Clone(sources)
+	}
+	return clone
+}
+
+// Merge returns a copy of d with the sources of other added
+func (d CSPDirectives) Merge(other CSPDirectives) CSPDirectives {
+	merged := d.Clone()
+	if merged == nil && other != nil {
+		merged = CSPDirectives{}
+	}
+	for _, directive := range sortedKeys(other) {
+		merged.Add(directive, other[directive]...)
+	}
+	return merged
+}
+
+// String renders the policy without nonce or reporting
+func (d CSPDirectives) String() string {
+	return newCSPTemplate(d, false, false, "").render("")
+}
+
+// ParseCSP parses a policy such as "script-src 'self' https://cdn.example.com; img-src https:"
+func ParseCSP(policy string) (CSPDirectives, error) {
+	directives := CSPDirectives{}
+	for _, part := range strings.Split(policy, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		if strings.ContainsAny(name, "'\"") {
+			return nil, fmt.Errorf("invalid CSP directive %q", fields[0])
+		}
+		directives.Add(name, fields[1:]...)
+	}
+	return directives, nil
+}
+
+// PermissionsPolicy maps browser features to the origins allowed to use them.
+// An empty allowlist disables the feature, "self" and "*" are the keywords and
+// everything else is an origin.
+type PermissionsPolicy map[string][]string
+
+// Merge returns a copy of p with the allowlists of other added. Allowing an
+// origin for a disabled feature enables it for that origin only.
+func (p PermissionsPolicy) Merge(other PermissionsPolicy) PermissionsPolicy {
+	merged := make(PermissionsPolicy, len(p)+len(other))
+	for feature, allowlist := range p {
+		merged[feature] = slices.Clone(allowlist)
+	}
+	for feature, allowlist := range other {
+		current, ok := merged[feature]
+		if !ok {
+			current = []string{}
+		}
+		for _, origin := range allowlist {
+			if !slices.Contains(current, origin) {
+				current = append(current, origin)
+			}
+		}
+		merged[feature] = current
+	}
+	return merged
+}
+
+// String renders the policy as a structured header, sorted by feature
+func (p PermissionsPolicy) String() string {
+	parts := make([]string, 0, len(p))
+	for _, feature := range sortedKeys(p) {
+		items := make([]string, 0, len(p[feature]))
+		for _, origin := range p[feature] {
+			if origin == "self" || origin == "*" {
+				items = append(items, origin)
+			} else {
+				items = append(items, strconv.Quote(origin))
+			}
+		}
+		parts = append(parts, feature+"=("+strings.Join(items, " ")+")")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ParsePermissionsPolicy parses a policy such as `camera=(), geolocation=(self "https://maps.example.com")`
+func ParsePermissionsPolicy(policy string) (PermissionsPolicy, error) {
+	parsed := PermissionsPolicy{}
+	for _, part := range strings.Split(policy, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		feature, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid Permissions-Policy entry %q", part)
+		}
+		allowlist := []string{}
+		if value = strings.TrimSpace(value); value != "*" {
+			if !strings.HasPrefix(value, "(") || !strings.HasSuffix(value, ")") {
+				return nil, fmt.Errorf("invalid Permissions-Policy allowlist %q", value)
+			}
+			for _, origin := range strings.Fields(value[1 : len(value)-1]) {
+				allowlist = append(allowlist, strings.Trim(origin, `"`))
+			}
+		} else {
+			allowlist = append(allowlist, "*")
+		}
+		parsed[strings.TrimSpace(feature)] = allowlist
+	}
+	return parsed, nil
+}
+
+// HSTS is the Strict-Transport-Security policy, sent only over HTTPS
+type HSTS struct {
+	MaxAge            time.Duration
+	IncludeSubDomains bool
+	// Preload asks to be added to the browsers' preload lists, which requires
+	// IncludeSubDomains and a MaxAge of at least a year
+	Preload bool
+}
+
+const hstsPreloadMinAge = time.Hour * 24 * 365
+
+// Validate checks the preload requirements
+func (h HSTS) Validate() error {
+	if h.Preload && (!h.IncludeSubDomains || h.MaxAge < hstsPreloadMinAge) {
+		return errors.New("HSTS preload requires includeSubDomains and a max-age of at least a year")
+	}
+	return nil
+}
+
+// String renders the header value, "" if HSTS is off
+func (h HSTS) String() string {
+	if h.MaxAge <= 0 {
+		return ""
+	}
+	value := fmt.Sprintf("max-age=%d", int(h.MaxAge/time.Second))
+	if h.IncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if h.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// SecurityHeaders is the set of security headers sent with every response.
+// Empty fields send no header.
+type SecurityHeaders struct {
+	// CSP is the enforced policy
+	CSP CSPDirectives
+	// CSPReportOnly is sent as Content-Security-Policy-Report-Only next to
+	// the enforced policy, to try a stricter policy before enforcing it
+	CSPReportOnly CSPDirectives
+	// Nonce adds a fresh 'nonce-...' to script-src and style-src of both
+	// policies on every request. Templates read it with CSPNonce.
+	Nonce bool
+	// StrictDynamic adds 'strict-dynamic' to script-src, so scripts loaded by
+	// nonced scripts may run and host allowlists are ignored. Requires Nonce.
+	StrictDynamic bool
+	// ReportURI receives violation reports through both report-uri and the
+	// Reporting API
+	ReportURI string
+
+	PermissionsPolicy         PermissionsPolicy
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	CrossOriginResourcePolicy string
+	ReferrerPolicy            string
+	FrameOptions              string
+	HSTS                      HSTS
+}
+
+// DefaultSecurityHeaders returns the default headers for production or
+// development. Development keeps 'unsafe-inline' and so goes without nonce,
+// which would make browsers ignore it, and without HSTS.
+func DefaultSecurityHeaders(isDevelopment bool) SecurityHeaders {
+	h := SecurityHeaders{
+		CSP: DefaultCSPDirectives(isDevelopment),
+		PermissionsPolicy: PermissionsPolicy{
+			"camera":      {},
+			"geolocation": {},
+			"microphone":  {},
+			"payment":     {},
+			"usb":         {},
+		},
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginResourcePolicy: "same-origin",
+		ReferrerPolicy:            "strict-origin-when-cross-origin",
+		FrameOptions:              "DENY",
+	}
+	if !isDevelopment {
+		h.Nonce = true
+		h.ReportURI = CSPReportPath
+		h.HSTS = HSTS{MaxAge: hstsPreloadMinAge, IncludeSubDomains: true}
+	}
+	return h
+}
+
+// Merge returns a copy of h with the directives and allowlists of add added,
+// and the fields that add sets replaced
+func (h SecurityHeaders) Merge(add SecurityHeaders) SecurityHeaders {
+	merged := h
+	merged.CSP = h.CSP.Merge(add.CSP)
+	merged.CSPReportOnly = h.CSPReportOnly.Merge(add.CSPReportOnly)
+	merged.PermissionsPolicy = h.PermissionsPolicy.Merge(add.PermissionsPolicy)
+	merged.Nonce = h.Nonce || add.Nonce
+	merged.StrictDynamic = h.StrictDynamic || add.StrictDynamic
+	override(&merged.ReportURI, add.ReportURI)
+	override(&merged.CrossOriginOpenerPolicy, add.CrossOriginOpenerPolicy)
+	override(&merged.CrossOriginEmbedderPolicy, add.CrossOriginEmbedderPolicy)
+	override(&merged.CrossOriginResourcePolicy, add.CrossOriginResourcePolicy)
+	override(&merged.ReferrerPolicy, add.ReferrerPolicy)
+	override(&merged.FrameOptions, add.FrameOptions)
+	if add.HSTS.MaxAge > 0 {
+		merged.HSTS = add.HSTS
+	}
+	return merged
+}
+
+func override(dst *string, src string) {
+	if src != "" {
+		*dst = src
+	}
+}
+
+// Validate checks the combination of settings
+func (h SecurityHeaders) Validate() error {
+	if h.StrictDynamic && !h.Nonce {
+		return errors.New("'strict-dynamic' without a nonce blocks every script")
+	}
+	return h.HSTS.Validate()
+}
+
+// Middleware sets the headers on every response. The policies are rendered
+// once, only the nonce is filled in per request.
+func (h SecurityHeaders) Middleware() Middleware {
+	if err := h.Validate(); err != nil {
+		log.Printf("security headers: %v", err)
+	}
+	enforced := newCSPTemplate(h.CSP, h.Nonce, h.StrictDynamic, h.ReportURI)
+	reportOnly := newCSPTemplate(h.CSPReportOnly, h.Nonce, h.StrictDynamic, h.ReportURI)
+
+	static := map[string]string{
+		"Permissions-Policy":           h.PermissionsPolicy.String(),
+		"Cross-Origin-Opener-Policy":   h.CrossOriginOpenerPolicy,
+		"Cross-Origin-Embedder-Policy": h.CrossOriginEmbedderPolicy,
+		"Cross-Origin-Resource-Policy": h.CrossOriginResourcePolicy,
+		"Referrer-Policy":              h.ReferrerPolicy,
+		"X-Frame-Options":              h.FrameOptions,
+		"X-Content-Type-Options":       "nosniff",
+		"X-XSS-Protection":             "0",
+	}
+	if h.ReportURI != "" {
+		static["Reporting-Endpoints"] = fmt.Sprintf("%s=%q", cspReportGroup, h.ReportURI)
+	}
+	hsts := h.HSTS.String()
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce := ""
+			if h.Nonce {
+				nonce = newCSPNonce()
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce))
+			}
+			if enforced != nil {
+				w.Header().Set("Content-Security-Policy", enforced.render(nonce))
+			}
+			if reportOnly != nil {
+				w.Header().Set("Content-Security-Policy-Report-Only", reportOnly.render(nonce))
+			}
+			for name, value := range static {
+				if value != "" {
+					w.Header().Set(name, value)
+				}
+			}
+			if hsts != "" && (r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https") {
+				w.Header().Set("Strict-Transport-Security", hsts)
+			}
+
+			next(w, r)
+		})
+	}
+}
+
+// SecurityHeaders reads the app's headers from the config on top of the
+// defaults for the environment:
+//
+//	CSP_ADD                       directives added to the policy, e.g. "img-src https://cdn.example.com"
+//	CSP_REPORT_ONLY               a policy to report on without enforcing it
+//	CSP_STRICT_DYNAMIC            add 'strict-dynamic' to script-src
+//	PERMISSIONS_POLICY            allowlists added to the policy, e.g. "geolocation=(self)"
+//	CROSS_ORIGIN_OPENER_POLICY    defaults to same-origin
+//	CROSS_ORIGIN_EMBEDDER_POLICY  not sent by default
+//	CROSS_ORIGIN_RESOURCE_POLICY  defaults to same-origin
+//	REFERRER_POLICY               defaults to strict-origin-when-cross-origin
+//	HSTS_MAX_AGE                  as a Go duration, defaults to a year in production
+//	HSTS_INCLUDE_SUBDOMAINS       defaults to true
+//	HSTS_PRELOAD                  defaults to false
+//
+// Routes merge their additions with SecurityHeaders.Merge.
+func (a *MiddlewareContext) SecurityHeaders() (SecurityHeaders, error) {
+	h := DefaultSecurityHeaders(a.IsDev)
+
+	var add SecurityHeaders
+	if v := a.Env.GetString("CSP_ADD"); v != "" {
+		directives, err := ParseCSP(v)
+		if err != nil {
+			return SecurityHeaders{}, fmt.Errorf("CSP_ADD: %w", err)
+		}
+		add.CSP = directives
+	}
+	if v := a.Env.GetString("CSP_REPORT_ONLY"); v != "" {
+		directives, err := ParseCSP(v)
+		if err != nil {
+			return SecurityHeaders{}, fmt.Errorf("CSP_REPORT_ONLY: %w", err)
+		}
+		add.CSPReportOnly = directives
+		add.ReportURI = CSPReportPath
+	}
+	if v := a.Env.GetString("PERMISSIONS_POLICY"); v != "" {
+		policy, err := ParsePermissionsPolicy(v)
+		if err != nil {
+			return SecurityHeaders{}, fmt.Errorf("PERMISSIONS_POLICY: %w", err)
+		}
+		add.PermissionsPolicy = policy
+	}
+	add.CrossOriginOpenerPolicy = a.Env.GetString("CROSS_ORIGIN_OPENER_POLICY")
+	add.CrossOriginEmbedderPolicy = a.Env.GetString("CROSS_ORIGIN_EMBEDDER_POLICY")
+	add.CrossOriginResourcePolicy = a.Env.GetString("CROSS_ORIGIN_RESOURCE_POLICY")
+	add.ReferrerPolicy = a.Env.GetString("REFERRER_POLICY")
+	h = h.Merge(add)
+
+	var err error
+	if h.StrictDynamic, err = a.boolSetting("CSP_STRICT_DYNAMIC", h.StrictDynamic); err != nil {
+		return SecurityHeaders{}, err
+	}
+	if v := a.Env.GetString("HSTS_MAX_AGE"); v != "" {
+		maxAge, err := time.ParseDuration(v)
+		if err != nil || maxAge < 0 {
+			return SecurityHeaders{}, fmt.Errorf("invalid HSTS_MAX_AGE %q", v)
+		}
+		h.HSTS.MaxAge = maxAge
+	}
+	if h.HSTS.IncludeSubDomains, err = a.boolSetting("HSTS_INCLUDE_SUBDOMAINS", true); err != nil {
+		return SecurityHeaders{}, err
+	}
+	if h.HSTS.Preload, err = a.boolSetting("HSTS_PRELOAD", false); err != nil {
+		return SecurityHeaders{}, err
+	}
+
+	if err := h.Validate(); err != nil {
+		return SecurityHeaders{}, err
+	}
+	return h, nil
+}
+
+func (a *MiddlewareContext) boolSetting(key string, fallback bool) (bool, error) {
+	v := a.Env.GetString(key)
+	if v == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return b, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
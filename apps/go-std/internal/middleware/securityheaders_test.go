@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeadersMerge(t *testing.T) {
+	base := SecurityHeaders{
+		CSP:               CSPDirectives{"default-src": {"'none'"}, "frame-src": {"'none'"}, "script-src": {"'self'"}},
+		PermissionsPolicy: PermissionsPolicy{"payment": {}, "camera": {}},
+		ReferrerPolicy:    "no-referrer",
+	}
+	checkout := base.Merge(SecurityHeaders{
+		CSP:               CSPDirectives{"frame-src": {"https://js.stripe.com"}, "script-src": {"https://js.stripe.com"}},
+		PermissionsPolicy: PermissionsPolicy{"payment": {"self", "https://js.stripe.com"}},
+	})
+
+	if got, want := checkout.CSP.String(), "default-src 'none'; frame-src https://js.stripe.com; script-src 'self' https://js.stripe.com"; got != want {
+		t.Fatalf("got CSP %q, want %q", got, want)
+	}
+	if got, want := checkout.PermissionsPolicy.String(), `camera=(), payment=(self "https://js.stripe.com")`; got != want {
+		t.Fatalf("got Permissions-Policy %q, want %q", got, want)
+	}
+	if got := base.CSP.String(); got != "default-src 'none'; frame-src 'none'; script-src 'self'" {
+		t.Fatalf("merge must not change the base policy, got %q", got)
+	}
+	if checkout.ReferrerPolicy != "no-referrer" {
+		t.Fatal("expected fields the addition leaves empty to be kept")
+	}
+}
+
+func TestSecurityHeadersHSTS(t *testing.T) {
+	h := SecurityHeaders{HSTS: HSTS{MaxAge: hstsPreloadMinAge, IncludeSubDomains: true, Preload: true}}
+	if err := h.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	handler := h.Middleware()(func(w http.ResponseWriter, r *http.Request) {})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Header().Get("Strict-Transport-Security") != "" {
+		t.Fatal("HSTS must only be sent over HTTPS")
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	rec = httptest.NewRecorder()
+	handler(rec, r)
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains; preload" {
+		t.Fatalf("unexpected HSTS %q", got)
+	}
+
+	if err := (HSTS{MaxAge: time.Hour, Preload: true}).Validate(); err == nil {
+		t.Fatal("expected preload with a short max-age to be rejected")
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+	csp, err := ParseCSP("img-src https://cdn.example.com data:; upgrade-insecure-requests")
+	if err != nil || csp.String() != "img-src https://cdn.example.com data:; upgrade-insecure-requests" {
+		t.Fatalf("ParseCSP: %q %v", csp.String(), err)
+	}
+	pp, err := ParsePermissionsPolicy(`geolocation=(self "https://maps.example.com"), fullscreen=*`)
+	if err != nil || pp.String() != `fullscreen=(*), geolocation=(self "https://maps.example.com")` {
+		t.Fatalf("ParsePermissionsPolicy: %q %v", pp.String(), err)
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"net/http"
 
 	"go-std/internal/config"
+	"go-std/internal/utils"
 )
 
+var logger = utils.NewLogger(utils.DEBUG, true)
+
 type MiddlewareContext struct {
 	*config.App
 }
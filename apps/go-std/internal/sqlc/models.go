@@ -23,32 +23,84 @@ type Account struct {
 	UpdatedAt            pgtype.Timestamp `db:"updated_at" json:"updated_at"`
 }
 
+type AuditEvent struct {
+	ID        string           `db:"id" json:"id"`
+	UserID    string           `db:"user_id" json:"user_id"`
+	Type      string           `db:"type" json:"type"`
+	IpAddress pgtype.Text      `db:"ip_address" json:"ip_address"`
+	UserAgent pgtype.Text      `db:"user_agent" json:"user_agent"`
+	Metadata  []byte           `db:"metadata" json:"metadata"`
+	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
+}
+
 type Author struct {
 	ID   int32       `db:"id" json:"id"`
 	Name string      `db:"name" json:"name"`
 	Bio  pgtype.Text `db:"bio" json:"bio"`
 }
 
-type Session struct {
-	ID        string           `db:"id" json:"id"`
+type CacheEntry struct {
+	Key       string           `db:"key" json:"key"`
+	Value     []byte           `db:"value" json:"value"`
+	ExpiresAt pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+}
+
+type OauthTransaction struct {
+	State           string           `db:"state" json:"state"`
+	CodeVerifier    string           `db:"code_verifier" json:"code_verifier"`
+	Nonce           string           `db:"nonce" json:"nonce"`
+	Provider        string           `db:"provider" json:"provider"`
+	RedirectUrl     string           `db:"redirect_url" json:"redirect_url"`
+	CreatedAt       pgtype.Timestamp `db:"created_at" json:"created_at"`
+	ExpiresAt       pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	ReauthSessionID pgtype.Text      `db:"reauth_session_id" json:"reauth_session_id"`
+}
+
+type RateLimit struct {
+	Key       string           `db:"key" json:"key"`
+	Value     []byte           `db:"value" json:"value"`
 	ExpiresAt pgtype.Timestamp `db:"expires_at" json:"expires_at"`
-	Token     string           `db:"token" json:"token"`
+}
+
+type RegisteredClient struct {
+	ID        string           `db:"id" json:"id"`
+	Name      string           `db:"name" json:"name"`
+	Origin    string           `db:"origin" json:"origin"`
 	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
-	UpdatedAt pgtype.Timestamp `db:"updated_at" json:"updated_at"`
-	IpAddress pgtype.Text      `db:"ip_address" json:"ip_address"`
-	UserAgent pgtype.Text      `db:"user_agent" json:"user_agent"`
-	UserID    string           `db:"user_id" json:"user_id"`
-	AccountID pgtype.Text      `db:"account_id" json:"account_id"`
+	RevokedAt pgtype.Timestamp `db:"revoked_at" json:"revoked_at"`
+}
+
+type Session struct {
+	ID              string           `db:"id" json:"id"`
+	ExpiresAt       pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	Token           string           `db:"token" json:"token"`
+	CreatedAt       pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt       pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+	IpAddress       pgtype.Text      `db:"ip_address" json:"ip_address"`
+	UserAgent       pgtype.Text      `db:"user_agent" json:"user_agent"`
+	UserID          string           `db:"user_id" json:"user_id"`
+	AccountID       pgtype.Text      `db:"account_id" json:"account_id"`
+	AuthenticatedAt pgtype.Timestamp `db:"authenticated_at" json:"authenticated_at"`
 }
 
 type User struct {
-	ID            string           `db:"id" json:"id"`
-	Name          string           `db:"name" json:"name"`
-	Email         string           `db:"email" json:"email"`
-	EmailVerified bool             `db:"email_verified" json:"email_verified"`
-	Image         pgtype.Text      `db:"image" json:"image"`
-	CreatedAt     pgtype.Timestamp `db:"created_at" json:"created_at"`
-	UpdatedAt     pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+	ID                  string           `db:"id" json:"id"`
+	Name                string           `db:"name" json:"name"`
+	Email               string           `db:"email" json:"email"`
+	EmailVerified       bool             `db:"email_verified" json:"email_verified"`
+	Image               pgtype.Text      `db:"image" json:"image"`
+	CreatedAt           pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt           pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+	DisabledAt          pgtype.Timestamp `db:"disabled_at" json:"disabled_at"`
+	DeletionScheduledAt pgtype.Timestamp `db:"deletion_scheduled_at" json:"deletion_scheduled_at"`
+}
+
+type Waitlist struct {
+	ID        string           `db:"id" json:"id"`
+	Email     string           `db:"email" json:"email"`
+	Name      string           `db:"name" json:"name"`
+	Provider  string           `db:"provider" json:"provider"`
+	CreatedAt pgtype.Timestamp `db:"created_at" json:"created_at"`
 }
 
 type Verification struct {
@@ -11,6 +11,91 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const addToWaitlist = `-- name: AddToWaitlist :exec
+INSERT INTO "public"."waitlist"
+("email", "name", "provider")
+VALUES ($1, $2, $3)
+ON CONFLICT(email) DO UPDATE SET name = $2, provider = $3
+`
+
+type AddToWaitlistParams struct {
+	Email    string `db:"email" json:"email"`
+	Name     string `db:"name" json:"name"`
+	Provider string `db:"provider" json:"provider"`
+}
+
+func (q *Queries) AddToWaitlist(ctx context.Context, arg AddToWaitlistParams) error {
+	_, err := q.db.Exec(ctx, addToWaitlist, arg.Email, arg.Name, arg.Provider)
+	return err
+}
+
+const cancelUserDeletion = `-- name: CancelUserDeletion :execrows
+UPDATE "public"."user"
+SET "disabled_at" = NULL,
+    "deletion_scheduled_at" = NULL
+WHERE id = $1 AND "deletion_scheduled_at" IS NOT NULL
+`
+
+func (q *Queries) CancelUserDeletion(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.Exec(ctx, cancelUserDeletion, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const consumeOAuthTransaction = `-- name: ConsumeOAuthTransaction :one
+DELETE FROM "public"."oauth_transaction"
+WHERE "state" = $1 AND "expires_at" > $2
+RETURNING state, code_verifier, nonce, provider, redirect_url, created_at, expires_at, reauth_session_id
+`
+
+type ConsumeOAuthTransactionParams struct {
+	State string           `db:"state" json:"state"`
+	Now   pgtype.Timestamp `db:"now" json:"now"`
+}
+
+func (q *Queries) ConsumeOAuthTransaction(ctx context.Context, arg ConsumeOAuthTransactionParams) (OauthTransaction, error) {
+	row := q.db.QueryRow(ctx, consumeOAuthTransaction, arg.State, arg.Now)
+	var i OauthTransaction
+	err := row.Scan(
+		&i.State,
+		&i.CodeVerifier,
+		&i.Nonce,
+		&i.Provider,
+		&i.RedirectUrl,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.ReauthSessionID,
+	)
+	return i, err
+}
+
+const createAuditEvent = `-- name: CreateAuditEvent :exec
+INSERT INTO "public"."audit_event"
+("user_id", "type", "ip_address", "user_agent", "metadata")
+VALUES ($1, $2, $3, $4, $5)
+`
+
+type CreateAuditEventParams struct {
+	UserID    string      `db:"user_id" json:"user_id"`
+	Type      string      `db:"type" json:"type"`
+	IpAddress pgtype.Text `db:"ip_address" json:"ip_address"`
+	UserAgent pgtype.Text `db:"user_agent" json:"user_agent"`
+	Metadata  []byte      `db:"metadata" json:"metadata"`
+}
+
+func (q *Queries) CreateAuditEvent(ctx context.Context, arg CreateAuditEventParams) error {
+	_, err := q.db.Exec(ctx, createAuditEvent,
+		arg.UserID,
+		arg.Type,
+		arg.IpAddress,
+		arg.UserAgent,
+		arg.Metadata,
+	)
+	return err
+}
+
 const createAuthor = `-- name: CreateAuthor :one
 INSERT INTO authors (
   name, bio
@@ -123,6 +208,37 @@ func (q *Queries) CreateNewUser(ctx context.Context, arg CreateNewUserParams) (C
 	return i, err
 }
 
+const createOAuthTransaction = `-- name: CreateOAuthTransaction :exec
+INSERT INTO "public"."oauth_transaction"
+("state", "code_verifier", "nonce", "provider", "redirect_url", "created_at", "expires_at", "reauth_session_id")
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`
+
+type CreateOAuthTransactionParams struct {
+	State           string           `db:"state" json:"state"`
+	CodeVerifier    string           `db:"code_verifier" json:"code_verifier"`
+	Nonce           string           `db:"nonce" json:"nonce"`
+	Provider        string           `db:"provider" json:"provider"`
+	RedirectUrl     string           `db:"redirect_url" json:"redirect_url"`
+	CreatedAt       pgtype.Timestamp `db:"created_at" json:"created_at"`
+	ExpiresAt       pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	ReauthSessionID pgtype.Text      `db:"reauth_session_id" json:"reauth_session_id"`
+}
+
+func (q *Queries) CreateOAuthTransaction(ctx context.Context, arg CreateOAuthTransactionParams) error {
+	_, err := q.db.Exec(ctx, createOAuthTransaction,
+		arg.State,
+		arg.CodeVerifier,
+		arg.Nonce,
+		arg.Provider,
+		arg.RedirectUrl,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+		arg.ReauthSessionID,
+	)
+	return err
+}
+
 const deleteAuthor = `-- name: DeleteAuthor :exec
 DELETE FROM authors
 WHERE id = $1
@@ -133,6 +249,55 @@ func (q *Queries) DeleteAuthor(ctx context.Context, id int32) error {
 	return err
 }
 
+const deleteCacheEntry = `-- name: DeleteCacheEntry :exec
+DELETE FROM "public"."cache_entry"
+WHERE "key" = $1
+`
+
+func (q *Queries) DeleteCacheEntry(ctx context.Context, key string) error {
+	_, err := q.db.Exec(ctx, deleteCacheEntry, key)
+	return err
+}
+
+const deleteExpiredCacheEntries = `-- name: DeleteExpiredCacheEntries :execrows
+DELETE FROM "public"."cache_entry"
+WHERE "expires_at" <= $1
+`
+
+func (q *Queries) DeleteExpiredCacheEntries(ctx context.Context, now pgtype.Timestamp) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredCacheEntries, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredOAuthTransactions = `-- name: DeleteExpiredOAuthTransactions :execrows
+DELETE FROM "public"."oauth_transaction"
+WHERE "expires_at" <= $1
+`
+
+func (q *Queries) DeleteExpiredOAuthTransactions(ctx context.Context, now pgtype.Timestamp) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredOAuthTransactions, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredRateLimits = `-- name: DeleteExpiredRateLimits :execrows
+DELETE FROM "public"."rate_limit"
+WHERE "expires_at" <= $1
+`
+
+func (q *Queries) DeleteExpiredRateLimits(ctx context.Context, now pgtype.Timestamp) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredRateLimits, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteSession = `-- name: DeleteSession :one
 DELETE FROM "public"."session"
 WHERE token = $1
@@ -171,6 +336,19 @@ func (q *Queries) DeleteUser(ctx context.Context, id string) (string, error) {
 	return id, err
 }
 
+const deleteUserSessions = `-- name: DeleteUserSessions :execrows
+DELETE FROM "public"."session"
+WHERE "user_id" = $1
+`
+
+func (q *Queries) DeleteUserSessions(ctx context.Context, userID string) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUserSessions, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const getAuthor = `-- name: GetAuthor :one
 SELECT id, name, bio FROM authors
 WHERE id = $1 LIMIT 1
@@ -183,26 +361,62 @@ func (q *Queries) GetAuthor(ctx context.Context, id int32) (Author, error) {
 	return i, err
 }
 
+const getCacheEntry = `-- name: GetCacheEntry :one
+SELECT "value" FROM "public"."cache_entry"
+WHERE "key" = $1 AND "expires_at" > $2
+`
+
+type GetCacheEntryParams struct {
+	Key string           `db:"key" json:"key"`
+	Now pgtype.Timestamp `db:"now" json:"now"`
+}
+
+func (q *Queries) GetCacheEntry(ctx context.Context, arg GetCacheEntryParams) ([]byte, error) {
+	row := q.db.QueryRow(ctx, getCacheEntry, arg.Key, arg.Now)
+	var value []byte
+	err := row.Scan(&value)
+	return value, err
+}
+
+const getRegisteredClientByOrigin = `-- name: GetRegisteredClientByOrigin :one
+SELECT id, name, origin, created_at, revoked_at FROM "public"."registered_client"
+WHERE "origin" = $1 AND "revoked_at" IS NULL
+`
+
+func (q *Queries) GetRegisteredClientByOrigin(ctx context.Context, origin string) (RegisteredClient, error) {
+	row := q.db.QueryRow(ctx, getRegisteredClientByOrigin, origin)
+	var i RegisteredClient
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Origin,
+		&i.CreatedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
 const getSessionByToken = `-- name: GetSessionByToken :one
-SELECT session.id, session.expires_at, session.token, session.created_at, session.updated_at, session.ip_address, session.user_agent, session.user_id, session.account_id, account.refresh_token, account.provider_id FROM "public"."session" session
+SELECT session.id, session.expires_at, session.token, session.created_at, session.updated_at, session.ip_address, session.user_agent, session.user_id, session.account_id, session.authenticated_at, account.refresh_token, account.provider_id FROM "public"."session" session
 INNER JOIN public.user  ON session.user_id = "user".id
 INNER JOIN public.account account ON session.account_id = account.id
-WHERE session.token = $1
+WHERE session.token = $1 AND "user".disabled_at IS NULL
 LIMIT 1
 `
 
 type GetSessionByTokenRow struct {
-	ID           string           `db:"id" json:"id"`
-	ExpiresAt    pgtype.Timestamp `db:"expires_at" json:"expires_at"`
-	Token        string           `db:"token" json:"token"`
-	CreatedAt    pgtype.Timestamp `db:"created_at" json:"created_at"`
-	UpdatedAt    pgtype.Timestamp `db:"updated_at" json:"updated_at"`
-	IpAddress    pgtype.Text      `db:"ip_address" json:"ip_address"`
-	UserAgent    pgtype.Text      `db:"user_agent" json:"user_agent"`
-	UserID       string           `db:"user_id" json:"user_id"`
-	AccountID    pgtype.Text      `db:"account_id" json:"account_id"`
-	RefreshToken pgtype.Text      `db:"refresh_token" json:"refresh_token"`
-	ProviderID   string           `db:"provider_id" json:"provider_id"`
+	ID              string           `db:"id" json:"id"`
+	ExpiresAt       pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	Token           string           `db:"token" json:"token"`
+	CreatedAt       pgtype.Timestamp `db:"created_at" json:"created_at"`
+	UpdatedAt       pgtype.Timestamp `db:"updated_at" json:"updated_at"`
+	IpAddress       pgtype.Text      `db:"ip_address" json:"ip_address"`
+	UserAgent       pgtype.Text      `db:"user_agent" json:"user_agent"`
+	UserID          string           `db:"user_id" json:"user_id"`
+	AccountID       pgtype.Text      `db:"account_id" json:"account_id"`
+	AuthenticatedAt pgtype.Timestamp `db:"authenticated_at" json:"authenticated_at"`
+	RefreshToken    pgtype.Text      `db:"refresh_token" json:"refresh_token"`
+	ProviderID      string           `db:"provider_id" json:"provider_id"`
 }
 
 func (q *Queries) GetSessionByToken(ctx context.Context, token string) (GetSessionByTokenRow, error) {
@@ -218,14 +432,111 @@ func (q *Queries) GetSessionByToken(ctx context.Context, token string) (GetSessi
 		&i.UserAgent,
 		&i.UserID,
 		&i.AccountID,
+		&i.AuthenticatedAt,
 		&i.RefreshToken,
 		&i.ProviderID,
 	)
 	return i, err
 }
 
+const getUserAccounts = `-- name: GetUserAccounts :many
+SELECT id, account_id, provider_id, user_id, access_token, refresh_token, id_token, access_token_expires_at, scope, password, created_at, updated_at FROM "public"."account"
+WHERE "user_id" = $1
+ORDER BY created_at
+`
+
+func (q *Queries) GetUserAccounts(ctx context.Context, userID string) ([]Account, error) {
+	rows, err := q.db.Query(ctx, getUserAccounts, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Account
+	for rows.Next() {
+		var i Account
+		if err := rows.Scan(
+			&i.ID,
+			&i.AccountID,
+			&i.ProviderID,
+			&i.UserID,
+			&i.AccessToken,
+			&i.RefreshToken,
+			&i.IDToken,
+			&i.AccessTokenExpiresAt,
+			&i.Scope,
+			&i.Password,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserAuditEvents = `-- name: GetUserAuditEvents :many
+SELECT id, user_id, type, ip_address, user_agent, metadata, created_at FROM "public"."audit_event"
+WHERE "user_id" = $1
+ORDER BY created_at
+`
+
+func (q *Queries) GetUserAuditEvents(ctx context.Context, userID string) ([]AuditEvent, error) {
+	rows, err := q.db.Query(ctx, getUserAuditEvents, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditEvent
+	for rows.Next() {
+		var i AuditEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Type,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.Metadata,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT id, name, email, email_verified, image, created_at, updated_at, disabled_at, deletion_scheduled_at FROM "public"."user"
+WHERE email = $1
+LIMIT 1
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRow(ctx, getUserByEmail, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Email,
+		&i.EmailVerified,
+		&i.Image,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DisabledAt,
+		&i.DeletionScheduledAt,
+	)
+	return i, err
+}
+
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, name, email, email_verified, image, created_at, updated_at FROM "public"."user"
+SELECT id, name, email, email_verified, image, created_at, updated_at, disabled_at, deletion_scheduled_at FROM "public"."user"
 WHERE id = $1
 LIMIT 1
 `
@@ -241,12 +552,14 @@ func (q *Queries) GetUserByID(ctx context.Context, id string) (User, error) {
 		&i.Image,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DisabledAt,
+		&i.DeletionScheduledAt,
 	)
 	return i, err
 }
 
 const getUserSessions = `-- name: GetUserSessions :many
-SELECT id, expires_at, token, created_at, updated_at, ip_address, user_agent, user_id, account_id FROM "public"."session"
+SELECT id, expires_at, token, created_at, updated_at, ip_address, user_agent, user_id, account_id, authenticated_at FROM "public"."session"
 WHERE "user_id" = $1
 `
 
@@ -269,6 +582,7 @@ func (q *Queries) GetUserSessions(ctx context.Context, userID string) ([]Session
 			&i.UserAgent,
 			&i.UserID,
 			&i.AccountID,
+			&i.AuthenticatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -280,6 +594,38 @@ func (q *Queries) GetUserSessions(ctx context.Context, userID string) ([]Session
 	return items, nil
 }
 
+const getUsersDueForDeletion = `-- name: GetUsersDueForDeletion :many
+SELECT id FROM "public"."user"
+WHERE "deletion_scheduled_at" <= $1
+ORDER BY "deletion_scheduled_at"
+LIMIT $2
+`
+
+type GetUsersDueForDeletionParams struct {
+	Now   pgtype.Timestamp `db:"now" json:"now"`
+	Limit int32            `db:"limit" json:"limit"`
+}
+
+func (q *Queries) GetUsersDueForDeletion(ctx context.Context, arg GetUsersDueForDeletionParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, getUsersDueForDeletion, arg.Now, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listAuthors = `-- name: ListAuthors :many
 SELECT id, name, bio FROM authors
 ORDER BY name
@@ -305,6 +651,105 @@ func (q *Queries) ListAuthors(ctx context.Context) ([]Author, error) {
 	return items, nil
 }
 
+const lockRateLimit = `-- name: LockRateLimit :one
+INSERT INTO "public"."rate_limit" ("key", "value", "expires_at")
+VALUES ($1, 'null', $2)
+ON CONFLICT("key") DO UPDATE SET "key" = EXCLUDED."key"
+RETURNING "value", "expires_at"
+`
+
+type LockRateLimitParams struct {
+	Key string           `db:"key" json:"key"`
+	Now pgtype.Timestamp `db:"now" json:"now"`
+}
+
+type LockRateLimitRow struct {
+	Value     []byte           `db:"value" json:"value"`
+	ExpiresAt pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+}
+
+// Returns the state and locks the row until the transaction ends. A missing
+// key is inserted as an already expired placeholder so it can be locked too.
+func (q *Queries) LockRateLimit(ctx context.Context, arg LockRateLimitParams) (LockRateLimitRow, error) {
+	row := q.db.QueryRow(ctx, lockRateLimit, arg.Key, arg.Now)
+	var i LockRateLimitRow
+	err := row.Scan(&i.Value, &i.ExpiresAt)
+	return i, err
+}
+
+const purgeUser = `-- name: PurgeUser :one
+DELETE FROM "public"."user"
+WHERE id = $1 AND "deletion_scheduled_at" <= $2
+RETURNING id
+`
+
+type PurgeUserParams struct {
+	ID  string           `db:"id" json:"id"`
+	Now pgtype.Timestamp `db:"now" json:"now"`
+}
+
+func (q *Queries) PurgeUser(ctx context.Context, arg PurgeUserParams) (string, error) {
+	row := q.db.QueryRow(ctx, purgeUser, arg.ID, arg.Now)
+	var id string
+	err := row.Scan(&id)
+	return id, err
+}
+
+const putCacheEntry = `-- name: PutCacheEntry :exec
+INSERT INTO "public"."cache_entry" ("key", "value", "expires_at")
+VALUES ($1, $2, $3)
+ON CONFLICT("key") DO UPDATE SET "value" = EXCLUDED."value", "expires_at" = EXCLUDED."expires_at"
+`
+
+type PutCacheEntryParams struct {
+	Key       string           `db:"key" json:"key"`
+	Value     []byte           `db:"value" json:"value"`
+	ExpiresAt pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+}
+
+func (q *Queries) PutCacheEntry(ctx context.Context, arg PutCacheEntryParams) error {
+	_, err := q.db.Exec(ctx, putCacheEntry, arg.Key, arg.Value, arg.ExpiresAt)
+	return err
+}
+
+const scheduleUserDeletion = `-- name: ScheduleUserDeletion :one
+UPDATE "public"."user"
+SET "disabled_at" = $1,
+    "deletion_scheduled_at" = $2
+WHERE id = $3 AND "deletion_scheduled_at" IS NULL
+RETURNING id
+`
+
+type ScheduleUserDeletionParams struct {
+	DisabledAt          pgtype.Timestamp `db:"disabled_at" json:"disabled_at"`
+	DeletionScheduledAt pgtype.Timestamp `db:"deletion_scheduled_at" json:"deletion_scheduled_at"`
+	ID                  string           `db:"id" json:"id"`
+}
+
+func (q *Queries) ScheduleUserDeletion(ctx context.Context, arg ScheduleUserDeletionParams) (string, error) {
+	row := q.db.QueryRow(ctx, scheduleUserDeletion, arg.DisabledAt, arg.DeletionScheduledAt, arg.ID)
+	var id string
+	err := row.Scan(&id)
+	return id, err
+}
+
+const setRateLimit = `-- name: SetRateLimit :exec
+UPDATE "public"."rate_limit"
+SET "value" = $1, "expires_at" = $2
+WHERE "key" = $3
+`
+
+type SetRateLimitParams struct {
+	Value     []byte           `db:"value" json:"value"`
+	ExpiresAt pgtype.Timestamp `db:"expires_at" json:"expires_at"`
+	Key       string           `db:"key" json:"key"`
+}
+
+func (q *Queries) SetRateLimit(ctx context.Context, arg SetRateLimitParams) error {
+	_, err := q.db.Exec(ctx, setRateLimit, arg.Value, arg.ExpiresAt, arg.Key)
+	return err
+}
+
 const testDatabaseConnection = `-- name: TestDatabaseConnection :one
 SELECT NOW()
 `
@@ -366,6 +811,26 @@ func (q *Queries) UpdateAuthor(ctx context.Context, arg UpdateAuthorParams) erro
 	return err
 }
 
+const updateSessionAuthenticatedAt = `-- name: UpdateSessionAuthenticatedAt :execrows
+UPDATE "public"."session"
+SET "authenticated_at" = $1,
+    "updated_at" = NOW()
+WHERE id = $2
+`
+
+type UpdateSessionAuthenticatedAtParams struct {
+	AuthenticatedAt pgtype.Timestamp `db:"authenticated_at" json:"authenticated_at"`
+	ID              string           `db:"id" json:"id"`
+}
+
+func (q *Queries) UpdateSessionAuthenticatedAt(ctx context.Context, arg UpdateSessionAuthenticatedAtParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateSessionAuthenticatedAt, arg.AuthenticatedAt, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const updateUser = `-- name: UpdateUser :one
 UPDATE "public"."user"
 SET "name" = $2,
@@ -1,7 +1,6 @@
 package utils
 
 import (
-	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
@@ -17,52 +16,6 @@ import (
 	"net/http"
 )
 
-var secret = []byte("NK9LELM2XGM89R5XYJ6S====")
-
-const (
-	CsrfCookieName = "csrf_token"
-	CsrfHeaderName = "X-CSRF-Token"
-)
-
-func GenerateCSRFToken(sessionId string) (string, string) {
-
-	csrfToken, err := GenerateRandomString()
-	if err != nil {
-		log.Fatal(err)
-	}
-	mac := hmac.New(sha256.New, secret)
-	mac.Write([]byte(csrfToken + "." + sessionId))
-	csrfTokenHMAC := mac.Sum(nil)
-	return csrfToken, EncodeBase64UrlNoPadding(csrfTokenHMAC)
-}
-
-func VerifyCSRFToken(token string, sessionId string, storedHMAC string) bool {
-	decodedHMAC, err := DecodeBase64UrlNoPadding(storedHMAC)
-	if err != nil {
-		return false
-	}
-	mac := hmac.New(sha256.New, secret)
-	mac.Write([]byte(token + "." + sessionId))
-	expectedHMAC := mac.Sum(nil)
-	log.Println("expectedHMAC: ", expectedHMAC)
-	log.Println("storedHMAC: ", storedHMAC)
-	return hmac.Equal(decodedHMAC, expectedHMAC)
-}
-
-// SetCSRFToken sets a new CSRF token in the response
-func SetCSRFToken(w http.ResponseWriter, sessionId string) {
-	token, encodedHMAC := GenerateCSRFToken(sessionId)
-	log.Println("encodedHMAC: ", encodedHMAC)
-	http.SetCookie(w, &http.Cookie{
-		Name:   CsrfCookieName,
-		Value:  encodedHMAC,
-		Path:   "/",
-		MaxAge: 3600,
-	})
-	// Return the token to be used in the X-CSRF-Token header
-	w.Header().Set(CsrfHeaderName, token)
-}
-
 func GenerateSessionToken() (string, error) {
 	//TODO : generate hash for storage and function to verify similar to csrf
 	state, err := GenerateRandomStringNoPadding()
@@ -236,12 +189,12 @@ func (t *OAuth2Tokens) HasIDToken() bool {
 
 var logger = NewLogger(DEBUG, true)
 
-func ValidateSession(q *sqlc.Queries, w http.ResponseWriter, r *http.Request) (bool, error) {
+func ValidateSession(q *sqlc.Queries, w http.ResponseWriter, r *http.Request, sessionCookie CookiePolicy) (bool, error) {
 	logger.Debug("ValidateSession")
-	cookie, _ := r.Cookie("session_token")
+	token, _ := sessionCookie.Read(r)
 
-	if cookie != nil {
-		session, err := q.GetSessionByToken(context.Background(), cookie.Value)
+	if token != "" {
+		session, err := q.GetSessionByToken(context.Background(), token)
 		if err != nil {
 			logger.Error("error getting session by token: %v", err)
 			return false, err
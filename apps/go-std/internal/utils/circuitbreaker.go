@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker.Allow while calls are failing fast
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// circuitMetrics publishes every breaker's state under "circuit_breakers" in /debug/vars
+var circuitMetrics = expvar.NewMap("circuit_breakers")
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreakerSettings configures a CircuitBreaker
+type CircuitBreakerSettings struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit
+	FailureThreshold int
+	// OpenTimeout is how long the circuit stays open before probing again
+	OpenTimeout time.Duration
+	// HalfOpenMaxRequests is the number of probes let through when half-open.
+	// The circuit closes once all of them succeed.
+	HalfOpenMaxRequests int
+}
+
+// CircuitBreaker stops calling a failing dependency. It is closed while calls
+// succeed, opens after FailureThreshold consecutive failures and rejects calls
+// for OpenTimeout, then lets HalfOpenMaxRequests probes through to decide
+// whether to close or open again.
+type CircuitBreaker struct {
+	name     string
+	settings CircuitBreakerSettings
+	now      func() time.Time
+
+	mu         sync.Mutex
+	state      CircuitState
+	generation uint64
+	failures   int
+	openedAt   time.Time
+	probes     int
+	successes  int
+
+	rejected    uint64
+	transitions uint64
+}
+
+// CircuitBreakerStats is a snapshot of a breaker
+type CircuitBreakerStats struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	Rejected            uint64 `json:"rejected"`
+	Transitions         uint64 `json:"transitions"`
+}
+
+func NewCircuitBreaker(name string, settings CircuitBreakerSettings) *CircuitBreaker {
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = 5
+	}
+	if settings.OpenTimeout <= 0 {
+		settings.OpenTimeout = time.Second * 30
+	}
+	if settings.HalfOpenMaxRequests <= 0 {
+		settings.HalfOpenMaxRequests = 1
+	}
+	cb := &CircuitBreaker{name: name, settings: settings, now: time.Now}
+	circuitMetrics.Set(name, expvar.Func(func() any { return cb.Stats() }))
+	return cb
+}
+
+// Allow reports whether a call may proceed. When it may, done must be called
+// with the call's outcome.
+func (cb *CircuitBreaker) Allow() (func(success bool), error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.advance()
+	switch cb.state {
+	case CircuitOpen:
+		cb.rejected++
+		return nil, ErrCircuitOpen
+	case CircuitHalfOpen:
+		if cb.probes >= cb.settings.HalfOpenMaxRequests {
+			cb.rejected++
+			return nil, ErrCircuitOpen
+		}
+		cb.probes++
+	}
+
+	generation := cb.generation
+	return func(success bool) { cb.record(generation, success) }, nil
+}
+
+func (cb *CircuitBreaker) record(generation uint64, success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	// Outcomes of calls started before the last state change are stale
+	if generation != cb.generation {
+		return
+	}
+
+	switch cb.state {
+	case CircuitClosed:
+		if success {
+			cb.failures = 0
+			return
+		}
+		cb.failures++
+		if cb.failures >= cb.settings.FailureThreshold {
+			cb.transition(CircuitOpen)
+		}
+	case CircuitHalfOpen:
+		if !success {
+			cb.transition(CircuitOpen)
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.settings.HalfOpenMaxRequests {
+			cb.transition(CircuitClosed)
+		}
+	}
+}
+
+// advance moves an open circuit to half-open once OpenTimeout has passed. The
+// lock must be held.
+func (cb *CircuitBreaker) advance() {
+	if cb.state == CircuitOpen && !cb.now().Before(cb.openedAt.Add(cb.settings.OpenTimeout)) {
+		cb.transition(CircuitHalfOpen)
+	}
+}
+
+// transition changes the state and resets its counters. The lock must be held.
+func (cb *CircuitBreaker) transition(to CircuitState) {
+	from := cb.state
+	cb.state = to
+	cb.generation++
+	cb.transitions++
+	cb.failures, cb.probes, cb.successes = 0, 0, 0
+	if to == CircuitOpen {
+		cb.openedAt = cb.now()
+		logger.Warn("circuit breaker %s: %s -> %s, failing fast for %s", cb.name, from, to, cb.settings.OpenTimeout)
+	} else {
+		logger.Info("circuit breaker %s: %s -> %s", cb.name, from, to)
+	}
+}
+
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.advance()
+	return cb.state
+}
+
+// RetryAfter is how long until an open circuit lets probes through, zero
+// otherwise
+func (cb *CircuitBreaker) RetryAfter() time.Duration {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.advance()
+	if cb.state != CircuitOpen {
+		return 0
+	}
+	return cb.openedAt.Add(cb.settings.OpenTimeout).Sub(cb.now())
+}
+
+func (cb *CircuitBreaker) Stats() CircuitBreakerStats {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.advance()
+	return CircuitBreakerStats{
+		State:               cb.state.String(),
+		ConsecutiveFailures: cb.failures,
+		Rejected:            cb.rejected,
+		Transitions:         cb.transitions,
+	}
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func call(t *testing.T, cb *CircuitBreaker, success bool) error {
+	t.Helper()
+	done, err := cb.Allow()
+	if err != nil {
+		return err
+	}
+	done(success)
+	return nil
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	clock := newFakeClock()
+	cb := NewCircuitBreaker("test", CircuitBreakerSettings{FailureThreshold: 3, OpenTimeout: time.Minute, HalfOpenMaxRequests: 2})
+	cb.now = clock.Now
+
+	call(t, cb, false)
+	call(t, cb, false)
+	call(t, cb, true)
+	if cb.State() != CircuitClosed {
+		t.Fatal("a success must reset the consecutive failures")
+	}
+	for i := 0; i < 3; i++ {
+		call(t, cb, false)
+	}
+	if cb.State() != CircuitOpen {
+		t.Fatalf("expected open after 3 failures, got %s", cb.State())
+	}
+	if err := call(t, cb, true); err != ErrCircuitOpen {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if d := cb.RetryAfter(); d != time.Minute {
+		t.Fatalf("expected retry after 1m, got %s", d)
+	}
+
+	clock.Advance(time.Minute)
+	if cb.State() != CircuitHalfOpen {
+		t.Fatalf("expected half-open after the timeout, got %s", cb.State())
+	}
+	first, _ := cb.Allow()
+	second, _ := cb.Allow()
+	if _, err := cb.Allow(); err != ErrCircuitOpen {
+		t.Fatalf("expected probes to be limited, got %v", err)
+	}
+	first(true)
+	second(true)
+	if cb.State() != CircuitClosed {
+		t.Fatalf("expected closed after successful probes, got %s", cb.State())
+	}
+}
+
+func TestCircuitBreakerReopensOnFailedProbe(t *testing.T) {
+	clock := newFakeClock()
+	cb := NewCircuitBreaker("test", CircuitBreakerSettings{FailureThreshold: 1, OpenTimeout: time.Minute})
+	cb.now = clock.Now
+
+	// A call started while closed must not affect the later state
+	stale, _ := cb.Allow()
+	call(t, cb, false)
+	clock.Advance(time.Minute)
+	stale(true)
+	if cb.State() != CircuitHalfOpen {
+		t.Fatalf("expected stale outcome to be ignored, got %s", cb.State())
+	}
+
+	call(t, cb, false)
+	if cb.State() != CircuitOpen {
+		t.Fatalf("expected failed probe to reopen, got %s", cb.State())
+	}
+	if s := cb.Stats(); s.Transitions != 3 || s.Rejected != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// ParseCIDRs parses a comma separated list of CIDRs and plain IPs
+func ParseCIDRs(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IP %q", part)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", part)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// ClientIPResolver finds the IP of the client behind trusted reverse proxies.
+// Forwarding headers are only honoured when the request comes from a trusted
+// proxy, and are read right to left, skipping trusted hops, so a client
+// cannot spoof its IP by sending the headers itself.
+type ClientIPResolver struct {
+	trusted []netip.Prefix
+}
+
+// NewClientIPResolver creates a resolver trusting the proxies in trusted
+func NewClientIPResolver(trusted []netip.Prefix) *ClientIPResolver {
+	return &ClientIPResolver{trusted: trusted}
+}
+
+func (c *ClientIPResolver) isTrusted(addr netip.Addr) bool {
+	for _, p := range c.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client IP of r. It prefers RFC 7239 Forwarded, then
+// X-Forwarded-For, then X-Real-IP, and falls back to the peer address.
+func (c *ClientIPResolver) Resolve(r *http.Request) string {
+	peer, err := netip.ParseAddr(RemoteIP(r))
+	if err != nil {
+		return RemoteIP(r)
+	}
+	peer = peer.Unmap()
+	if !c.isTrusted(peer) {
+		return peer.String()
+	}
+
+	if hops := r.Header.Values("Forwarded"); len(hops) > 0 {
+		return c.walk(peer, parseForwarded(hops)).String()
+	}
+	if hops := r.Header.Values("X-Forwarded-For"); len(hops) > 0 {
+		return c.walk(peer, splitHeaderList(hops)).String()
+	}
+	if v := r.Header.Get("X-Real-IP"); v != "" {
+		if addr, ok := parseHopAddr(v); ok {
+			return addr.String()
+		}
+	}
+	return peer.String()
+}
+
+// walk returns the rightmost untrusted hop. An invalid hop ends the walk at
+// the last trusted one, as nothing left of it can be relied on.
+func (c *ClientIPResolver) walk(peer netip.Addr, hops []string) netip.Addr {
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHopAddr(hops[i])
+		if !ok {
+			return client
+		}
+		client = addr
+		if !c.isTrusted(addr) {
+			return client
+		}
+	}
+	return client
+}
+
+func splitHeaderList(values []string) []string {
+	var items []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+	}
+	return items
+}
+
+// parseForwarded returns the for= parameter of every element of RFC 7239
+// Forwarded headers, "" for elements without one
+func parseForwarded(values []string) []string {
+	var hops []string
+	for _, element := range splitHeaderList(values) {
+		hop := ""
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(key, "for") {
+				hop = strings.Trim(value, `"`)
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// parseHopAddr parses a hop as IP, [IPv6]:port or IPv4:port. Obfuscated and
+// "unknown" identifiers are not addresses.
+func parseHopAddr(hop string) (netip.Addr, bool) {
+	hop = strings.TrimSpace(hop)
+	if addr, err := netip.ParseAddr(strings.Trim(hop, "[]")); err == nil {
+		return addr.Unmap(), true
+	}
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		if addr, err := netip.ParseAddr(host); err == nil {
+			return addr.Unmap(), true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// WithClientIP returns a copy of r whose context carries the client IP
+func WithClientIP(r *http.Request, ip string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+}
+
+// ClientIPFromContext returns the client IP stored by WithClientIP
+func ClientIPFromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(clientIPKey{}).(string)
+	return ip, ok
+}
+
+// ClientIP returns the client IP resolved for r, or the peer address when no
+// resolver ran for the request
+func ClientIP(r *http.Request) string {
+	if ip, ok := ClientIPFromContext(r.Context()); ok {
+		return ip
+	}
+	return RemoteIP(r)
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	trusted, err := ParseCIDRs("10.0.0.0/8, 2001:db8:ffff::/48")
+	if err != nil {
+		t.Fatalf("ParseCIDRs: %v", err)
+	}
+	resolver := NewClientIPResolver(trusted)
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer ignores headers", "198.51.100.7:1234", map[string]string{"X-Forwarded-For": "203.0.113.1"}, "198.51.100.7"},
+		{"trusted peer without headers", "10.0.0.2:1234", nil, "10.0.0.2"},
+		{"x-forwarded-for", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "203.0.113.1"}, "203.0.113.1"},
+		{"spoofed left entries are skipped", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.1, 10.0.0.9"}, "203.0.113.1"},
+		{"all hops trusted", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "10.1.1.1, 10.0.0.9"}, "10.1.1.1"},
+		{"invalid hop stops the walk", "10.0.0.2:1234", map[string]string{"X-Forwarded-For": "203.0.113.1, garbage"}, "10.0.0.2"},
+		{"forwarded wins", "10.0.0.2:1234", map[string]string{
+			"Forwarded":       `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711"`,
+			"X-Forwarded-For": "203.0.113.1",
+		}, "2001:db8:cafe::17"},
+		{"forwarded ipv4 with port", "10.0.0.2:1234", map[string]string{"Forwarded": `for="192.0.2.60:8080"`}, "192.0.2.60"},
+		{"forwarded unknown", "10.0.0.2:1234", map[string]string{"Forwarded": "for=unknown"}, "10.0.0.2"},
+		{"x-real-ip", "[2001:db8:ffff::1]:443", map[string]string{"X-Real-IP": "203.0.113.5"}, "203.0.113.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.peer
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := resolver.Resolve(r); got != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPFromContext(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	if got := ClientIP(r); got != "10.0.0.2" {
+		t.Fatalf("expected peer IP without port, got %s", got)
+	}
+	if got := ClientIP(WithClientIP(r, "203.0.113.1")); got != "203.0.113.1" {
+		t.Fatalf("expected IP from context, got %s", got)
+	}
+}
@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrUnsupportedCSPReport is returned for payloads that are neither legacy CSP
+// reports nor Reporting API reports
+var ErrUnsupportedCSPReport = errors.New("unsupported CSP report content type")
+
+// CSPViolation is a CSP violation report, normalized from either the legacy
+// report-uri format or the Reporting API format
+type CSPViolation struct {
+	DocumentURL        string `json:"document_url"`
+	Referrer           string `json:"referrer,omitempty"`
+	BlockedURL         string `json:"blocked_url,omitempty"`
+	EffectiveDirective string `json:"effective_directive"`
+	OriginalPolicy     string `json:"original_policy,omitempty"`
+	Disposition        string `json:"disposition,omitempty"`
+	SourceFile         string `json:"source_file,omitempty"`
+	Sample             string `json:"sample,omitempty"`
+	LineNumber         int    `json:"line_number,omitempty"`
+	ColumnNumber       int    `json:"column_number,omitempty"`
+	StatusCode         int    `json:"status_code,omitempty"`
+}
+
+// legacyCSPReport is the body of application/csp-report, sent for report-uri
+type legacyCSPReport struct {
+	Report struct {
+		DocumentURI        string      `json:"document-uri"`
+		Referrer           string      `json:"referrer"`
+		BlockedURI         string      `json:"blocked-uri"`
+		ViolatedDirective  string      `json:"violated-directive"`
+		EffectiveDirective string      `json:"effective-directive"`
+		OriginalPolicy     string      `json:"original-policy"`
+		Disposition        string      `json:"disposition"`
+		SourceFile         string      `json:"source-file"`
+		ScriptSample       string      `json:"script-sample"`
+		LineNumber         json.Number `json:"line-number"`
+		ColumnNumber       json.Number `json:"column-number"`
+		StatusCode         json.Number `json:"status-code"`
+	} `json:"csp-report"`
+}
+
+// reportingAPIReport is an element of application/reports+json, sent for
+// report-to
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		Referrer           string `json:"referrer"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		OriginalPolicy     string `json:"originalPolicy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"sourceFile"`
+		Sample             string `json:"sample"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+		StatusCode         int    `json:"statusCode"`
+	} `json:"body"`
+}
+
+// ParseCSPReports parses the violations in a report body. Reports of other
+// types in Reporting API batches are skipped.
+func ParseCSPReports(contentType string, body []byte) ([]CSPViolation, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupportedCSPReport
+	}
+
+	switch mediaType {
+	case "application/csp-report", "application/json":
+		var legacy legacyCSPReport
+		if err := json.Unmarshal(body, &legacy); err != nil {
+			return nil, fmt.Errorf("invalid CSP report: %w", err)
+		}
+		r := legacy.Report
+		directive := r.EffectiveDirective
+		if directive == "" {
+			directive = r.ViolatedDirective
+		}
+		if r.DocumentURI == "" || directive == "" {
+			return nil, errors.New("invalid CSP report: missing document-uri or directive")
+		}
+		return []CSPViolation{{
+			DocumentURL:        r.DocumentURI,
+			Referrer:           r.Referrer,
+			BlockedURL:         r.BlockedURI,
+			EffectiveDirective: directive,
+			OriginalPolicy:     r.OriginalPolicy,
+			Disposition:        r.Disposition,
+			SourceFile:         r.SourceFile,
+			Sample:             r.ScriptSample,
+			LineNumber:         jsonInt(r.LineNumber),
+			ColumnNumber:       jsonInt(r.ColumnNumber),
+			StatusCode:         jsonInt(r.StatusCode),
+		}}, nil
+
+	case "application/reports+json":
+		var reports []reportingAPIReport
+		if err := json.Unmarshal(body, &reports); err != nil {
+			return nil, fmt.Errorf("invalid CSP report: %w", err)
+		}
+		violations := []CSPViolation{}
+		for _, r := range reports {
+			if r.Type != "csp-violation" {
+				continue
+			}
+			documentURL := r.Body.DocumentURL
+			if documentURL == "" {
+				documentURL = r.URL
+			}
+			violations = append(violations, CSPViolation{
+				DocumentURL:        documentURL,
+				Referrer:           r.Body.Referrer,
+				BlockedURL:         r.Body.BlockedURL,
+				EffectiveDirective: r.Body.EffectiveDirective,
+				OriginalPolicy:     r.Body.OriginalPolicy,
+				Disposition:        r.Body.Disposition,
+				SourceFile:         r.Body.SourceFile,
+				Sample:             r.Body.Sample,
+				LineNumber:         r.Body.LineNumber,
+				ColumnNumber:       r.Body.ColumnNumber,
+				StatusCode:         r.Body.StatusCode,
+			})
+		}
+		return violations, nil
+	}
+	return nil, ErrUnsupportedCSPReport
+}
+
+// jsonInt reads numbers that some browsers send as strings
+func jsonInt(n json.Number) int {
+	i, _ := strconv.Atoi(n.String())
+	return i
+}
+
+// Fingerprint identifies a violation regardless of the query string and
+// fragment of the page it happened on, which often carry per user values
+func (v CSPViolation) Fingerprint() string {
+	h := sha256.New()
+	for _, part := range []string{
+		stripQuery(v.DocumentURL),
+		v.EffectiveDirective,
+		stripQuery(v.BlockedURL),
+		stripQuery(v.SourceFile),
+		strconv.Itoa(v.LineNumber),
+		strconv.Itoa(v.ColumnNumber),
+		v.Disposition,
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func stripQuery(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+type cspReportSeen struct {
+	since      time.Time
+	duplicates int
+}
+
+// CSPReportCollector deduplicates CSP violations. Browsers report the same
+// violation on every page view, so each one is only passed to the sink once
+// per window, together with the number of duplicates dropped in the window
+// before.
+type CSPReportCollector struct {
+	seen   *TTLMap[string, cspReportSeen]
+	window time.Duration
+	sink   func(v CSPViolation, duplicates int)
+	now    func() time.Time
+}
+
+// NewCSPReportCollector creates a collector passing violations to sink at most
+// once per window
+func NewCSPReportCollector(window time.Duration, sink func(v CSPViolation, duplicates int)) *CSPReportCollector {
+	return &CSPReportCollector{
+		seen:   NewTTLMap[string, cspReportSeen](10000, window*2, "csp_reports"),
+		window: window,
+		sink:   sink,
+		now:    time.Now,
+	}
+}
+
+// Collect records v and reports whether it was passed to the sink
+func (c *CSPReportCollector) Collect(v CSPViolation) bool {
+	now := c.now()
+	report, duplicates := false, 0
+	c.seen.Update(v.Fingerprint(), func(seen cspReportSeen, ok bool) cspReportSeen {
+		if ok && now.Sub(seen.since) < c.window {
+			seen.duplicates++
+			return seen
+		}
+		report, duplicates = true, seen.duplicates
+		return cspReportSeen{since: now}
+	})
+	if report {
+		c.sink(v, duplicates)
+	}
+	return report
+}
+
+// Close stops the collector's janitor
+func (c *CSPReportCollector) Close() {
+	c.seen.Close()
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCSPReports(t *testing.T) {
+	legacy := `{"csp-report": {
+		"document-uri": "https://example.com/page?user=1",
+		"violated-directive": "script-src-elem",
+		"blocked-uri": "https://evil.test/x.js",
+		"line-number": "12"
+	}}`
+	got, err := ParseCSPReports("application/csp-report", []byte(legacy))
+	if err != nil {
+		t.Fatalf("legacy report: %v", err)
+	}
+	if len(got) != 1 || got[0].EffectiveDirective != "script-src-elem" || got[0].LineNumber != 12 {
+		t.Fatalf("unexpected legacy violations %+v", got)
+	}
+
+	batch := `[
+		{"type": "csp-violation", "url": "https://example.com/page", "body": {
+			"documentURL": "https://example.com/page?user=2",
+			"effectiveDirective": "script-src-elem",
+			"blockedURL": "https://evil.test/x.js",
+			"disposition": "report",
+			"lineNumber": 12
+		}},
+		{"type": "deprecation", "url": "https://example.com/page", "body": {}}
+	]`
+	got, err = ParseCSPReports("application/reports+json", []byte(batch))
+	if err != nil {
+		t.Fatalf("reporting API batch: %v", err)
+	}
+	if len(got) != 1 || got[0].Disposition != "report" || got[0].BlockedURL != "https://evil.test/x.js" {
+		t.Fatalf("unexpected batch violations %+v", got)
+	}
+
+	if _, err := ParseCSPReports("text/plain", []byte(legacy)); err != ErrUnsupportedCSPReport {
+		t.Fatalf("expected ErrUnsupportedCSPReport, got %v", err)
+	}
+	if _, err := ParseCSPReports("application/csp-report", []byte(`{"csp-report": {}}`)); err == nil {
+		t.Fatal("expected an empty report to be rejected")
+	}
+}
+
+func TestCSPReportCollectorDeduplicates(t *testing.T) {
+	clock := newFakeClock()
+	var reported []int
+	c := NewCSPReportCollector(time.Hour, func(v CSPViolation, duplicates int) {
+		reported = append(reported, duplicates)
+	})
+	defer c.Close()
+	c.now = clock.Now
+
+	v := CSPViolation{DocumentURL: "https://example.com/page?user=1", EffectiveDirective: "img-src", BlockedURL: "https://evil.test/a.png"}
+	if !c.Collect(v) {
+		t.Fatal("expected the first report to pass")
+	}
+	v.DocumentURL = "https://example.com/page?user=2"
+	if c.Collect(v) || c.Collect(v) {
+		t.Fatal("expected duplicates to be dropped regardless of the query")
+	}
+	if !c.Collect(CSPViolation{DocumentURL: "https://example.com/other", EffectiveDirective: "img-src"}) {
+		t.Fatal("expected another violation to pass")
+	}
+
+	clock.Advance(time.Hour)
+	if !c.Collect(v) {
+		t.Fatal("expected the violation to be reported again after the window")
+	}
+	if len(reported) != 3 || reported[2] != 2 {
+		t.Fatalf("expected the duplicate count with the repeat, got %v", reported)
+	}
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	CsrfCookieName = "csrf_token"
+	CsrfHeaderName = "X-CSRF-Token"
+	// CsrfFormField carries the token in server rendered forms
+	CsrfFormField = "csrf_token"
+	// csrfTokenLength is the length of the tokens of GenerateRandomString
+	csrfTokenLength = 24
+)
+
+// ErrInvalidCSRFMask is returned for tokens that are not masked tokens
+var ErrInvalidCSRFMask = errors.New("invalid masked CSRF token")
+
+// MaskCSRFToken XORs token with a random pad and returns pad and masked token
+// together, base64url encoded. Every masking of the same token looks
+// different, so a token in a compressed response cannot be recovered by
+// BREACH style length oracles.
+func MaskCSRFToken(token string) string {
+	pad := make([]byte, len(token))
+	rand.Read(pad)
+	masked := make([]byte, 2*len(token))
+	copy(masked, pad)
+	for i := range len(token) {
+		masked[len(token)+i] = token[i] ^ pad[i]
+	}
+	return EncodeBase64UrlNoPadding(masked)
+}
+
+// UnmaskCSRFToken returns the token masked by MaskCSRFToken. Anything that
+// does not decode to a masked token of the generated length is rejected.
+func UnmaskCSRFToken(masked string) (string, error) {
+	data, err := DecodeBase64UrlNoPadding(masked)
+	if err != nil || len(data) != 2*csrfTokenLength {
+		return "", ErrInvalidCSRFMask
+	}
+	n := len(data) / 2
+	token := make([]byte, n)
+	for i := range n {
+		token[i] = data[i] ^ data[n+i]
+	}
+	return string(token), nil
+}
+
+// CSRFSigner binds CSRF tokens to sessions with an HMAC. The HMAC is stored in
+// a cookie prefixed with the ID of the key that made it, so tokens signed with
+// an older key stay valid after a rotation until the key is removed.
+type CSRFSigner struct {
+	keys *KeyRing
+}
+
+// NewCSRFSigner creates a signer. The primary key signs, all keys verify.
+func NewCSRFSigner(keys *KeyRing) *CSRFSigner {
+	return &CSRFSigner{keys: keys}
+}
+
+// Generate returns a new token for the session and the value to store in the
+// CSRF cookie
+func (s *CSRFSigner) Generate(sessionId string) (string, string) {
+	csrfToken, err := GenerateRandomString()
+	if err != nil {
+		log.Fatal(err)
+	}
+	key := s.keys.Primary()
+	return csrfToken, key.ID + "." + EncodeBase64UrlNoPadding(csrfMAC(key, csrfToken, sessionId))
+}
+
+// Verify checks token against the stored value of the session
+func (s *CSRFSigner) Verify(token string, sessionId string, stored string) bool {
+	id, encodedHMAC, ok := strings.Cut(stored, ".")
+	if !ok {
+		return false
+	}
+	key, ok := s.keys.Lookup(id)
+	if !ok {
+		return false
+	}
+	storedHMAC, err := DecodeBase64UrlNoPadding(encodedHMAC)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(storedHMAC, csrfMAC(key, token, sessionId))
+}
+
+// SetToken sets a new CSRF token in the response and returns it masked, for
+// pages that render it into a form or a JSON body
+func (s *CSRFSigner) SetToken(w http.ResponseWriter, policy CookiePolicy, sessionId string) string {
+	token, stored := s.Generate(sessionId)
+	// The cookie is never read by scripts, only compared server side
+	policy.Set(w, stored)
+	// Return the token to be used in the X-CSRF-Token header
+	masked := MaskCSRFToken(token)
+	w.Header().Set(CsrfHeaderName, masked)
+	return masked
+}
+
+func csrfMAC(key CookieKey, token, sessionId string) []byte {
+	return key.mac("csrf", []byte(token+"."+sessionId))
+}
@@ -0,0 +1,56 @@
+package utils
+
+import "testing"
+
+func TestCSRFSignerRotation(t *testing.T) {
+	old, err := ParseKeyRing("k1:0123456789abcdef0123")
+	if err != nil {
+		t.Fatalf("ParseKeyRing: %v", err)
+	}
+	rotated, err := ParseKeyRing("k2:fedcba9876543210fedc,k1:0123456789abcdef0123")
+	if err != nil {
+		t.Fatalf("ParseKeyRing: %v", err)
+	}
+	removed, err := ParseKeyRing("k2:fedcba9876543210fedc")
+	if err != nil {
+		t.Fatalf("ParseKeyRing: %v", err)
+	}
+
+	token, stored := NewCSRFSigner(old).Generate("session")
+	if !NewCSRFSigner(rotated).Verify(token, "session", stored) {
+		t.Fatal("expected tokens of the old key to verify after rotation")
+	}
+	if NewCSRFSigner(rotated).Verify(token, "other-session", stored) {
+		t.Fatal("a token must be bound to its session")
+	}
+	if NewCSRFSigner(removed).Verify(token, "session", stored) {
+		t.Fatal("expected tokens of a removed key to be rejected")
+	}
+
+	token, stored = NewCSRFSigner(rotated).Generate("session")
+	if stored[:3] != "k2." {
+		t.Fatalf("expected the new key to sign, got %s", stored)
+	}
+	if NewCSRFSigner(old).Verify(token, "session", stored) {
+		t.Fatal("nodes without the new key must reject its tokens")
+	}
+}
+
+func TestMaskCSRFToken(t *testing.T) {
+	token, err := GenerateRandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := MaskCSRFToken(token), MaskCSRFToken(token)
+	if first == second {
+		t.Fatal("expected every masking to differ")
+	}
+	for _, masked := range []string{first, second} {
+		if got, err := UnmaskCSRFToken(masked); err != nil || got != token {
+			t.Fatalf("UnmaskCSRFToken = %q, %v, want %q", got, err, token)
+		}
+	}
+	if got, _ := UnmaskCSRFToken(token); got == token {
+		t.Fatal("an unmasked token must not unmask to itself")
+	}
+}
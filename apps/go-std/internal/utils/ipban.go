@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	ipBanPrefix   = "ip_ban:"
+	ipBanIndexKey = "ip_ban_index"
+)
+
+// TTLCache is a Cache whose entries can expire other than after the cache's
+// default TTL
+type TTLCache interface {
+	Cache
+	PutWithTTL(key string, value interface{}, ttl time.Duration)
+}
+
+// IPBan is a temporary ban of a client IP
+type IPBan struct {
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"`
+	BannedAt  time.Time `json:"banned_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IPBanSettings configures an IPBanList
+type IPBanSettings struct {
+	// Threshold is the number of failures within Window that bans an IP
+	Threshold int
+	Window    time.Duration
+	// Duration is how long a ban lasts
+	Duration time.Duration
+}
+
+// IPBanList bans IPs that cross a threshold of failures, e.g. failed logins.
+// Failures are counted with a sliding window limiter and bans are kept in a
+// Cache, so with a shared backend they apply across all nodes. An index of
+// banned IPs is kept under its own key for listing; concurrent bans on
+// different nodes may race on it, the bans themselves are unaffected.
+type IPBanList struct {
+	cache    Cache
+	failures RateLimiter
+	settings IPBanSettings
+	now      func() time.Time
+	mu       sync.Mutex
+}
+
+// NewIPBanList creates a ban list. opts configure the failure counter, e.g.
+// WithRateLimitStore to count failures across nodes.
+func NewIPBanList(cache Cache, settings IPBanSettings, opts ...RateLimiterOption) *IPBanList {
+	if settings.Threshold <= 0 {
+		settings.Threshold = 10
+	}
+	if settings.Window <= 0 {
+		settings.Window = time.Minute * 10
+	}
+	if settings.Duration <= 0 {
+		settings.Duration = time.Hour
+	}
+	return &IPBanList{
+		cache:    cache,
+		failures: NewSlidingWindowRateLimiter("ip_ban_failures", settings.Threshold, settings.Window, opts...),
+		settings: settings,
+		now:      time.Now,
+	}
+}
+
+// RecordFailure counts a failure for ip and bans it once the threshold is
+// reached. It returns the ban if ip is now banned. A nil list bans nobody.
+func (l *IPBanList) RecordFailure(ip string, reason string) (*IPBan, bool) {
+	if l == nil || ip == "" {
+		return nil, false
+	}
+	result, err := l.failures.Allow(ip)
+	if err != nil {
+		logger.Error("error counting failure of %s: %v", ip, err)
+		return nil, false
+	}
+	if result.Allowed && result.Remaining > 0 {
+		return nil, false
+	}
+
+	now := l.now()
+	ban := IPBan{IP: ip, Reason: reason, BannedAt: now, ExpiresAt: now.Add(l.settings.Duration)}
+	l.put(ipBanPrefix+ip, ban, l.settings.Duration)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	index := l.index()
+	index[ip] = ban.ExpiresAt
+	l.put(ipBanIndexKey, index, l.settings.Duration)
+	logger.Warn("banned %s until %s after repeated %s", ip, ban.ExpiresAt.Format(time.RFC3339), reason)
+	return &ban, true
+}
+
+// Banned returns the ban of ip, if any
+func (l *IPBanList) Banned(ip string) (IPBan, bool) {
+	var ban IPBan
+	if l == nil || !l.get(ipBanPrefix+ip, &ban) || !l.now().Before(ban.ExpiresAt) {
+		return IPBan{}, false
+	}
+	return ban, true
+}
+
+// List returns the active bans ordered by expiry
+func (l *IPBanList) List() []IPBan {
+	if l == nil {
+		return []IPBan{}
+	}
+	l.mu.Lock()
+	index := l.index()
+	l.mu.Unlock()
+
+	bans := []IPBan{}
+	for ip := range index {
+		if ban, ok := l.Banned(ip); ok {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].ExpiresAt.Before(bans[j].ExpiresAt) })
+	return bans
+}
+
+// Lift removes the ban of ip. The failures counted so far are kept, so a
+// single further failure may ban it again until they age out of the window.
+func (l *IPBanList) Lift(ip string) bool {
+	if l == nil {
+		return false
+	}
+	_, banned := l.Banned(ip)
+	l.cache.Delete(ipBanPrefix + ip)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	index := l.index()
+	if _, ok := index[ip]; ok {
+		delete(index, ip)
+		l.put(ipBanIndexKey, index, l.settings.Duration)
+	}
+	if banned {
+		logger.Info("lifted ban of %s", ip)
+	}
+	return banned
+}
+
+// index returns the banned IPs with their expiry, dropping expired ones. The
+// lock must be held.
+func (l *IPBanList) index() map[string]time.Time {
+	index := map[string]time.Time{}
+	l.get(ipBanIndexKey, &index)
+	now := l.now()
+	for ip, expiresAt := range index {
+		if !now.Before(expiresAt) {
+			delete(index, ip)
+		}
+	}
+	return index
+}
+
+// put stores v as a JSON string, which every Cache implementation returns as is
+func (l *IPBanList) put(key string, v interface{}, ttl time.Duration) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("error encoding %s: %v", key, err)
+		return
+	}
+	if c, ok := l.cache.(TTLCache); ok {
+		c.PutWithTTL(key, string(data), ttl)
+		return
+	}
+	l.cache.Put(key, string(data))
+}
+
+func (l *IPBanList) get(key string, v interface{}) bool {
+	raw, ok := l.cache.Get(key)
+	if !ok {
+		return false
+	}
+	data, ok := raw.(string)
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		logger.Error("error decoding %s: %v", key, err)
+		return false
+	}
+	return true
+}
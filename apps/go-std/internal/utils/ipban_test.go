@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIPBanListBansAfterThreshold(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewTTLMap[string, interface{}](100, time.Hour, "test")
+	defer cache.Close()
+	bans := NewIPBanList(cache, IPBanSettings{Threshold: 3, Window: time.Minute, Duration: time.Hour}, WithClock(clock.Now), testStore(t))
+	bans.now = clock.Now
+
+	for i := 0; i < 2; i++ {
+		if _, banned := bans.RecordFailure("192.0.2.1", "invalid_code"); banned {
+			t.Fatalf("failure %d must not ban yet", i+1)
+		}
+	}
+	ban, banned := bans.RecordFailure("192.0.2.1", "invalid_code")
+	if !banned || ban.ExpiresAt != clock.Now().Add(time.Hour) {
+		t.Fatalf("expected ban for an hour, got %+v %v", ban, banned)
+	}
+	if _, banned := bans.Banned("192.0.2.1"); !banned {
+		t.Fatal("expected IP to be banned")
+	}
+	if _, banned := bans.Banned("192.0.2.2"); banned {
+		t.Fatal("other IPs must not be banned")
+	}
+	if list := bans.List(); len(list) != 1 || list[0].IP != "192.0.2.1" || list[0].Reason != "invalid_code" {
+		t.Fatalf("unexpected list %+v", list)
+	}
+
+	if !bans.Lift("192.0.2.1") {
+		t.Fatal("expected Lift to report the ban")
+	}
+	if _, banned := bans.Banned("192.0.2.1"); banned || len(bans.List()) != 0 {
+		t.Fatal("expected ban to be lifted")
+	}
+}
+
+func TestIPBanExpires(t *testing.T) {
+	clock := newFakeClock()
+	cache := NewTTLMap[string, interface{}](100, time.Hour*24, "test")
+	defer cache.Close()
+	bans := NewIPBanList(cache, IPBanSettings{Threshold: 1, Window: time.Minute, Duration: time.Minute}, WithClock(clock.Now), testStore(t))
+	bans.now = clock.Now
+
+	bans.RecordFailure("2001:db8::1", "csrf_mismatch")
+	clock.Advance(time.Minute)
+	if _, banned := bans.Banned("2001:db8::1"); banned {
+		t.Fatal("expected ban to expire")
+	}
+	if list := bans.List(); len(list) != 0 {
+		t.Fatalf("expected no active bans, got %+v", list)
+	}
+
+	var nilList *IPBanList
+	if _, banned := nilList.RecordFailure("2001:db8::1", "x"); banned {
+		t.Fatal("a nil list must not ban")
+	}
+}
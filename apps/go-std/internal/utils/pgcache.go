@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go-std/internal/sqlc"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const pgCacheTimeout = time.Second * 2
+
+// sweeper periodically deletes expired rows until it is closed
+type sweeper struct {
+	stop chan struct{}
+	once sync.Once
+	done sync.WaitGroup
+}
+
+func startSweeper(name string, interval time.Duration, sweep func(ctx context.Context, now pgtype.Timestamp) (int64, error)) *sweeper {
+	s := &sweeper{stop: make(chan struct{})}
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case now := <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				deleted, err := sweep(ctx, pgtype.Timestamp{Time: now.UTC(), Valid: true})
+				cancel()
+				if err != nil {
+					logger.Error("error sweeping %s: %v", name, err)
+				} else if deleted > 0 {
+					logger.Debug("swept %d expired %s rows", deleted, name)
+				}
+			}
+		}
+	}()
+	return s
+}
+
+// Close stops the sweeper and waits for a running sweep to finish
+func (s *sweeper) Close() error {
+	s.once.Do(func() {
+		close(s.stop)
+		s.done.Wait()
+	})
+	return nil
+}
+
+// PostgresCache implements Cache on the cache_entry table so every node sees
+// the same entries. Values are stored as JSON, so Get returns them decoded
+// into generic JSON types (string, float64, map[string]interface{}, ...).
+type PostgresCache struct {
+	*sweeper
+	q   *sqlc.Queries
+	ttl time.Duration
+}
+
+// NewPostgresCache creates a cache whose entries expire ttl after they were
+// put. Expired rows are deleted every sweepInterval until Close is called.
+func NewPostgresCache(q *sqlc.Queries, ttl time.Duration, sweepInterval time.Duration) *PostgresCache {
+	return &PostgresCache{
+		sweeper: startSweeper("cache_entry", sweepInterval, q.DeleteExpiredCacheEntries),
+		q:       q,
+		ttl:     ttl,
+	}
+}
+
+func (c *PostgresCache) Put(key string, value interface{}) {
+	c.PutWithTTL(key, value, c.ttl)
+}
+
+// PutWithTTL is Put with an expiry other than the cache's default
+func (c *PostgresCache) PutWithTTL(key string, value interface{}, ttl time.Duration) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		logger.Error("error encoding cache entry %s: %v", key, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pgCacheTimeout)
+	defer cancel()
+	err = c.q.PutCacheEntry(ctx, sqlc.PutCacheEntryParams{
+		Key:       key,
+		Value:     data,
+		ExpiresAt: pgtype.Timestamp{Time: time.Now().UTC().Add(ttl), Valid: true},
+	})
+	if err != nil {
+		logger.Error("error putting cache entry %s: %v", key, err)
+	}
+}
+
+func (c *PostgresCache) Get(key string) (interface{}, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgCacheTimeout)
+	defer cancel()
+	data, err := c.q.GetCacheEntry(ctx, sqlc.GetCacheEntryParams{
+		Key: key,
+		Now: pgtype.Timestamp{Time: time.Now().UTC(), Valid: true},
+	})
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			logger.Error("error getting cache entry %s: %v", key, err)
+		}
+		return nil, false
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		logger.Error("error decoding cache entry %s: %v", key, err)
+		return nil, false
+	}
+	return value, true
+}
+
+func (c *PostgresCache) Delete(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), pgCacheTimeout)
+	defer cancel()
+	if err := c.q.DeleteCacheEntry(ctx, key); err != nil {
+		logger.Error("error deleting cache entry %s: %v", key, err)
+	}
+}
+
+// PostgresRateLimitStore implements RateLimitStore on the rate_limit table so
+// limits hold across all nodes. Each update locks the key's row in a
+// transaction, so concurrent updates from any node are applied one at a time.
+type PostgresRateLimitStore struct {
+	*sweeper
+	db *pgxpool.Pool
+	q  *sqlc.Queries
+}
+
+// NewPostgresRateLimitStore creates the store. Expired rows are deleted every
+// sweepInterval until Close is called.
+func NewPostgresRateLimitStore(db *pgxpool.Pool, q *sqlc.Queries, sweepInterval time.Duration) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{
+		sweeper: startSweeper("rate_limit", sweepInterval, q.DeleteExpiredRateLimits),
+		db:      db,
+		q:       q,
+	}
+}
+
+func (s *PostgresRateLimitStore) Update(key string, ttl time.Duration, fn func(current []byte) ([]byte, error)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pgCacheTimeout)
+	defer cancel()
+
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		q := s.q.WithTx(tx)
+		now := time.Now().UTC()
+		row, err := q.LockRateLimit(ctx, sqlc.LockRateLimitParams{
+			Key: key,
+			Now: pgtype.Timestamp{Time: now, Valid: true},
+		})
+		if err != nil {
+			return fmt.Errorf("error reading rate limit %s: %w", key, err)
+		}
+
+		// Expired rows and fresh placeholders start a new state
+		var current []byte
+		if row.ExpiresAt.Time.After(now) {
+			current = row.Value
+		}
+		next, err := fn(current)
+		if err != nil {
+			return err
+		}
+
+		err = q.SetRateLimit(ctx, sqlc.SetRateLimitParams{
+			Value:     next,
+			ExpiresAt: pgtype.Timestamp{Time: now.Add(ttl), Valid: true},
+			Key:       key,
+		})
+		if err != nil {
+			return fmt.Errorf("error writing rate limit %s: %w", key, err)
+		}
+		return nil
+	})
+}
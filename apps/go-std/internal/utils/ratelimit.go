@@ -1,108 +1,400 @@
 package utils
 
 import (
+	"encoding/json"
 	"math"
+	"sync"
 	"time"
 )
 
+// RateLimitResult is the outcome of a rate limit check
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// ResetAfter is how long until the full quota is available again
+	ResetAfter time.Duration
+	// RetryAfter is how long until the next request would be allowed, zero when allowed
+	RetryAfter time.Duration
+}
+
+// RateLimiter counts requests per key
+type RateLimiter interface {
+	Allow(key string) (RateLimitResult, error)
+}
+
+// RateLimitStore holds the state of rate limiters. Update must run fn and store
+// its result as one atomic step: concurrent updates of the same key must not
+// interleave, although fn may be called more than once if the store retries.
+// current is nil when the key does not exist. The value expires after ttl.
+type RateLimitStore interface {
+	Update(key string, ttl time.Duration, fn func(current []byte) ([]byte, error)) error
+}
+
+// MemoryRateLimitStore keeps limiter state in a sharded TTLMap. Suitable for a single node only.
+type MemoryRateLimitStore struct {
+	m *TTLMap[string, []byte]
+}
+
+// NewMemoryRateLimitStore creates a store holding at most maxKeys keys. maxTTL
+// is the expiry of keys written without a ttl. Close the store to stop its
+// janitor.
+func NewMemoryRateLimitStore(name string, maxKeys int, maxTTL time.Duration) *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{m: NewShardedTTLMap[string, []byte](16, maxKeys, maxTTL, name)}
+}
+
+// Update runs fn under the lock of the key's shard
+func (s *MemoryRateLimitStore) Update(key string, ttl time.Duration, fn func(current []byte) ([]byte, error)) error {
+	if ttl <= 0 {
+		ttl = s.m.ttl
+	}
+	var err error
+	s.m.UpdateWithTTL(key, ttl, func(current []byte, ok bool) []byte {
+		next, fnErr := fn(current)
+		if fnErr != nil {
+			err = fnErr
+			return current
+		}
+		return next
+	})
+	return err
+}
+
+// Close stops the store's janitor
+func (s *MemoryRateLimitStore) Close() error {
+	return s.m.Close()
+}
+
+// rateLimiterConfig holds the settings shared by all limiters
+type rateLimiterConfig struct {
+	store RateLimitStore
+	now   func() time.Time
+}
+
+// RateLimiterOption configures a rate limiter
+type RateLimiterOption func(*rateLimiterConfig)
+
+// WithRateLimitStore stores limiter state in store instead of process memory
+func WithRateLimitStore(store RateLimitStore) RateLimiterOption {
+	return func(c *rateLimiterConfig) {
+		c.store = store
+	}
+}
+
+// WithClock replaces time.Now, so tests can control time
+func WithClock(now func() time.Time) RateLimiterOption {
+	return func(c *rateLimiterConfig) {
+		c.now = now
+	}
+}
+
+var (
+	defaultRateLimitStore     *MemoryRateLimitStore
+	defaultRateLimitStoreOnce sync.Once
+)
+
+// sharedRateLimitStore returns the store of limiters created without one. It
+// is shared so that limiters nobody closes do not each leave a janitor behind;
+// their keys are already prefixed by limiter type and identifier.
+func sharedRateLimitStore() *MemoryRateLimitStore {
+	defaultRateLimitStoreOnce.Do(func() {
+		defaultRateLimitStore = NewMemoryRateLimitStore("rate_limiters", 100000, time.Hour)
+	})
+	return defaultRateLimitStore
+}
+
+func newRateLimiterConfig(opts []RateLimiterOption) rateLimiterConfig {
+	c := rateLimiterConfig{now: time.Now}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.store == nil {
+		c.store = sharedRateLimitStore()
+	}
+	return c
+}
+
+// updateState atomically loads the JSON state of key into state, runs fn and saves state
+func updateState[T any](store RateLimitStore, key string, ttl time.Duration, fn func(state *T, exists bool)) error {
+	return store.Update(key, ttl, func(current []byte) ([]byte, error) {
+		var state T
+		exists := false
+		if current != nil {
+			if err := json.Unmarshal(current, &state); err == nil {
+				exists = true
+			}
+		}
+		fn(&state, exists)
+		return json.Marshal(state)
+	})
+}
+
 type TokenBucketCache struct {
-	count      int
-	lastRefill int64
+	Tokens     float64 `json:"t"`
+	LastRefill int64   `json:"r"`
 }
 
+// TokenBucketRateLimiter allows bursts of up to bucketCapacity requests and
+// refills refillRate tokens per second
 type TokenBucketRateLimiter struct {
-	cache          Cache
+	rateLimiterConfig
 	cachePrefix    string
 	bucketCapacity int
 	refillRate     float64
+	ttl            time.Duration
 }
 
-func NewTokenBucketRateLimiter(identifier string, bucketCapacity int, refillRate float64) *TokenBucketRateLimiter {
-	storage := NewTTLMap(10000, time.Minute*15, "token_bucket_rate_limiter:"+identifier)
-	cachePrefix := "tb_rate_limit:" + identifier + ":"
+func NewTokenBucketRateLimiter(identifier string, bucketCapacity int, refillRate float64, opts ...RateLimiterOption) *TokenBucketRateLimiter {
+	ttl := time.Minute * 15
+	if refillRate > 0 {
+		ttl = time.Duration(float64(bucketCapacity)/refillRate*float64(time.Second)) + time.Minute
+	}
 
 	return &TokenBucketRateLimiter{
-		cache:          storage,
-		cachePrefix:    cachePrefix,
-		bucketCapacity: bucketCapacity,
-		refillRate:     refillRate,
+		rateLimiterConfig: newRateLimiterConfig(opts),
+		cachePrefix:       "tb_rate_limit:" + identifier + ":",
+		bucketCapacity:    bucketCapacity,
+		refillRate:        refillRate,
+		ttl:               ttl,
 	}
-
 }
 
 func (tb *TokenBucketRateLimiter) IsAllowed(key string) bool {
-	cacheKey := tb.cachePrefix + key
-	var currentTime int64 = time.Now().UnixMilli()
-	//current state
-	results, ok := tb.cache.Get(cacheKey)
-	if !ok {
-		results = &TokenBucketCache{
-			count:      tb.bucketCapacity,
-			lastRefill: currentTime,
+	result, err := tb.Allow(key)
+	return err == nil && result.Allowed
+}
+
+// Allow takes a token for key if one is available
+func (tb *TokenBucketRateLimiter) Allow(key string) (RateLimitResult, error) {
+	var result RateLimitResult
+
+	err := updateState(tb.store, tb.cachePrefix+key, tb.ttl, func(bucket *TokenBucketCache, exists bool) {
+		currentTime := tb.now().UnixMilli()
+		if !exists {
+			bucket.Tokens = float64(tb.bucketCapacity)
+			bucket.LastRefill = currentTime
 		}
-	}
-	bucket := results.(*TokenBucketCache)
-	tokenCount, lastRefillTime := bucket.count, bucket.lastRefill
 
-	elapsedTimeMs := currentTime - lastRefillTime
-	elapsedTimeSeconds := float64(elapsedTimeMs) / 1000.0
-	tokensToAdd := int(tb.refillRate * elapsedTimeSeconds)
-	tokenCount = int(math.Min(float64(tb.bucketCapacity), float64(tokenCount+tokensToAdd)))
+		elapsedSeconds := float64(max(currentTime-bucket.LastRefill, 0)) / 1000.0
+		bucket.Tokens = math.Min(float64(tb.bucketCapacity), bucket.Tokens+tb.refillRate*elapsedSeconds)
+		bucket.LastRefill = currentTime
 
-	isAllowed := tokenCount > 0
-	if isAllowed {
-		tokenCount--
-	}
+		result = RateLimitResult{Limit: tb.bucketCapacity}
+		if bucket.Tokens >= 1 {
+			bucket.Tokens--
+			result.Allowed = true
+		}
+		result.Remaining = int(bucket.Tokens)
 
-	tb.cache.Put(cacheKey, &TokenBucketCache{
-		count:      tokenCount,
-		lastRefill: currentTime,
+		if tb.refillRate > 0 {
+			result.ResetAfter = secondsDuration((float64(tb.bucketCapacity) - bucket.Tokens) / tb.refillRate)
+			if !result.Allowed {
+				result.RetryAfter = secondsDuration((1 - bucket.Tokens) / tb.refillRate)
+			}
+		}
 	})
-
-	return isAllowed
+	return result, err
 }
 
 type FixedWindowCache struct {
-	count int
+	Count       int   `json:"c"`
+	WindowStart int64 `json:"w"`
 }
 
+// FixedWindowRateLimiter allows limit requests per aligned window of windowSize
 type FixedWindowRateLimiter struct {
-	cache       Cache
+	rateLimiterConfig
 	cachePrefix string
 	limit       int
 	windowSize  time.Duration
 }
 
-func NewFixedWindowRateLimiter(identifier string, limit int, windowSize time.Duration) *FixedWindowRateLimiter {
-	storage := NewTTLMap(10000, windowSize, "fixed_window_rate_limiter:"+identifier)
-	cachePrefix := "fw_rate_limit:" + identifier + ":"
-
+func NewFixedWindowRateLimiter(identifier string, limit int, windowSize time.Duration, opts ...RateLimiterOption) *FixedWindowRateLimiter {
 	return &FixedWindowRateLimiter{
-		cache:       storage,
-		cachePrefix: cachePrefix,
-		limit:       limit,
-		windowSize:  windowSize,
+		rateLimiterConfig: newRateLimiterConfig(opts),
+		cachePrefix:       "fw_rate_limit:" + identifier + ":",
+		limit:             limit,
+		windowSize:        windowSize,
 	}
 }
+
 func (fw *FixedWindowRateLimiter) IsAllowed(key string) bool {
-	cacheKey := fw.cachePrefix + key
-	results, ok := fw.cache.Get(cacheKey)
-	if !ok {
-		results = &FixedWindowCache{
-			count: 0,
+	result, err := fw.Allow(key)
+	return err == nil && result.Allowed
+}
+
+// Allow counts a request for key against the current window
+func (fw *FixedWindowRateLimiter) Allow(key string) (RateLimitResult, error) {
+	var result RateLimitResult
+
+	err := updateState(fw.store, fw.cachePrefix+key, fw.windowSize, func(bucket *FixedWindowCache, exists bool) {
+		now := fw.now()
+		windowStart := now.Truncate(fw.windowSize).UnixMilli()
+		if !exists || bucket.WindowStart != windowStart {
+			bucket.Count = 0
+			bucket.WindowStart = windowStart
+		}
+
+		result = RateLimitResult{Limit: fw.limit}
+		if bucket.Count < fw.limit {
+			bucket.Count++
+			result.Allowed = true
+		}
+		result.Remaining = fw.limit - bucket.Count
+		result.ResetAfter = time.UnixMilli(windowStart).Add(fw.windowSize).Sub(now)
+		if !result.Allowed {
+			result.RetryAfter = result.ResetAfter
+		}
+	})
+	return result, err
+}
+
+type SlidingWindowCache struct {
+	WindowStart int64 `json:"w"`
+	Count       int   `json:"c"`
+	Previous    int   `json:"p"`
+}
+
+// SlidingWindowRateLimiter approximates a sliding window by weighting the
+// count of the previous window by how much of it still overlaps the sliding
+// window. It avoids the burst of up to twice the limit a fixed window allows
+// at window boundaries, using constant memory per key.
+type SlidingWindowRateLimiter struct {
+	rateLimiterConfig
+	cachePrefix string
+	limit       int
+	windowSize  time.Duration
+}
+
+func NewSlidingWindowRateLimiter(identifier string, limit int, windowSize time.Duration, opts ...RateLimiterOption) *SlidingWindowRateLimiter {
+	return &SlidingWindowRateLimiter{
+		rateLimiterConfig: newRateLimiterConfig(opts),
+		cachePrefix:       "sw_rate_limit:" + identifier + ":",
+		limit:             limit,
+		windowSize:        windowSize,
+	}
+}
+
+// Allow counts a request for key if the weighted count is below the limit
+func (sw *SlidingWindowRateLimiter) Allow(key string) (RateLimitResult, error) {
+	var result RateLimitResult
+
+	err := updateState(sw.store, sw.cachePrefix+key, sw.windowSize*2, func(bucket *SlidingWindowCache, exists bool) {
+		now := sw.now()
+		window := sw.windowSize.Milliseconds()
+		windowStart := now.Truncate(sw.windowSize).UnixMilli()
+
+		switch {
+		case exists && bucket.WindowStart == windowStart:
+		case exists && bucket.WindowStart == windowStart-window:
+			bucket.Previous, bucket.Count = bucket.Count, 0
+		default:
+			bucket.Previous, bucket.Count = 0, 0
+		}
+		bucket.WindowStart = windowStart
+
+		elapsed := now.UnixMilli() - windowStart
+		overlap := 1 - float64(elapsed)/float64(window)
+		weighted := float64(bucket.Previous)*overlap + float64(bucket.Count)
+
+		result = RateLimitResult{Limit: sw.limit}
+		if weighted+1 <= float64(sw.limit) {
+			bucket.Count++
+			weighted++
+			result.Allowed = true
+		}
+		result.Remaining = max(int(math.Floor(float64(sw.limit)-weighted)), 0)
+
+		// Quota is fully restored once neither window counts any more
+		switch {
+		case bucket.Count > 0:
+			result.ResetAfter = time.Duration(2*window-elapsed) * time.Millisecond
+		case bucket.Previous > 0:
+			result.ResetAfter = time.Duration(window-elapsed) * time.Millisecond
+		}
+
+		if !result.Allowed {
+			result.RetryAfter = sw.retryAfter(bucket, elapsed)
+		}
+	})
+	return result, err
+}
+
+// retryAfter returns how long until the weighted count leaves room for one request
+func (sw *SlidingWindowRateLimiter) retryAfter(bucket *SlidingWindowCache, elapsed int64) time.Duration {
+	window := float64(sw.windowSize.Milliseconds())
+	limit := float64(sw.limit)
+
+	// Still within the current window, while the previous window's weight decays
+	if free := limit - 1 - float64(bucket.Count); free >= 0 && bucket.Previous > 0 {
+		at := window * (1 - free/float64(bucket.Previous))
+		if at < window {
+			return time.Duration(math.Ceil(at-float64(elapsed))) * time.Millisecond
 		}
 	}
-	bucket := results.(*FixedWindowCache)
-	count := bucket.count
-
-	isAllowed := count < fw.limit
-	if isAllowed {
-		count++
-		fw.cache.Put(cacheKey, &FixedWindowCache{
-			count: count,
-		})
+
+	// In the next window the current count becomes the previous one
+	at := window
+	if bucket.Count > 0 {
+		at += window * math.Max(0, 1-(limit-1)/float64(bucket.Count))
+	}
+	return time.Duration(math.Ceil(at-float64(elapsed))) * time.Millisecond
+}
+
+type GCRACache struct {
+	// TAT is the theoretical arrival time in unix nanoseconds
+	TAT int64 `json:"t"`
+}
+
+// GCRARateLimiter implements the generic cell rate algorithm: requests are
+// spaced evenly at window/limit and up to limit requests may arrive at once.
+// It stores a single timestamp per key.
+type GCRARateLimiter struct {
+	rateLimiterConfig
+	cachePrefix string
+	limit       int
+	interval    time.Duration
+}
+
+func NewGCRARateLimiter(identifier string, limit int, windowSize time.Duration, opts ...RateLimiterOption) *GCRARateLimiter {
+	return &GCRARateLimiter{
+		rateLimiterConfig: newRateLimiterConfig(opts),
+		cachePrefix:       "gcra_rate_limit:" + identifier + ":",
+		limit:             limit,
+		interval:          windowSize / time.Duration(limit),
 	}
+}
+
+// Allow admits a request for key if it does not arrive too early
+func (g *GCRARateLimiter) Allow(key string) (RateLimitResult, error) {
+	var result RateLimitResult
+	burst := g.interval * time.Duration(g.limit)
 
-	return isAllowed
+	err := updateState(g.store, g.cachePrefix+key, burst, func(cell *GCRACache, exists bool) {
+		now := g.now()
+		tat := time.Unix(0, cell.TAT)
+		if !exists || tat.Before(now) {
+			tat = now
+		}
+
+		newTAT := tat.Add(g.interval)
+		allowAt := newTAT.Add(-burst)
+
+		result = RateLimitResult{Limit: g.limit}
+		if now.Before(allowAt) {
+			result.RetryAfter = allowAt.Sub(now)
+		} else {
+			tat = newTAT
+			result.Allowed = true
+		}
+		cell.TAT = tat.UnixNano()
+
+		result.Remaining = max(int(now.Sub(tat.Add(-burst))/g.interval), 0)
+		result.ResetAfter = tat.Sub(now)
+	})
+	return result, err
+}
 
+func secondsDuration(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
 }
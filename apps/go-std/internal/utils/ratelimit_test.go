@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// testStore gives a limiter its own store, instead of the shared one whose
+// state would leak between tests and repeated runs
+func testStore(t *testing.T) RateLimiterOption {
+	store := NewMemoryRateLimitStore("test_rate_limits", 1000, time.Hour)
+	t.Cleanup(func() { store.Close() })
+	return WithRateLimitStore(store)
+}
+
+func allow(t *testing.T, l RateLimiter, key string) RateLimitResult {
+	t.Helper()
+	result, err := l.Allow(key)
+	if err != nil {
+		t.Fatalf("Allow(%q): %v", key, err)
+	}
+	return result
+}
+
+func TestFixedWindowResetsOnTime(t *testing.T) {
+	clock := newFakeClock()
+	fw := NewFixedWindowRateLimiter("test", 2, time.Minute, WithClock(clock.Now), testStore(t))
+
+	for i := 0; i < 2; i++ {
+		if r := allow(t, fw, "k"); !r.Allowed || r.Remaining != 1-i {
+			t.Fatalf("request %d: got %+v", i, r)
+		}
+	}
+	r := allow(t, fw, "k")
+	if r.Allowed || r.RetryAfter != time.Minute {
+		t.Fatalf("expected denial with retry after 1m, got %+v", r)
+	}
+
+	clock.Advance(time.Minute)
+	if r := allow(t, fw, "k"); !r.Allowed {
+		t.Fatalf("expected new window to allow, got %+v", r)
+	}
+}
+
+func TestSlidingWindowWeightsPreviousWindow(t *testing.T) {
+	clock := newFakeClock()
+	sw := NewSlidingWindowRateLimiter("test", 10, time.Minute, WithClock(clock.Now), testStore(t))
+
+	for i := 0; i < 10; i++ {
+		if !allow(t, sw, "k").Allowed {
+			t.Fatalf("request %d denied", i)
+		}
+	}
+	if allow(t, sw, "k").Allowed {
+		t.Fatal("expected 11th request to be denied")
+	}
+
+	// Halfway into the next window half of the previous count still applies
+	clock.Advance(time.Minute + time.Second*30)
+	for i := 0; i < 5; i++ {
+		if !allow(t, sw, "k").Allowed {
+			t.Fatalf("request %d in next window denied", i)
+		}
+	}
+	r := allow(t, sw, "k")
+	if r.Allowed {
+		t.Fatal("expected weighted limit to deny")
+	}
+	if r.RetryAfter <= 0 || r.RetryAfter > time.Minute {
+		t.Fatalf("unexpected retry after %v", r.RetryAfter)
+	}
+
+	clock.Advance(r.RetryAfter)
+	if !allow(t, sw, "k").Allowed {
+		t.Fatal("expected request to be allowed after RetryAfter")
+	}
+}
+
+func TestGCRASpacesRequests(t *testing.T) {
+	clock := newFakeClock()
+	g := NewGCRARateLimiter("test", 5, time.Second*5, WithClock(clock.Now), testStore(t))
+
+	for i := 0; i < 5; i++ {
+		r := allow(t, g, "k")
+		if !r.Allowed || r.Remaining != 4-i {
+			t.Fatalf("burst request %d: got %+v", i, r)
+		}
+	}
+	r := allow(t, g, "k")
+	if r.Allowed || r.RetryAfter != time.Second {
+		t.Fatalf("expected denial with retry after 1s, got %+v", r)
+	}
+	if r.ResetAfter != time.Second*5 {
+		t.Fatalf("expected reset after 5s, got %v", r.ResetAfter)
+	}
+
+	clock.Advance(time.Second)
+	if !allow(t, g, "k").Allowed {
+		t.Fatal("expected one request after one interval")
+	}
+	if allow(t, g, "k").Allowed {
+		t.Fatal("expected only one request after one interval")
+	}
+}
+
+func TestTokenBucketRefills(t *testing.T) {
+	clock := newFakeClock()
+	tb := NewTokenBucketRateLimiter("test", 2, 1, WithClock(clock.Now), testStore(t))
+
+	allow(t, tb, "k")
+	allow(t, tb, "k")
+	if allow(t, tb, "k").Allowed {
+		t.Fatal("expected empty bucket to deny")
+	}
+
+	// Partial refills accumulate instead of being dropped
+	clock.Advance(time.Millisecond * 500)
+	if allow(t, tb, "k").Allowed {
+		t.Fatal("expected half a token to deny")
+	}
+	clock.Advance(time.Millisecond * 500)
+	if !allow(t, tb, "k").Allowed {
+		t.Fatal("expected refilled token to allow")
+	}
+}
+
+func TestLimitersDoNotOverAdmitConcurrently(t *testing.T) {
+	clock := newFakeClock()
+	limiters := map[string]RateLimiter{
+		"fixed":   NewFixedWindowRateLimiter("race", 50, time.Minute, WithClock(clock.Now), testStore(t)),
+		"sliding": NewSlidingWindowRateLimiter("race", 50, time.Minute, WithClock(clock.Now), testStore(t)),
+		"gcra":    NewGCRARateLimiter("race", 50, time.Minute, WithClock(clock.Now), testStore(t)),
+		"bucket":  NewTokenBucketRateLimiter("race", 50, 0.001, WithClock(clock.Now), testStore(t)),
+	}
+
+	for name, l := range limiters {
+		t.Run(name, func(t *testing.T) {
+			var allowed atomic.Int64
+			var wg sync.WaitGroup
+			for i := 0; i < 200; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if r, err := l.Allow("k"); err == nil && r.Allowed {
+						allowed.Add(1)
+					}
+				}()
+			}
+			wg.Wait()
+			if got := allowed.Load(); got != 50 {
+				t.Fatalf("allowed %d requests, want 50", got)
+			}
+		})
+	}
+}
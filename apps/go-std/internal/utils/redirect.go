@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ErrUnsafeRedirect is returned when a redirect target is not allowed
+var ErrUnsafeRedirect = errors.New("unsafe redirect target")
+
+// RedirectTarget is an external origin (e.g. a frontend) that may be redirected to
+type RedirectTarget struct {
+	Name   string
+	Origin string
+	// Paths are the allowed path prefixes on the origin. Empty allows every path.
+	Paths []string
+}
+
+// RedirectPolicy validates redirect targets against an allowlist of origins and paths.
+// Relative paths on the current host are always allowed.
+type RedirectPolicy struct {
+	targets map[string]RedirectTarget
+}
+
+// NewRedirectPolicy creates a redirect policy from the given targets
+func NewRedirectPolicy(targets ...RedirectTarget) (*RedirectPolicy, error) {
+	p := &RedirectPolicy{targets: make(map[string]RedirectTarget)}
+	for _, t := range targets {
+		u, err := url.Parse(t.Origin)
+		if err != nil {
+			return nil, fmt.Errorf("redirect target %s: invalid origin: %w", t.Name, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return nil, fmt.Errorf("redirect target %s: origin must be scheme://host[:port], got %q", t.Name, t.Origin)
+		}
+		origin := strings.ToLower(u.Scheme + "://" + u.Host)
+		t.Origin = origin
+		paths := make([]string, len(t.Paths))
+		for i, prefix := range t.Paths {
+			paths[i] = cleanRedirectPath(prefix)
+		}
+		t.Paths = paths
+		p.targets[origin] = t
+	}
+	return p, nil
+}
+
+// Normalize validates a redirect target and returns its canonical form. Relative
+// targets are returned as a cleaned absolute path; absolute targets must match
+// an allowed origin and path prefix.
+func (p *RedirectPolicy) Normalize(target string) (string, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return "", fmt.Errorf("%w: empty target", ErrUnsafeRedirect)
+	}
+	if strings.ContainsAny(target, "\\") || strings.IndexFunc(target, isControlRune) >= 0 {
+		return "", fmt.Errorf("%w: target contains illegal characters", ErrUnsafeRedirect)
+	}
+	if strings.HasPrefix(target, "//") {
+		return "", fmt.Errorf("%w: scheme-relative URLs are not allowed", ErrUnsafeRedirect)
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsafeRedirect, err)
+	}
+
+	if u.Scheme == "" && u.Host == "" {
+		if !strings.HasPrefix(u.Path, "/") {
+			return "", fmt.Errorf("%w: relative targets must start with /", ErrUnsafeRedirect)
+		}
+		normalized := &url.URL{Path: cleanRedirectPath(u.Path), RawQuery: u.RawQuery, Fragment: u.Fragment}
+		return normalized.String(), nil
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return "", fmt.Errorf("%w: scheme %q is not allowed", ErrUnsafeRedirect, u.Scheme)
+	}
+	if u.User != nil {
+		return "", fmt.Errorf("%w: credentials in URL are not allowed", ErrUnsafeRedirect)
+	}
+
+	origin := scheme + "://" + strings.ToLower(u.Host)
+	allowed, ok := p.targets[origin]
+	if !ok {
+		return "", fmt.Errorf("%w: origin %s is not allowed", ErrUnsafeRedirect, origin)
+	}
+
+	cleanPath := cleanRedirectPath(u.Path)
+	if !allowed.allowsPath(cleanPath) {
+		return "", fmt.Errorf("%w: path %s is not allowed for %s", ErrUnsafeRedirect, cleanPath, allowed.Name)
+	}
+
+	normalized := &url.URL{
+		Scheme:   scheme,
+		Host:     strings.ToLower(u.Host),
+		Path:     cleanPath,
+		RawQuery: u.RawQuery,
+		Fragment: u.Fragment,
+	}
+	return normalized.String(), nil
+}
+
+// Redirect validates the target and redirects to it, or responds with a 400
+// when the target is not allowed.
+func (p *RedirectPolicy) Redirect(w http.ResponseWriter, r *http.Request, target string) {
+	normalized, err := p.Normalize(target)
+	if err != nil {
+		ErrorResponse(w, http.StatusBadRequest, err.Error(), "INVALID_REDIRECT")
+		return
+	}
+	if strings.HasPrefix(normalized, "/") {
+		Redirect(w, r, normalized)
+		return
+	}
+	http.Redirect(w, r, normalized, http.StatusTemporaryRedirect)
+}
+
+func (t RedirectTarget) allowsPath(p string) bool {
+	if len(t.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range t.Paths {
+		if prefix == "/" || p == prefix || strings.HasPrefix(p, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanRedirectPath resolves dot segments while keeping a trailing slash
+func cleanRedirectPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func isControlRune(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	redisTimeout         = time.Second * 2
+	redisSwapMaxAttempts = 10
+)
+
+// ErrRateLimitContention is returned when a rate limit update keeps losing
+// races against other nodes updating the same key
+var ErrRateLimitContention = errors.New("rate limit update contention")
+
+// redisSwapScript sets KEYS[1] to ARGV[3] with a TTL of ARGV[4] milliseconds
+// only if it still holds ARGV[2] (or is missing when ARGV[1] is "0"), so a
+// read-modify-write of limiter state is atomic on the server.
+const redisSwapScript = `local current = redis.call('GET', KEYS[1])
+if ARGV[1] == '0' then
+  if current then return 0 end
+elseif current ~= ARGV[2] then
+  return 0
+end
+redis.call('SET', KEYS[1], ARGV[3], 'PX', ARGV[4])
+return 1`
+
+var redisSwapScriptSHA = func() string {
+	sum := sha1.Sum([]byte(redisSwapScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// RedisCache implements Cache on a Redis or Valkey server so every node sees
+// the same entries. Values are stored as JSON, so Get returns them decoded
+// into generic JSON types (string, float64, map[string]interface{}, ...).
+type RedisCache struct {
+	c      *RESPClient
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisCache creates a cache whose keys are prefixed with prefix and expire
+// ttl after they were put
+func NewRedisCache(c *RESPClient, prefix string, ttl time.Duration) *RedisCache {
+	return &RedisCache{c: c, prefix: prefix, ttl: ttl}
+}
+
+func (c *RedisCache) Put(key string, value interface{}) {
+	c.PutWithTTL(key, value, c.ttl)
+}
+
+// PutWithTTL is Put with an expiry other than the cache's default
+func (c *RedisCache) PutWithTTL(key string, value interface{}, ttl time.Duration) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		logger.Error("error encoding cache entry %s: %v", key, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	_, err = c.c.Do(ctx, "SET", c.prefix+key, string(data), "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	if err != nil {
+		logger.Error("error putting cache entry %s: %v", key, err)
+	}
+}
+
+func (c *RedisCache) Get(key string) (interface{}, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	reply, err := c.c.Do(ctx, "GET", c.prefix+key)
+	if err != nil {
+		logger.Error("error getting cache entry %s: %v", key, err)
+		return nil, false
+	}
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, false
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		logger.Error("error decoding cache entry %s: %v", key, err)
+		return nil, false
+	}
+	return value, true
+}
+
+func (c *RedisCache) Delete(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if _, err := c.c.Do(ctx, "DEL", c.prefix+key); err != nil {
+		logger.Error("error deleting cache entry %s: %v", key, err)
+	}
+}
+
+// Close closes the client's connections
+func (c *RedisCache) Close() error {
+	return c.c.Close()
+}
+
+// RedisRateLimitStore implements RateLimitStore on a Redis or Valkey server so
+// limits hold across all nodes. The state is read with GET and written back by
+// a Lua script only if it is unchanged, otherwise the update is retried and
+// ErrRateLimitContention returned once the retries run out, which the
+// RateLimit middleware treats as a denial. Every write sets the key's TTL, so
+// idle limiters expire on the server.
+type RedisRateLimitStore struct {
+	c      *RESPClient
+	prefix string
+}
+
+// NewRedisRateLimitStore creates a store whose keys are prefixed with prefix
+func NewRedisRateLimitStore(c *RESPClient, prefix string) *RedisRateLimitStore {
+	return &RedisRateLimitStore{c: c, prefix: prefix}
+}
+
+func (s *RedisRateLimitStore) Update(key string, ttl time.Duration, fn func(current []byte) ([]byte, error)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	key = s.prefix + key
+	ttlMillis := strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)
+
+	for attempt := 0; attempt < redisSwapMaxAttempts; attempt++ {
+		reply, err := s.c.Do(ctx, "GET", key)
+		if err != nil {
+			return fmt.Errorf("error reading rate limit %s: %w", key, err)
+		}
+		current, _ := reply.([]byte)
+		exists := "0"
+		if current != nil {
+			exists = "1"
+		}
+
+		next, err := fn(current)
+		if err != nil {
+			return err
+		}
+
+		swapped, err := s.swap(ctx, key, exists, string(current), string(next), ttlMillis)
+		if err != nil {
+			return fmt.Errorf("error writing rate limit %s: %w", key, err)
+		}
+		if swapped {
+			return nil
+		}
+	}
+	return ErrRateLimitContention
+}
+
+// swap runs the cached script and loads it with EVAL when the server does not
+// know it yet
+func (s *RedisRateLimitStore) swap(ctx context.Context, key string, args ...string) (bool, error) {
+	reply, err := s.c.Do(ctx, append([]string{"EVALSHA", redisSwapScriptSHA, "1", key}, args...)...)
+	var respErr RESPError
+	if errors.As(err, &respErr) && strings.HasPrefix(string(respErr), "NOSCRIPT") {
+		reply, err = s.c.Do(ctx, append([]string{"EVAL", redisSwapScript, "1", key}, args...)...)
+	}
+	if err != nil {
+		return false, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return false, fmt.Errorf("unexpected script reply %v", reply)
+	}
+	return n == 1, nil
+}
+
+// Close closes the client's connections
+func (s *RedisRateLimitStore) Close() error {
+	return s.c.Close()
+}
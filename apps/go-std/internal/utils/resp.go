@@ -0,0 +1,306 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRESPClosed is returned by a RESPClient after Close
+var ErrRESPClosed = errors.New("resp client closed")
+
+// RESPError is an error reply from the server
+type RESPError string
+
+func (e RESPError) Error() string {
+	return string(e)
+}
+
+// RESPOptions configures a RESPClient
+type RESPOptions struct {
+	Addr     string
+	Username string
+	Password string
+	DB       int
+	// PoolSize is the maximum number of open connections
+	PoolSize    int
+	DialTimeout time.Duration
+	// IOTimeout bounds each command when the context has no deadline
+	IOTimeout time.Duration
+}
+
+// ParseRESPURL parses redis://[user:password@]host:port[/db] into options
+func ParseRESPURL(raw string) (RESPOptions, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return RESPOptions{}, err
+	}
+	if u.Scheme != "redis" {
+		return RESPOptions{}, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	opts := RESPOptions{Addr: u.Host}
+	if u.Port() == "" {
+		opts.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		opts.Username = u.User.Username()
+		opts.Password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if opts.DB, err = strconv.Atoi(db); err != nil {
+			return RESPOptions{}, fmt.Errorf("invalid database %q", db)
+		}
+	}
+	return opts, nil
+}
+
+// RESPClient is a minimal client for servers speaking the Redis protocol
+// (Redis, Valkey, ...) with a bounded connection pool
+type RESPClient struct {
+	opts  RESPOptions
+	slots chan struct{}
+	idle  chan *respConn
+
+	mu     sync.Mutex
+	closed bool
+}
+
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func NewRESPClient(opts RESPOptions) *RESPClient {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 10
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = time.Second * 5
+	}
+	if opts.IOTimeout <= 0 {
+		opts.IOTimeout = time.Second * 3
+	}
+	return &RESPClient{
+		opts:  opts,
+		slots: make(chan struct{}, opts.PoolSize),
+		idle:  make(chan *respConn, opts.PoolSize),
+	}
+}
+
+// Do sends a command and returns its reply: string for simple strings, int64
+// for integers, []byte or nil for bulk strings, []interface{} for arrays and
+// RESPError for error replies.
+func (c *RESPClient) Do(ctx context.Context, args ...string) (interface{}, error) {
+	rc, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := rc.do(ctx, c.opts.IOTimeout, args)
+	var respErr RESPError
+	if err != nil && !errors.As(err, &respErr) {
+		// The connection state is unknown after an I/O error
+		c.discard(rc)
+		return nil, err
+	}
+	c.put(rc)
+	return reply, err
+}
+
+// Close closes idle connections. Connections in use are closed when returned.
+func (c *RESPClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	for {
+		select {
+		case rc := <-c.idle:
+			rc.conn.Close()
+			<-c.slots
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *RESPClient) get(ctx context.Context) (*respConn, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return nil, ErrRESPClosed
+	}
+
+	select {
+	case rc := <-c.idle:
+		return rc, nil
+	default:
+	}
+
+	select {
+	case rc := <-c.idle:
+		return rc, nil
+	case c.slots <- struct{}{}:
+		rc, err := c.dial(ctx)
+		if err != nil {
+			<-c.slots
+			return nil, err
+		}
+		return rc, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *RESPClient) put(rc *respConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		rc.conn.Close()
+		<-c.slots
+		return
+	}
+	c.idle <- rc
+}
+
+func (c *RESPClient) discard(rc *respConn) {
+	rc.conn.Close()
+	<-c.slots
+}
+
+func (c *RESPClient) dial(ctx context.Context) (*respConn, error) {
+	d := net.Dialer{Timeout: c.opts.DialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	rc := &respConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	var setup [][]string
+	if c.opts.Password != "" {
+		if c.opts.Username != "" {
+			setup = append(setup, []string{"AUTH", c.opts.Username, c.opts.Password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.opts.Password})
+		}
+	}
+	if c.opts.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.opts.DB)})
+	}
+	for _, args := range setup {
+		if _, err := rc.do(ctx, c.opts.IOTimeout, args); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: %w", args[0], err)
+		}
+	}
+	return rc, nil
+}
+
+func (rc *respConn) do(ctx context.Context, timeout time.Duration, args []string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := rc.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if err := writeRESPCommand(rc.w, args); err != nil {
+		return nil, err
+	}
+	if err := rc.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readRESPReply(rc.r)
+}
+
+func writeRESPCommand(w *bufio.Writer, args []string) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n", len(arg))
+		w.WriteString(arg)
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", fmt.Errorf("resp: malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func readRESPReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("resp: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RESPError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("resp: invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("resp: invalid array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := readRESPReply(r)
+			var respErr RESPError
+			if err != nil && !errors.As(err, &respErr) {
+				return nil, err
+			}
+			if err != nil {
+				items[i] = respErr
+			} else {
+				items[i] = item
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("resp: unknown reply type %q", line[0])
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
 )
 
 // Response represents a standardized API response
@@ -17,8 +18,9 @@ type Response struct {
 
 // Error represents an API error response
 type Error struct {
-	Message string `json:"message"`
-	Code    string `json:"code,omitempty"`
+	Message string      `json:"message"`
+	Code    string      `json:"code,omitempty"`
+	Details interface{} `json:"details,omitempty"`
 }
 
 // JSON writes a JSON response to the ResponseWriter
@@ -47,6 +49,18 @@ func ErrorResponse(w http.ResponseWriter, status int, message string, code strin
 	})
 }
 
+// ErrorDetailsResponse writes an error response with machine readable details
+func ErrorDetailsResponse(w http.ResponseWriter, status int, message string, code string, details interface{}) {
+	JSONResponse(w, status, Response{
+		Success: false,
+		Error: &Error{
+			Message: message,
+			Code:    code,
+			Details: details,
+		},
+	})
+}
+
 // Common error responses for expected errors
 func BadRequest(w http.ResponseWriter, message string) {
 	ErrorResponse(w, http.StatusBadRequest, message, "BAD_REQUEST")
@@ -81,10 +95,21 @@ func MethodNotAllowed(w http.ResponseWriter, message string) {
 	ErrorResponse(w, http.StatusMethodNotAllowed, message, "METHOD_NOT_ALLOWED")
 }
 
+// Redirect redirects to a path or URL on the current host. Targets on other
+// hosts must go through a RedirectPolicy.
 func Redirect(w http.ResponseWriter, r *http.Request, targetPath string) {
 	// Try to parse the targetPath as a URL
-	if parsedURL, err := url.Parse(targetPath); err == nil && parsedURL.Host != "" {
-		// If it's a valid URL with a host, redirect directly
+	parsedURL, err := url.Parse(targetPath)
+	if err != nil || strings.HasPrefix(targetPath, "//") || (parsedURL.Scheme != "" && parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		ErrorResponse(w, http.StatusBadRequest, "invalid redirect target", "INVALID_REDIRECT")
+		return
+	}
+	if parsedURL.Host != "" {
+		// Only follow absolute URLs that point back at this host
+		if !strings.EqualFold(parsedURL.Host, r.Host) {
+			ErrorResponse(w, http.StatusBadRequest, "redirect target host not allowed", "INVALID_REDIRECT")
+			return
+		}
 		http.Redirect(w, r, targetPath, http.StatusTemporaryRedirect)
 		return
 	}
@@ -102,7 +127,11 @@ func Redirect(w http.ResponseWriter, r *http.Request, targetPath string) {
 	}
 
 	// Join the base URL with the target path
-	targetURL := baseURL.ResolveReference(&url.URL{Path: path.Join("/", targetPath)})
+	targetURL := baseURL.ResolveReference(&url.URL{
+		Path:     path.Join("/", parsedURL.Path),
+		RawQuery: parsedURL.RawQuery,
+		Fragment: parsedURL.Fragment,
+	})
 
 	http.Redirect(w, r, targetURL.String(), http.StatusTemporaryRedirect)
 }
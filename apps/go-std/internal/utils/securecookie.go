@@ -0,0 +1,370 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidCookie is returned when a cookie fails verification or decryption
+	ErrInvalidCookie = errors.New("invalid cookie")
+	// ErrCookieExpired is returned when a cookie is older than its max age
+	ErrCookieExpired = errors.New("cookie expired")
+)
+
+const hostCookiePrefix = "__Host-"
+
+// CookieKey is a single secret in a KeyRing. The ID is embedded in every
+// encoded value so older keys can still be used to decode after a rotation.
+type CookieKey struct {
+	ID     string
+	Secret []byte
+}
+
+// KeyRing holds the cookie keys. The first key is used for encoding, all keys
+// are tried when decoding.
+type KeyRing struct {
+	keys []CookieKey
+}
+
+// NewKeyRing creates a key ring. The first key becomes the primary key.
+func NewKeyRing(keys ...CookieKey) (*KeyRing, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("key ring requires at least one key")
+	}
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if k.ID == "" || strings.ContainsAny(k.ID, ".:,") {
+			return nil, fmt.Errorf("invalid key id %q", k.ID)
+		}
+		if len(k.Secret) < 16 {
+			return nil, fmt.Errorf("key %s: secret must be at least 16 bytes", k.ID)
+		}
+		if seen[k.ID] {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		seen[k.ID] = true
+	}
+	return &KeyRing{keys: keys}, nil
+}
+
+// ErrNoKeys is returned by ParseKeyRing for an empty spec
+var ErrNoKeys = errors.New("no keys configured")
+
+var (
+	ephemeralKeyRing     *KeyRing
+	ephemeralKeyRingErr  error
+	ephemeralKeyRingOnce sync.Once
+)
+
+// EphemeralKeyRing returns a random key ring shared by the whole process. It
+// is only fit for development: cookies and tokens signed with it do not
+// survive restarts or validate on other nodes.
+func EphemeralKeyRing() (*KeyRing, error) {
+	ephemeralKeyRingOnce.Do(func() {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			ephemeralKeyRingErr = fmt.Errorf("failed to generate key: %w", err)
+			return
+		}
+		logger.Warn("no keys configured, using a random key")
+		ephemeralKeyRing = &KeyRing{keys: []CookieKey{{ID: "ephemeral", Secret: secret}}}
+	})
+	return ephemeralKeyRing, ephemeralKeyRingErr
+}
+
+// ParseKeyRing parses a key ring spec of the form "id1:secret1,id2:secret2".
+// An empty spec returns ErrNoKeys.
+func ParseKeyRing(spec string) (*KeyRing, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, ErrNoKeys
+	}
+
+	var keys []CookieKey
+	for _, part := range strings.Split(spec, ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid key %q, expected id:secret", part)
+		}
+		keys = append(keys, CookieKey{ID: id, Secret: []byte(secret)})
+	}
+	return NewKeyRing(keys...)
+}
+
+// Primary returns the key used for encoding
+func (k *KeyRing) Primary() CookieKey {
+	return k.keys[0]
+}
+
+// Lookup returns the key with the given ID
+func (k *KeyRing) Lookup(id string) (CookieKey, bool) {
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return CookieKey{}, false
+}
+
+// Sign returns an HMAC-SHA256 of data using a key derived from the secret
+func (k CookieKey) Sign(data []byte) []byte {
+	return k.mac("sign", data)
+}
+
+// mac returns an HMAC-SHA256 of data with the key derived for purpose, so a
+// value signed for one purpose is never valid for another
+func (k CookieKey) mac(purpose string, data []byte) []byte {
+	mac := hmac.New(sha256.New, k.derive(purpose))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func (k CookieKey) derive(purpose string) []byte {
+	mac := hmac.New(sha256.New, k.Secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// CookieMode selects how a CookieCodec protects values
+type CookieMode int
+
+const (
+	// CookieSigned values are readable by the client but tamper-proof
+	CookieSigned CookieMode = iota
+	// CookieEncrypted values are tamper-proof and opaque to the client
+	CookieEncrypted
+)
+
+// ParseCookieMode parses "signed" or "encrypted"
+func ParseCookieMode(s string) (CookieMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "signed":
+		return CookieSigned, nil
+	case "", "encrypted":
+		return CookieEncrypted, nil
+	default:
+		return 0, fmt.Errorf("unknown cookie mode %q", s)
+	}
+}
+
+// CookieCodec signs or encrypts cookie values together with the time they were issued.
+// Values are bound to the cookie name so they cannot be swapped between cookies.
+type CookieCodec struct {
+	keys *KeyRing
+	mode CookieMode
+	now  func() time.Time
+}
+
+func NewCookieCodec(keys *KeyRing, mode CookieMode) *CookieCodec {
+	return &CookieCodec{keys: keys, mode: mode, now: time.Now}
+}
+
+// Encode protects value for storage in the cookie called name
+func (c *CookieCodec) Encode(name, value string) (string, error) {
+	key := c.keys.Primary()
+	ts := c.now().Unix()
+
+	if c.mode == CookieSigned {
+		payload := "s1." + key.ID + "." + strconv.FormatInt(ts, 10) + "." + EncodeBase64UrlNoPadding([]byte(value))
+		mac := key.Sign([]byte(name + "|" + payload))
+		return payload + "." + EncodeBase64UrlNoPadding(mac), nil
+	}
+
+	aead, err := newCookieAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	plaintext := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(plaintext, uint64(ts))
+	plaintext = append(plaintext, value...)
+
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(name+"|"+key.ID))
+	return "e1." + key.ID + "." + EncodeBase64UrlNoPadding(sealed), nil
+}
+
+// Decode verifies an encoded value and returns the original. A maxAge of zero
+// disables the age check. Both signed and encrypted values are accepted, so the
+// codec mode can be changed without invalidating existing cookies.
+func (c *CookieCodec) Decode(name, encoded string, maxAge time.Duration) (string, error) {
+	parts := strings.Split(encoded, ".")
+	if len(parts) < 3 {
+		return "", ErrInvalidCookie
+	}
+	key, ok := c.keys.Lookup(parts[1])
+	if !ok {
+		return "", ErrInvalidCookie
+	}
+
+	var (
+		ts    int64
+		value string
+	)
+	switch {
+	case parts[0] == "s1" && len(parts) == 5:
+		payload := strings.Join(parts[:4], ".")
+		mac, err := DecodeBase64UrlNoPadding(parts[4])
+		if err != nil || !hmac.Equal(mac, key.Sign([]byte(name+"|"+payload))) {
+			return "", ErrInvalidCookie
+		}
+		ts, err = strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return "", ErrInvalidCookie
+		}
+		raw, err := DecodeBase64UrlNoPadding(parts[3])
+		if err != nil {
+			return "", ErrInvalidCookie
+		}
+		value = string(raw)
+
+	case parts[0] == "e1" && len(parts) == 3:
+		sealed, err := DecodeBase64UrlNoPadding(parts[2])
+		if err != nil {
+			return "", ErrInvalidCookie
+		}
+		aead, err := newCookieAEAD(key)
+		if err != nil {
+			return "", err
+		}
+		if len(sealed) < aead.NonceSize() {
+			return "", ErrInvalidCookie
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name+"|"+key.ID))
+		if err != nil || len(plaintext) < 8 {
+			return "", ErrInvalidCookie
+		}
+		ts = int64(binary.BigEndian.Uint64(plaintext[:8]))
+		value = string(plaintext[8:])
+
+	default:
+		return "", ErrInvalidCookie
+	}
+
+	if maxAge > 0 && c.now().Sub(time.Unix(ts, 0)) > maxAge {
+		return "", ErrCookieExpired
+	}
+	return value, nil
+}
+
+func newCookieAEAD(key CookieKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key.derive("encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// CookiePolicy holds the name and attributes applied to a cookie when it is written
+type CookiePolicy struct {
+	Name     string
+	Path     string
+	Domain   string
+	MaxAge   int
+	HttpOnly bool
+	Secure   bool
+	SameSite http.SameSite
+	// HostPrefix adds the __Host- prefix, which browsers only accept for
+	// Secure cookies on path / without a Domain attribute
+	HostPrefix bool
+	// Persistent cookies are written with MaxAge. Otherwise the cookie lasts
+	// for the browser session and MaxAge is only enforced server side.
+	Persistent bool
+}
+
+// DefaultCookiePolicy returns the secure defaults: HttpOnly, SameSite=Lax and,
+// outside development, Secure with the __Host- prefix.
+func DefaultCookiePolicy(name string, isDev bool) CookiePolicy {
+	return CookiePolicy{
+		Name:       name,
+		Path:       "/",
+		HttpOnly:   true,
+		Secure:     !isDev,
+		SameSite:   http.SameSiteLaxMode,
+		HostPrefix: !isDev,
+		Persistent: true,
+	}
+}
+
+// CookieName returns the name the cookie is stored under, including any prefix
+func (p CookiePolicy) CookieName() string {
+	if p.HostPrefix && p.Secure && p.Domain == "" && p.Path == "/" {
+		return hostCookiePrefix + p.Name
+	}
+	return p.Name
+}
+
+// Cookie builds a cookie with the policy's name and attributes
+func (p CookiePolicy) Cookie(value string) *http.Cookie {
+	c := &http.Cookie{
+		Name:     p.CookieName(),
+		Value:    value,
+		Path:     p.Path,
+		Domain:   p.Domain,
+		HttpOnly: p.HttpOnly,
+		Secure:   p.Secure,
+		SameSite: p.SameSite,
+	}
+	if p.Persistent && p.MaxAge > 0 {
+		c.MaxAge = p.MaxAge
+		c.Expires = time.Now().Add(time.Duration(p.MaxAge) * time.Second)
+	}
+	return c
+}
+
+// Set writes value to the cookie as is
+func (p CookiePolicy) Set(w http.ResponseWriter, value string) {
+	http.SetCookie(w, p.Cookie(value))
+}
+
+// Read returns the raw value of the cookie
+func (p CookiePolicy) Read(r *http.Request) (string, error) {
+	c, err := r.Cookie(p.CookieName())
+	if err != nil {
+		return "", err
+	}
+	return c.Value, nil
+}
+
+// Remove expires the cookie using the same attributes it was written with
+func (p CookiePolicy) Remove(w http.ResponseWriter) {
+	c := p.Cookie("")
+	c.MaxAge = -1
+	c.Expires = time.Time{}
+	http.SetCookie(w, c)
+}
+
+// SetSecureCookie encodes value with the codec and writes it using the policy
+func SetSecureCookie(w http.ResponseWriter, codec *CookieCodec, policy CookiePolicy, value string) error {
+	encoded, err := codec.Encode(policy.Name, value)
+	if err != nil {
+		return err
+	}
+	policy.Set(w, encoded)
+	return nil
+}
+
+// ReadSecureCookie reads and decodes a cookie written by SetSecureCookie. The
+// policy's MaxAge, if set, is also enforced on the embedded timestamp.
+func ReadSecureCookie(r *http.Request, codec *CookieCodec, policy CookiePolicy) (string, error) {
+	raw, err := policy.Read(r)
+	if err != nil {
+		return "", err
+	}
+	return codec.Decode(policy.Name, raw, time.Duration(policy.MaxAge)*time.Second)
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKeyRing(t *testing.T, keys ...CookieKey) *KeyRing {
+	t.Helper()
+	ring, err := NewKeyRing(keys...)
+	if err != nil {
+		t.Fatalf("NewKeyRing: %v", err)
+	}
+	return ring
+}
+
+var (
+	oldCookieKey = CookieKey{ID: "k1", Secret: []byte("0123456789abcdef-old")}
+	newCookieKey = CookieKey{ID: "k2", Secret: []byte("0123456789abcdef-new")}
+)
+
+// replacePart swaps one dot separated part of an encoded value
+func replacePart(encoded string, i int, part string) string {
+	parts := strings.Split(encoded, ".")
+	parts[i] = part
+	return strings.Join(parts, ".")
+}
+
+// flipMiddle changes a character in the middle of s, whose bits all count
+func flipMiddle(s string) string {
+	i := len(s) / 2
+	c := byte('A')
+	if s[i] == 'A' {
+		c = 'B'
+	}
+	return s[:i] + string(c) + s[i+1:]
+}
+
+func TestCookieCodecRoundTrip(t *testing.T) {
+	ring := testKeyRing(t, oldCookieKey)
+	for _, mode := range []CookieMode{CookieSigned, CookieEncrypted} {
+		codec := NewCookieCodec(ring, mode)
+		encoded, err := codec.Encode("session", "value.with:separators")
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		if mode == CookieEncrypted && strings.Contains(encoded, EncodeBase64UrlNoPadding([]byte("value.with:separators"))) {
+			t.Fatal("expected the encrypted value to be opaque")
+		}
+		got, err := codec.Decode("session", encoded, time.Minute)
+		if err != nil || got != "value.with:separators" {
+			t.Fatalf("mode %d: Decode = %q, %v", mode, got, err)
+		}
+	}
+}
+
+func TestCookieCodecRejectsTampering(t *testing.T) {
+	codec := NewCookieCodec(testKeyRing(t, oldCookieKey), CookieSigned)
+	signed, _ := codec.Encode("session", "user-1")
+	encrypted, _ := NewCookieCodec(testKeyRing(t, oldCookieKey), CookieEncrypted).Encode("session", "user-1")
+
+	tests := []struct {
+		name, cookie, encoded string
+	}{
+		{"signed value", "session", replacePart(signed, 3, EncodeBase64UrlNoPadding([]byte("user-2")))},
+		{"signed timestamp", "session", replacePart(signed, 2, "9999999999")},
+		{"signed mac", "session", replacePart(signed, 4, flipMiddle(strings.Split(signed, ".")[4]))},
+		{"signed other cookie", "csrf", signed},
+		{"encrypted ciphertext", "session", replacePart(encrypted, 2, flipMiddle(strings.Split(encrypted, ".")[2]))},
+		{"encrypted other cookie", "csrf", encrypted},
+		{"encrypted truncated", "session", replacePart(encrypted, 2, "AAAA")},
+		{"unknown key", "session", replacePart(signed, 1, "k9")},
+		{"unknown version", "session", replacePart(signed, 0, "s2")},
+		{"garbage", "session", "not-a-cookie"},
+		{"empty", "session", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := codec.Decode(tt.cookie, tt.encoded, 0); !errors.Is(err, ErrInvalidCookie) {
+				t.Fatalf("expected ErrInvalidCookie, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCookieCodecKeyRotation(t *testing.T) {
+	for _, mode := range []CookieMode{CookieSigned, CookieEncrypted} {
+		before := NewCookieCodec(testKeyRing(t, oldCookieKey), mode)
+		encoded, _ := before.Encode("session", "user-1")
+
+		rotated := NewCookieCodec(testKeyRing(t, newCookieKey, oldCookieKey), mode)
+		if got, err := rotated.Decode("session", encoded, 0); err != nil || got != "user-1" {
+			t.Fatalf("mode %d: expected the old key to still decode, got %q, %v", mode, got, err)
+		}
+		reissued, _ := rotated.Encode("session", "user-1")
+		if !strings.Contains(reissued, ".k2.") {
+			t.Fatalf("mode %d: expected the primary key to encode, got %s", mode, reissued)
+		}
+
+		retired := NewCookieCodec(testKeyRing(t, newCookieKey), mode)
+		if _, err := retired.Decode("session", encoded, 0); !errors.Is(err, ErrInvalidCookie) {
+			t.Fatalf("mode %d: expected a retired key to be rejected, got %v", mode, err)
+		}
+	}
+}
+
+func TestCookieCodecExpiry(t *testing.T) {
+	clock := newFakeClock()
+	for _, mode := range []CookieMode{CookieSigned, CookieEncrypted} {
+		codec := NewCookieCodec(testKeyRing(t, oldCookieKey), mode)
+		codec.now = clock.Now
+		encoded, _ := codec.Encode("session", "user-1")
+
+		clock.Advance(time.Hour)
+		if _, err := codec.Decode("session", encoded, time.Hour); err != nil {
+			t.Fatalf("mode %d: expected a value at its max age to decode, got %v", mode, err)
+		}
+		clock.Advance(time.Second)
+		if _, err := codec.Decode("session", encoded, time.Hour); !errors.Is(err, ErrCookieExpired) {
+			t.Fatalf("mode %d: expected ErrCookieExpired, got %v", mode, err)
+		}
+		if _, err := codec.Decode("session", encoded, 0); err != nil {
+			t.Fatalf("mode %d: expected a zero max age to skip the check, got %v", mode, err)
+		}
+	}
+}
+
+func TestCookieCodecModeSwitch(t *testing.T) {
+	ring := testKeyRing(t, oldCookieKey)
+	signed := NewCookieCodec(ring, CookieSigned)
+	encrypted := NewCookieCodec(ring, CookieEncrypted)
+
+	s1, _ := signed.Encode("session", "user-1")
+	e1, _ := encrypted.Encode("session", "user-1")
+	if !strings.HasPrefix(s1, "s1.") || !strings.HasPrefix(e1, "e1.") {
+		t.Fatalf("unexpected formats %s and %s", s1, e1)
+	}
+	if got, err := encrypted.Decode("session", s1, 0); err != nil || got != "user-1" {
+		t.Fatalf("expected the encrypted codec to accept signed values, got %q, %v", got, err)
+	}
+	if got, err := signed.Decode("session", e1, 0); err != nil || got != "user-1" {
+		t.Fatalf("expected the signed codec to accept encrypted values, got %q, %v", got, err)
+	}
+}
@@ -1,8 +1,10 @@
 package utils
 
 import (
-	"log"
+	"container/list"
+	"hash/maphash"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,100 +14,281 @@ type Cache interface {
 	Delete(key string)
 }
 
-// item is a struct that holds the value and the last access time
-type item struct {
-	value      interface{}
-	lastAccess int64
+const (
+	ttlMapJanitorInterval = time.Second
+	ttlMapWarningInterval = time.Hour
+)
+
+// entry is a value with the key it is stored under and when it expires
+type entry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
 }
 
-// You can have a single map for an application or few maps for different purposes
-type TTLMap struct {
-	m map[string]*item
-	// For safe access to the map
-	mu          sync.Mutex
-	name        string
-	lastWarning int64
+// ttlShard is a size bounded LRU list guarded by its own lock
+type ttlShard[K comparable, V any] struct {
+	mu      sync.Mutex
+	items   map[K]*list.Element
+	lru     *list.List
+	maxSize int
 }
 
-func NewTTLMap(size int, maxTTL time.Duration, name string) (m *TTLMap) {
-	// map is created with the given length
-	m = &TTLMap{m: make(map[string]*item, size), name: name, lastWarning: 0}
+// TTLMapStats counts the map's activity since it was created
+type TTLMapStats struct {
+	Size        int
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+}
 
-	// this goroutine will clean up the map from old items
-	go func() {
-		// You can adjust this ticker to be more or less frequent
-		for now := range time.Tick(time.Second) {
-			m.mu.Lock()
-			for k, v := range m.m {
-				if now.Unix()-v.lastAccess > int64(maxTTL.Seconds()) {
-					delete(m.m, k)
-				}
-			}
+// TTLMap is a size bounded map whose entries expire ttl after they were last
+// written. When full, the least recently used entry is evicted. You can have a
+// single map for an application or few maps for different purposes. Close
+// stops the janitor goroutine that removes expired entries.
+type TTLMap[K comparable, V any] struct {
+	name   string
+	ttl    time.Duration
+	shards []*ttlShard[K, V]
+	seed   maphash.Seed
+	now    func() time.Time
 
-			m.mu.Unlock()
-		}
-	}()
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
+	lastWarning atomic.Int64
+
+	stop chan struct{}
+	once sync.Once
+}
 
-	//check if map is over threshold every 24 hours
-	go func() {
-		for range time.Tick(time.Hour * 24) {
-			m.HandleTTLMapLimitWarning()
+// NewTTLMap creates a map holding at most maxSize entries that expire ttl
+// after they were last written
+func NewTTLMap[K comparable, V any](maxSize int, ttl time.Duration, name string) *TTLMap[K, V] {
+	return NewShardedTTLMap[K, V](1, maxSize, ttl, name)
+}
+
+// NewShardedTTLMap creates a map split into shards with their own locks and
+// LRU lists, which lowers contention under concurrent load. maxSize is divided
+// evenly between the shards, so eviction is least recently used per shard.
+func NewShardedTTLMap[K comparable, V any](shards int, maxSize int, ttl time.Duration, name string) *TTLMap[K, V] {
+	shards = max(shards, 1)
+	perShard := max((maxSize+shards-1)/shards, 1)
+
+	m := &TTLMap[K, V]{
+		name:   name,
+		ttl:    ttl,
+		shards: make([]*ttlShard[K, V], shards),
+		seed:   maphash.MakeSeed(),
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}
+	for i := range m.shards {
+		m.shards[i] = &ttlShard[K, V]{
+			items:   make(map[K]*list.Element),
+			lru:     list.New(),
+			maxSize: perShard,
 		}
-	}()
+	}
 
-	return
+	go m.janitor()
+	return m
 }
 
-func (m *TTLMap) GetName() string {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+func (m *TTLMap[K, V]) GetName() string {
 	return m.name
 }
 
-// Put adds a new item to the map or updates the existing one
-func (m *TTLMap) Put(k string, v interface{}) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// Close stops the janitor. The map stays usable, but expired entries are then
+// only removed when they are accessed or evicted.
+func (m *TTLMap[K, V]) Close() error {
+	m.once.Do(func() { close(m.stop) })
+	return nil
+}
+
+func (m *TTLMap[K, V]) janitor() {
+	ticker := time.NewTicker(ttlMapJanitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.removeExpired()
+		}
+	}
+}
+
+func (m *TTLMap[K, V]) removeExpired() {
+	now := m.now()
+	for _, s := range m.shards {
+		s.mu.Lock()
+		for k, el := range s.items {
+			if !now.Before(el.Value.(*entry[K, V]).expiresAt) {
+				s.remove(k, el)
+				m.expirations.Add(1)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
 
-	it, ok := m.m[k]
+func (m *TTLMap[K, V]) shard(k K) *ttlShard[K, V] {
+	if len(m.shards) == 1 {
+		return m.shards[0]
+	}
+	return m.shards[maphash.Comparable(m.seed, k)%uint64(len(m.shards))]
+}
+
+// lookup returns the live element of k, removing it if it has expired. The
+// shard's lock must be held.
+func (m *TTLMap[K, V]) lookup(s *ttlShard[K, V], k K) (*list.Element, bool) {
+	el, ok := s.items[k]
 	if !ok {
-		it = &item{}
-		m.m[k] = it
+		return nil, false
+	}
+	if !m.now().Before(el.Value.(*entry[K, V]).expiresAt) {
+		s.remove(k, el)
+		m.expirations.Add(1)
+		return nil, false
+	}
+	return el, true
+}
+
+// set stores v under k and evicts the least recently used entries beyond the
+// shard's size. The shard's lock must be held.
+func (m *TTLMap[K, V]) set(s *ttlShard[K, V], k K, v V, ttl time.Duration) {
+	expiresAt := m.now().Add(ttl)
+	if el, ok := s.items[k]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value, e.expiresAt = v, expiresAt
+		s.lru.MoveToFront(el)
+		return
 	}
-	it.value = v
-	it.lastAccess = time.Now().Unix()
+
+	s.items[k] = s.lru.PushFront(&entry[K, V]{key: k, value: v, expiresAt: expiresAt})
+	for len(s.items) > s.maxSize {
+		oldest := s.lru.Back()
+		s.remove(oldest.Value.(*entry[K, V]).key, oldest)
+		m.evictions.Add(1)
+		m.warnEviction()
+	}
+}
+
+func (s *ttlShard[K, V]) remove(k K, el *list.Element) {
+	s.lru.Remove(el)
+	delete(s.items, k)
+}
+
+// warnEviction logs at most once per hour that live entries are being evicted
+// because the map is full
+func (m *TTLMap[K, V]) warnEviction() {
+	now := time.Now().UnixMilli()
+	last := m.lastWarning.Load()
+	if now-last < ttlMapWarningInterval.Milliseconds() || !m.lastWarning.CompareAndSwap(last, now) {
+		return
+	}
+	logger.Warn("TTLMap %s is full, evicting least recently used entries", m.name)
+}
+
+// Put adds a new item to the map or updates the existing one
+func (m *TTLMap[K, V]) Put(k K, v V) {
+	m.PutWithTTL(k, v, m.ttl)
+}
+
+// PutWithTTL is Put with an expiry other than the map's default
+func (m *TTLMap[K, V]) PutWithTTL(k K, v V, ttl time.Duration) {
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.set(s, k, v, ttl)
 }
 
 // Get returns the value of the given key if it exists
-func (m *TTLMap) Get(k string) (interface{}, bool) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+func (m *TTLMap[K, V]) Get(k K) (V, bool) {
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := m.lookup(s, k)
+	if !ok {
+		m.misses.Add(1)
+		var zero V
+		return zero, false
+	}
+	m.hits.Add(1)
+	s.lru.MoveToFront(el)
+	return el.Value.(*entry[K, V]).value, true
+}
+
+// Take returns the value of the given key and removes it in a single step
+func (m *TTLMap[K, V]) Take(k K) (V, bool) {
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if it, ok := m.m[k]; ok {
-		it.lastAccess = time.Now().Unix()
-		return it.value, true
+	el, ok := m.lookup(s, k)
+	if !ok {
+		m.misses.Add(1)
+		var zero V
+		return zero, false
 	}
+	m.hits.Add(1)
+	s.remove(k, el)
+	return el.Value.(*entry[K, V]).value, true
+}
 
-	return nil, false
+// Update replaces the value of the given key with fn(value, ok) while holding
+// the lock, so concurrent updates of the same key never interleave
+func (m *TTLMap[K, V]) Update(k K, fn func(v V, ok bool) V) V {
+	return m.UpdateWithTTL(k, m.ttl, fn)
+}
+
+// UpdateWithTTL is Update with an expiry other than the map's default
+func (m *TTLMap[K, V]) UpdateWithTTL(k K, ttl time.Duration, fn func(v V, ok bool) V) V {
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current V
+	el, ok := m.lookup(s, k)
+	if ok {
+		current = el.Value.(*entry[K, V]).value
+	}
+	next := fn(current, ok)
+	m.set(s, k, next, ttl)
+	return next
 }
 
 // Delete removes the item from the map
-func (m *TTLMap) Delete(k string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	delete(m.m, k)
-}
-
-func (m *TTLMap) HandleTTLMapLimitWarning() {
-	m.mu.Lock()
-	//if map is over threshold, log warning
-	warningThreshold := 1000
-	// every 24 hours, log warning
-	warningWindow := (time.Hour * 24).Milliseconds()
-	count := len(m.m)
-	if count > warningThreshold && (time.Now().UnixMilli()-m.lastWarning) > warningWindow {
-		log.Println("TLLMap "+m.name+" size of ", count, " is above warning threshold of ", warningThreshold, ".")
-		m.lastWarning = time.Now().UnixMilli()
-	}
-	m.mu.Unlock()
+func (m *TTLMap[K, V]) Delete(k K) {
+	s := m.shard(k)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[k]; ok {
+		s.remove(k, el)
+	}
+}
+
+// Len returns the number of entries, including expired ones not yet removed
+func (m *TTLMap[K, V]) Len() int {
+	n := 0
+	for _, s := range m.shards {
+		s.mu.Lock()
+		n += len(s.items)
+		s.mu.Unlock()
+	}
+	return n
+}
+
+func (m *TTLMap[K, V]) Stats() TTLMapStats {
+	return TTLMapStats{
+		Size:        m.Len(),
+		Hits:        m.hits.Load(),
+		Misses:      m.misses.Load(),
+		Evictions:   m.evictions.Load(),
+		Expirations: m.expirations.Load(),
+	}
 }
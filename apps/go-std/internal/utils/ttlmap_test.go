@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTTLMapEvictsLeastRecentlyUsed(t *testing.T) {
+	m := NewTTLMap[string, int](2, time.Minute, "test")
+	defer m.Close()
+
+	m.Put("a", 1)
+	m.Put("b", 2)
+	m.Get("a")
+	m.Put("c", 3)
+
+	if _, ok := m.Get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+	if v, ok := m.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected a to survive, got %v %v", v, ok)
+	}
+	if s := m.Stats(); s.Size != 2 || s.Evictions != 1 || s.Hits != 2 || s.Misses != 1 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestTTLMapPerEntryTTL(t *testing.T) {
+	clock := newFakeClock()
+	m := NewTTLMap[string, string](10, time.Minute, "test")
+	m.now = clock.Now
+	defer m.Close()
+
+	m.Put("long", "v")
+	m.PutWithTTL("short", "v", time.Second)
+
+	clock.Advance(time.Second)
+	if _, ok := m.Get("short"); ok {
+		t.Fatal("expected short to expire")
+	}
+	if _, ok := m.Get("long"); !ok {
+		t.Fatal("expected long to be live")
+	}
+
+	clock.Advance(time.Minute)
+	m.removeExpired()
+	if s := m.Stats(); s.Size != 0 || s.Expirations != 2 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+func TestShardedTTLMapIsBounded(t *testing.T) {
+	m := NewShardedTTLMap[string, int](8, 64, time.Minute, "test")
+	defer m.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				m.Update("k"+strconv.Itoa(g*100+i), func(v int, ok bool) int { return v + 1 })
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if n := m.Len(); n > 64 {
+		t.Fatalf("map holds %d entries, limit is 64", n)
+	}
+	if s := m.Stats(); uint64(s.Size)+s.Evictions != 800 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
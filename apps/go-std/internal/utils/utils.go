@@ -4,6 +4,8 @@ import (
 	"crypto/rand"
 	"encoding/base32"
 	"encoding/base64"
+	"net"
+	"net/http"
 )
 
 func GenerateRandomString() (string, error) {
@@ -34,3 +36,12 @@ func GenerateRandomStringNoPadding() (string, error) {
 	code := base32.NewEncoding("ABCDEFGHJKLMNPQRSTUVWXYZ23456789").WithPadding(base32.NoPadding).EncodeToString(bytes)
 	return code, nil
 }
+
+// RemoteIP returns the IP of the request's direct peer
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -6,6 +6,7 @@ import (
 	"go-std/internal/utils"
 	"log"
 	"net/http"
+	"time"
 )
 
 func main() {
@@ -13,16 +14,19 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	csrfToken, csrfTokenHMAC := utils.GenerateCSRFToken(sessionId)
-	log.Println("csrfToken: ", csrfToken)
-	log.Println("csrfTokenHMAC: ", csrfTokenHMAC)
+	csrfKeys, err := utils.EphemeralKeyRing()
+	if err != nil {
+		log.Fatal(err)
+	}
+	csrfSigner := utils.NewCSRFSigner(csrfKeys)
+	csrfToken, csrfTokenHMAC := csrfSigner.Generate(sessionId)
 	code, err := utils.GenerateRandomString()
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.Println(code)
 
-	valid := utils.VerifyCSRFToken(csrfToken, sessionId, csrfTokenHMAC)
+	valid := csrfSigner.Verify(csrfToken, sessionId, csrfTokenHMAC)
 	log.Println("valid: ", valid)
 	router := http.NewServeMux()
 	router.HandleFunc("/item/{id}", func(w http.ResponseWriter, r *http.Request) {
@@ -35,21 +39,31 @@ func main() {
 	})
 
 	stack := middleware.CreateStack(
-		middleware.CORS,
+		middleware.NewCORS(middleware.CORSPolicy{
+			AllowedOrigins:   []string{"http://localhost:8080", "http://localhost:3001"},
+			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowedHeaders:   []string{"Content-Type", "X-CSRF-Token"},
+			ExposedHeaders:   []string{"X-CSRF-Token"},
+			AllowCredentials: true,
+		}),
 	)
 
 	router.HandleFunc("GET /test-cors", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("CORS test successful"))
 	})
 
-	router.HandleFunc("GET /test-rate", func(w http.ResponseWriter, r *http.Request) {
+	testRateLimit := middleware.RateLimit(
+		middleware.NewRateLimitPolicy("test-rate", 5, time.Second*10, middleware.KeyByIP),
+	)
+
+	router.HandleFunc("GET /test-rate", testRateLimit(func(w http.ResponseWriter, r *http.Request) {
 
 		w.Header().Set("Content-Type", "application/json")
 		response := map[string]interface{}{
 			"message": "Rate limit test successful",
 		}
 		json.NewEncoder(w).Encode(response)
-	})
+	}))
 
 	server := http.Server{
 		Addr:    ":8080",
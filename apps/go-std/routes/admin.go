@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"go-std/internal/config"
+	"go-std/internal/middleware"
+	"go-std/internal/utils"
+	"log"
+	"net/http"
+
+	"github.com/g-h-miles/httpmux"
+)
+
+// AdminRoutes registers the admin endpoints. They are only reachable from the
+// IPs in IP_ALLOW_ADMIN, or from any IP with ADMIN_ALLOW_ANY_IP=true, and only
+// for the users in ADMIN_EMAILS.
+func AdminRoutes(mux *httpmux.Router, app *config.App) {
+
+	r := mux
+
+	m := middleware.NewMiddlewareContext(app)
+	filter, err := m.IPFilter("admin")
+	if err != nil {
+		log.Fatalf("failed to create admin IP filter: %v", err)
+	}
+	access := middleware.IPAccess(filter)
+	if len(filter.Allow) == 0 {
+		if anyIP, _ := app.Env.GetBool("ADMIN_ALLOW_ANY_IP"); anyIP {
+			log.Println("ADMIN_ALLOW_ANY_IP is set, admin endpoints are reachable from any IP")
+		} else {
+			log.Println("IP_ALLOW_ADMIN is not set, admin endpoints are disabled. Set ADMIN_ALLOW_ANY_IP=true to allow any IP")
+			access = denyAll
+		}
+	}
+	admin := middleware.CreateStack(m.ResolveClientIP, access, m.RequireAdmin, m.CSRFMiddleware)
+
+	r.GET("/api/admin/ip-bans", admin(ListIPBansHandler(app)))
+	r.DELETE("/api/admin/ip-bans/{ip}", admin(LiftIPBanHandler(app)))
+}
+
+// ListIPBansHandler lists the active temporary IP bans
+func ListIPBansHandler(app *config.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.SuccessResponse(w, app.IPBans.List())
+	}
+}
+
+// LiftIPBanHandler lifts the ban of the IP in the path
+func LiftIPBanHandler(app *config.App) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := r.PathValue("ip")
+		if !app.IPBans.Lift(ip) {
+			utils.ErrorResponse(w, http.StatusNotFound, "IP is not banned", "NOT_FOUND")
+			return
+		}
+		utils.SuccessResponse(w, map[string]interface{}{"ip": ip, "lifted": true})
+	}
+}
+
+// denyAll rejects every request, for admin endpoints without an IP allow list
+func denyAll(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		utils.ErrorResponse(w, http.StatusForbidden, "Forbidden", "IP_FORBIDDEN")
+	}
+}
@@ -3,65 +3,123 @@ package routes
 import (
 	"go-std/internal/auth"
 	"go-std/internal/config"
+	"go-std/internal/middleware"
 	"go-std/internal/utils"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/g-h-miles/httpmux"
 )
 
-func AuthRoutes(mux *httpmux.Router, app *config.App) {
+// AuthRoutes registers the auth endpoints and returns their handlers. The
+// caller runs the handlers' account purger for as long as the server runs.
+func AuthRoutes(mux *httpmux.Router, app *config.App, opts ...auth.Option) *auth.AuthHandlers {
 
 	r := mux
 
-	a, err := auth.NewAuthHandlers(app)
+	a, err := auth.NewAuthHandlers(app, opts...)
 	if err != nil {
 		log.Fatalf("failed to create auth handlers: %v", err)
 	}
 
+	m := middleware.NewMiddlewareContext(app)
+	freshAuth := m.RequireFreshAuth(m.FreshAuthMaxAge())
+	limits := authRateLimits(m)
+	authGroup := middleware.CreateStack(m.ResolveClientIP, m.BlockBannedIPs, middleware.ConcurrencyLimit(authConcurrency(m)))
+
 	//todo: move to root
 	r.GET("/{$}", DummyHandler)
-	r.GET("/api/auth/login", a.LoginHandler)
-	r.GET("/api/auth/login/{provider}", a.LoginHandler)
-	r.GET("/api/auth/callback", a.CallbackHandler)
-	r.GET("/api/auth/callback/{provider}", a.CallbackHandler)
-	r.GET("/api/auth/validate", a.ValidateSessionHandler)
-	r.POST("/api/auth/refresh", a.RefreshTokenHandler)
-	r.GET("/api/auth/logout", a.LogoutHandler) //todo: change to POST
-	r.GET("/api/auth/csrf", a.GetCSRFTokenHandler)
-	r.GET("/api/auth/user", a.GetUserHandler)
-	r.POST("/api/auth/user", a.UpdateUserHandler)
-	r.DELETE("/api/auth/user", a.DeleteUserHandler)
-	r.GET("/api/auth/sessions", a.GetUserSessionsHandler)
+	r.GET("/api/auth/login", authGroup(limits.login(a.LoginHandler)))
+	r.GET("/api/auth/login/{provider}", authGroup(limits.login(a.LoginHandler)))
+	r.GET("/api/auth/callback", authGroup(limits.callback(a.CallbackHandler)))
+	r.GET("/api/auth/callback/{provider}", authGroup(limits.callback(a.CallbackHandler)))
+	r.GET("/api/auth/validate", authGroup(a.ValidateSessionHandler))
+	r.POST("/api/auth/refresh", authGroup(limits.refresh(a.RefreshTokenHandler)))
+	r.GET("/api/auth/logout", authGroup(a.LogoutHandler)) //todo: change to POST
+	r.GET("/api/auth/csrf", authGroup(limits.csrf(a.GetCSRFTokenHandler)))
+	r.GET("/api/auth/csrf/token", authGroup(limits.csrf(a.GetCSRFTokenJSONHandler)))
+	r.GET("/api/auth/user", authGroup(a.GetUserHandler))
+	r.POST("/api/auth/user", authGroup(freshAuth(a.UpdateUserHandler)))
+	r.DELETE("/api/auth/user", authGroup(freshAuth(a.DeleteUserHandler)))
+	r.GET("/api/auth/user/export", authGroup(a.ExportUserDataHandler))
+	r.GET("/api/auth/sessions", authGroup(a.GetUserSessionsHandler))
+
+	return a
+}
 
+type authRateLimitSet struct {
+	login    middleware.Middleware
+	callback middleware.Middleware
+	refresh  middleware.Middleware
+	csrf     middleware.Middleware
+}
+
+// authRateLimits are the default rate limits of the auth endpoints. Each can be
+// changed with RATE_LIMIT_<NAME>, e.g. RATE_LIMIT_AUTH_LOGIN=10/1m.
+func authRateLimits(m *middleware.MiddlewareContext) authRateLimitSet {
+	return authRateLimitSet{
+		login: middleware.RateLimit(
+			m.RateLimitPolicy("auth-login", 10, time.Minute, middleware.KeyByIP),
+		),
+		callback: middleware.RateLimit(
+			m.RateLimitPolicy("auth-callback", 20, time.Minute, middleware.KeyByIP),
+		),
+		refresh: middleware.RateLimit(
+			m.RateLimitPolicy("auth-refresh", 30, time.Minute, m.KeyBySessionUser),
+			m.RateLimitPolicy("auth-refresh-ip", 120, time.Minute, middleware.KeyByIP),
+		),
+		csrf: middleware.RateLimit(
+			m.RateLimitPolicy("auth-csrf", 60, time.Minute, middleware.KeyByRouteAndIP),
+		),
+	}
+}
+
+// authConcurrency caps the auth endpoints, which all wait on Postgres, so a slow
+// database sheds load instead of queueing every request on the pool. It can be
+// changed with CONCURRENCY_AUTH, e.g. CONCURRENCY_AUTH=32/64/2s.
+func authConcurrency(m *middleware.MiddlewareContext) *middleware.ConcurrencyLimiter {
+	return m.ConcurrencyLimiter("auth", middleware.ConcurrencyOptions{
+		Limit:        32,
+		MaxQueue:     64,
+		QueueTimeout: time.Second * 2,
+	})
 }
 
 func DummyHandler(w http.ResponseWriter, r *http.Request) {
 	utils.SuccessResponse(w, "dummy handler")
 }
 
-func AuthRoutesStd(mux *httpmux.Router, app *config.App) {
+func AuthRoutesStd(mux *httpmux.Router, app *config.App, opts ...auth.Option) *auth.AuthHandlers {
 
 	r := mux
 
-	a, err := auth.NewAuthHandlers(app)
+	a, err := auth.NewAuthHandlers(app, opts...)
 	if err != nil {
 		log.Fatalf("failed to create auth handlers: %v", err)
 	}
 
+	m := middleware.NewMiddlewareContext(app)
+	freshAuth := m.RequireFreshAuth(m.FreshAuthMaxAge())
+	limits := authRateLimits(m)
+	authGroup := middleware.CreateStack(m.ResolveClientIP, m.BlockBannedIPs, middleware.ConcurrencyLimit(authConcurrency(m)))
+
 	//todo: move to root
 	r.GET("/{$}", DummyHandler)
-	r.GET("/api/auth/login", a.LoginHandler)
-	r.GET("/api/auth/login/{provider}", a.LoginHandler)
-	r.GET("/api/auth/callback", a.CallbackHandler)
-	r.GET("/api/auth/callback/{provider}", a.CallbackHandler)
-	r.GET("/api/auth/validate", a.ValidateSessionHandler)
-	r.POST("/api/auth/refresh", a.RefreshTokenHandler)
-	r.GET("/api/auth/logout", a.LogoutHandler)
-	r.GET("/api/auth/csrf", a.GetCSRFTokenHandler)
-	r.GET("/api/auth/user", a.GetUserHandler)
-	r.POST("/api/auth/user", a.UpdateUserHandler)
-	r.DELETE("/api/auth/user", a.DeleteUserHandler)
-	r.GET("/api/auth/sessions", a.GetUserSessionsHandler)
+	r.GET("/api/auth/login", authGroup(limits.login(a.LoginHandler)))
+	r.GET("/api/auth/login/{provider}", authGroup(limits.login(a.LoginHandler)))
+	r.GET("/api/auth/callback", authGroup(limits.callback(a.CallbackHandler)))
+	r.GET("/api/auth/callback/{provider}", authGroup(limits.callback(a.CallbackHandler)))
+	r.GET("/api/auth/validate", authGroup(a.ValidateSessionHandler))
+	r.POST("/api/auth/refresh", authGroup(limits.refresh(a.RefreshTokenHandler)))
+	r.GET("/api/auth/logout", authGroup(a.LogoutHandler))
+	r.GET("/api/auth/csrf", authGroup(limits.csrf(a.GetCSRFTokenHandler)))
+	r.GET("/api/auth/csrf/token", authGroup(limits.csrf(a.GetCSRFTokenJSONHandler)))
+	r.GET("/api/auth/user", authGroup(a.GetUserHandler))
+	r.POST("/api/auth/user", authGroup(freshAuth(a.UpdateUserHandler)))
+	r.DELETE("/api/auth/user", authGroup(freshAuth(a.DeleteUserHandler)))
+	r.GET("/api/auth/user/export", authGroup(a.ExportUserDataHandler))
+	r.GET("/api/auth/sessions", authGroup(a.GetUserSessionsHandler))
 
+	return a
 }
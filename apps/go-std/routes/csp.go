@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"errors"
+	"go-std/internal/config"
+	"go-std/internal/middleware"
+	"go-std/internal/utils"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/g-h-miles/httpmux"
+)
+
+// maxCSPReportSize bounds report bodies, Reporting API batches included
+const maxCSPReportSize = 64 << 10
+
+// CSPReportRoutes registers the endpoint browsers send CSP violation reports
+// to. Reports are logged once per CSP_REPORT_DEDUPE_WINDOW (default 1h) per
+// violation. The caller closes the returned collector on shutdown.
+func CSPReportRoutes(mux *httpmux.Router, app *config.App) *utils.CSPReportCollector {
+
+	r := mux
+
+	window := time.Hour
+	if v := app.Env.GetString("CSP_REPORT_DEDUPE_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid CSP_REPORT_DEDUPE_WINDOW %q", v)
+		}
+		window = d
+	}
+	collector := utils.NewCSPReportCollector(window, logCSPViolation)
+
+	m := middleware.NewMiddlewareContext(app)
+	// report-uri sends one report per violation, so a single page load can
+	// send dozens. The collector deduplicates them, the limit only stops floods.
+	limit := middleware.RateLimit(m.RateLimitPolicy("csp-report", 600, time.Minute, middleware.KeyByIP))
+
+	r.POST(middleware.CSPReportPath, middleware.CreateStack(m.ResolveClientIP, limit)(CSPReportHandler(collector)))
+	return collector
+}
+
+// CSPReportHandler accepts legacy application/csp-report bodies and Reporting
+// API application/reports+json batches
+func CSPReportHandler(collector *utils.CSPReportCollector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCSPReportSize))
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusRequestEntityTooLarge, "Report too large", "PAYLOAD_TOO_LARGE")
+			return
+		}
+		violations, err := utils.ParseCSPReports(r.Header.Get("Content-Type"), body)
+		if errors.Is(err, utils.ErrUnsupportedCSPReport) {
+			utils.ErrorResponse(w, http.StatusUnsupportedMediaType, err.Error(), "UNSUPPORTED_MEDIA_TYPE")
+			return
+		}
+		if err != nil {
+			utils.ErrorResponse(w, http.StatusBadRequest, "Invalid CSP report", "BAD_REQUEST")
+			return
+		}
+		for _, v := range violations {
+			collector.Collect(v)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func logCSPViolation(v utils.CSPViolation, duplicates int) {
+	disposition := v.Disposition
+	if disposition == "" {
+		disposition = "enforce"
+	}
+	// Every field comes from the browser's report, so quote them to keep
+	// control characters and fake log lines out of the log
+	log.Printf("CSP violation (%s): %q blocked %q on %q at %q:%d:%d, %d duplicates in the last window",
+		disposition, v.EffectiveDirective, v.BlockedURL, v.DocumentURL, v.SourceFile, v.LineNumber, v.ColumnNumber, duplicates)
+}
@@ -5,11 +5,16 @@ import (
 	"go-std/internal/config"
 
 	"context"
+	"expvar"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"go-std/internal/middleware"
 	"go-std/internal/sqlc"
@@ -21,6 +26,10 @@ import (
 )
 
 func main() {
+	// Stops the server and background jobs on Ctrl+C or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	env, _ := config.Config()
 
 	log.Println("env db url", env.GetString("DATABASE_URL"))
@@ -48,25 +57,52 @@ func main() {
 		Queries: queries,
 		Env:     env,
 	}
+	if err := app.OpenCache(); err != nil {
+		log.Fatalf("failed to open cache: %v", err)
+	}
+	defer app.CloseCache()
 
 	mux := httpmux.NewServeMux()
 	// mux := http.NewServeMux()
-	routes.AuthRoutes(mux, app)
-
-	middlewareStack := middleware.CreateStack(middleware.CORS, middleware.CSPMiddleware(isDev))
+	// One registry, so every route set shares the providers' circuit breakers
+	providers, err := auth.NewProviderRegistry(app)
+	if err != nil {
+		log.Fatalf("failed to create provider registry: %v", err)
+	}
+	authHandlers := routes.AuthRoutes(mux, app, auth.WithProviderRegistry(providers))
+	defer authHandlers.Close()
+	go authHandlers.RunAccountPurger(ctx)
+	routes.AdminRoutes(mux, app)
+	cspReports := routes.CSPReportRoutes(mux, app)
+	defer cspReports.Close()
 
 	middlewareContext := middleware.NewMiddlewareContext(app)
-	protected := middlewareContext.Protected
-
-	authHandlers, err := auth.NewAuthHandlers(app)
+	corsMiddleware, err := middlewareContext.CORS(
+		middleware.CORSGroup{Name: "auth", PathPrefix: "/api/auth/"},
+		middleware.CORSGroup{Name: "admin", PathPrefix: "/api/admin/"},
+	)
+	if err != nil {
+		log.Fatalf("failed to create CORS middleware: %v", err)
+	}
+	securityHeaders, err := middlewareContext.SecurityHeaders()
 	if err != nil {
-		log.Fatalf("failed to create auth handlers: %v", err)
+		log.Fatalf("failed to read security headers: %v", err)
 	}
+	middlewareStack := middleware.CreateStack(corsMiddleware, securityHeaders.Middleware())
+
+	protected := middlewareContext.Protected
+	// Caps every request, the route groups have tighter limits of their own
+	global := middleware.ConcurrencyLimit(middlewareContext.ConcurrencyLimiter("global", middleware.ConcurrencyOptions{
+		Limit:        256,
+		MaxQueue:     512,
+		QueueTimeout: time.Second * 5,
+	}))
+	csrf := middlewareContext.CSRFMiddleware
 
 	mux.HandleFunc("GET", "/api/auth/protected", protected(someProtectedHandler))
 
-	mux.HandleFunc("GET", "/api/auth/test-form", middlewareStack(middleware.CSRFMiddleware(authHandlers.TestFormHandler)))
-	mux.HandleFunc("GET", "/api/auth/csrf-protected", middlewareStack(middleware.CSRFMiddleware((someCSRFHandler))))
+	mux.HandleFunc("GET", "/api/auth/test-form", middlewareStack(csrf(authHandlers.TestFormHandler)))
+	mux.HandleFunc("GET", "/api/auth/csrf-protected", middlewareStack(csrf(someCSRFHandler)))
 	mux.HandleFunc("GET", "/testing/{wow...}", someProtectedHandler)
 
 	withMethodMiddleware := protectedMiddleware(mux, []string{"POST"}, testLogMiddleware)
@@ -77,9 +113,31 @@ func main() {
 	}
 	portStr := strconv.Itoa(port)
 
+	// Metrics such as concurrency queue depth and rejects are served on a
+	// separate, private address
+	if metricsAddr := env.GetString("METRICS_ADDR"); metricsAddr != "" {
+		go func() {
+			log.Printf("Serving metrics on %s/debug/vars", metricsAddr)
+			if err := http.ListenAndServe(metricsAddr, expvar.Handler()); err != nil {
+				log.Printf("metrics server stopped: %v", err)
+			}
+		}()
+	}
+
 	log.Printf("Starting server on port %s (Dev Mode: %t)...", portStr, isDev)
-	err = http.ListenAndServe(":"+portStr, middlewareStack(withMethodMiddleware.ServeHTTP))
-	if err != nil {
+	server := &http.Server{
+		Addr:    ":" + portStr,
+		Handler: middlewareContext.ResolveClientIP(global(middlewareStack(withMethodMiddleware.ServeHTTP))),
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("server shutdown: %v", err)
+		}
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
 
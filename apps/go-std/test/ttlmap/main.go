@@ -8,8 +8,10 @@ import (
 )
 
 func main() {
-	m := utils.NewTTLMap(100, time.Second*100, "test-map-1")
-	g := utils.NewTTLMap(5, time.Second*100, "test-map-2")
+	m := utils.NewTTLMap[string, string](100, time.Second*100, "test-map-1")
+	g := utils.NewTTLMap[string, string](5, time.Second*100, "test-map-2")
+	defer m.Close()
+	defer g.Close()
 
 	m.Put("test", "test")
 	value, ok := m.Get("test")
@@ -24,31 +26,26 @@ func main() {
 	}
 	log.Println(value)
 
-	// Add multiple items to trigger the warning
-	m.Put("test2", "test2")
-	m.Put("test3", "test3")
-	m.Put("test4", "test4")
-	m.Put("test5", "test5")
-	m.Put("test6", "test6")
-	m.Put("test7", "test7")
-	m.Put("test8", "test8")
-	m.Put("test9", "test9")
-
-	// Wait to see both the TTL cleanup and the warning
-	time.Sleep(15 * time.Second)
+	// Add more items than g holds to trigger eviction of the oldest
+	for _, k := range []string{"test1", "test2", "test3", "test4", "test5", "test6", "test7"} {
+		g.Put(k, k)
+	}
+	if _, ok = g.Get("test1"); !ok {
+		log.Println("test1 evicted- test passed")
+	}
 
-	g.Put("g-test", "g-test")
-	value, ok = m.Get("test")
-	if !ok {
-		log.Println("Value not found")
+	// Entries with a short TTL are removed by the janitor
+	m.PutWithTTL("short", "short", time.Second)
+	time.Sleep(3 * time.Second)
+	if _, ok = m.Get("short"); !ok {
+		log.Println("short expired- test passed")
 	}
-	log.Println(value)
-	log.Println("Map 1 name:", m.GetName())
 
-	value, ok = g.Get("g-test")
+	value, ok = m.Get("test")
 	if !ok {
 		log.Println("Value not found")
 	}
 	log.Println(value)
-	log.Println("Map 2 name:", g.GetName())
+	log.Println("Map 1 name:", m.GetName(), "stats:", m.Stats())
+	log.Println("Map 2 name:", g.GetName(), "stats:", g.Stats())
 }
@@ -1,15 +1,91 @@
 package middleware
 
 import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 )
 
+// SetupCORS reads the CORS policy from the environment, using the same keys as
+// the go-std app:
+//
+//	CORS_ALLOWED_ORIGINS    comma separated origins or https://*.example.com patterns, * for any origin, defaults to http://localhost:3002
+//	CORS_ALLOWED_METHODS    defaults to fiber's default methods
+//	CORS_ALLOWED_HEADERS    defaults to *
+//	CORS_EXPOSED_HEADERS    defaults to Set-Cookie
+//	CORS_MAX_AGE            how long preflights are cached, as a Go duration
+//	CORS_ALLOW_CREDENTIALS  defaults to true
 func SetupCORS() fiber.Handler {
-	return cors.New(cors.Config{
-		AllowOrigins:     "http://localhost:3002",
-		AllowHeaders:     "*",
-		AllowCredentials: true,
-		ExposeHeaders:    "Set-Cookie",
-	})
+	origins := envList("CORS_ALLOWED_ORIGINS", "http://localhost:3002")
+	allowCredentials, err := strconv.ParseBool(envOr("CORS_ALLOW_CREDENTIALS", "true"))
+	if err != nil {
+		log.Fatalf("invalid CORS_ALLOW_CREDENTIALS %q", os.Getenv("CORS_ALLOW_CREDENTIALS"))
+	}
+	for _, origin := range origins {
+		if origin == "*" && allowCredentials {
+			log.Fatalf("CORS_ALLOWED_ORIGINS * cannot be combined with CORS_ALLOW_CREDENTIALS")
+		}
+	}
+
+	config := cors.Config{
+		AllowOriginsFunc: func(origin string) bool {
+			origin = strings.ToLower(origin)
+			for _, allowed := range origins {
+				if matchOrigin(strings.ToLower(allowed), origin) {
+					return true
+				}
+			}
+			return false
+		},
+		AllowMethods:     os.Getenv("CORS_ALLOWED_METHODS"),
+		AllowHeaders:     envOr("CORS_ALLOWED_HEADERS", "*"),
+		ExposeHeaders:    envOr("CORS_EXPOSED_HEADERS", "Set-Cookie"),
+		AllowCredentials: allowCredentials,
+	}
+	if v := os.Getenv("CORS_MAX_AGE"); v != "" {
+		maxAge, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("invalid CORS_MAX_AGE %q", v)
+		}
+		config.MaxAge = int(maxAge / time.Second)
+	}
+	return cors.New(config)
+}
+
+// matchOrigin matches an exact origin or a pattern like https://*.example.com,
+// which matches any subdomain but not example.com itself. "*" matches any origin.
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" || pattern == origin {
+		return true
+	}
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	if !ok || len(origin) <= len(prefix)+len(suffix) {
+		return false
+	}
+	if !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	return !strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:@")
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envList(key, fallback string) []string {
+	var list []string
+	for _, item := range strings.Split(envOr(key, fallback), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
 }